@@ -0,0 +1,661 @@
+// Package redis provides an adapter which broadcasts the packets to the other Socket.IO servers of a cluster through
+// the Redis Pub/Sub mechanism.
+//
+// The channels and the messages are compatible with the socket.io-redis-adapter package, so Go and Node.js servers
+// can be part of the same cluster.
+package redis
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/zishang520/engine.io/log"
+	"github.com/zishang520/engine.io/utils"
+	"github.com/zishang520/socket.io/parser"
+	"github.com/zishang520/socket.io/socket"
+)
+
+var redis_log = log.NewLog("socket.io-redis")
+
+type ackRequest struct {
+	clientsCallback func(*socket.BroadcastClients)
+	ack             func(*socket.BroadcastAck)
+}
+
+type fetchRequest struct {
+	numSub   int64
+	msgCount int64
+	sockets  []any
+	done     chan struct{}
+
+	mu sync.Mutex
+}
+
+type serverSideEmitRequest struct {
+	numSub    int64
+	msgCount  int64
+	responses []any
+	timeout   *utils.Timer
+	ack       func(error, []any)
+
+	mu sync.Mutex
+}
+
+type redisAdapter struct {
+	socket.Adapter
+
+	pubClient *Client
+	subClient *Client
+	opts      *RedisAdapterOptions
+
+	uid                     string
+	channel                 string
+	requestChannel          string
+	responseChannel         string
+	specificResponseChannel string
+	requests                *sync.Map
+	ackRequests             *sync.Map
+
+	_broadcast func(*parser.Packet, *socket.BroadcastOptions) error
+
+	mu sync.RWMutex
+}
+
+// Returns a Redis adapter, to be used with Server.SetAdapter() or ServerOptions.SetAdapter().
+//
+// The subClient is dedicated to the subscriptions, while the pubClient sends the other commands. The same client can
+// be used for both.
+//
+// <pre><code>
+//
+//	pubClient, _ := redis.NewClient("localhost:6379")
+//	subClient, _ := redis.NewClient("localhost:6379")
+//
+//	opts := socket.DefaultServerOptions()
+//	opts.SetAdapter(redis.NewRedisAdapter(pubClient, subClient, nil))
+//	io := socket.NewServer(nil, opts)
+//
+// </pre></code>
+func NewRedisAdapter(pubClient *Client, subClient *Client, opts *RedisAdapterOptions) socket.Adapter {
+	if opts == nil {
+		opts = DefaultRedisAdapterOptions()
+	}
+	return &redisAdapter{
+		pubClient: pubClient,
+		subClient: subClient,
+		opts:      opts,
+	}
+}
+
+func (r *redisAdapter) New(nsp socket.NamespaceInterface) socket.Adapter {
+	a := &redisAdapter{}
+	a.Adapter = socket.NewAdapter(nsp)
+	a.pubClient = r.pubClient
+	a.subClient = r.subClient
+	a.opts = r.opts
+	a.uid, _ = utils.Base64Id().GenerateId()
+	a.requests = &sync.Map{}
+	a.ackRequests = &sync.Map{}
+
+	prefix := a.opts.Key()
+	a.channel = prefix + "#" + nsp.Name() + "#"
+	a.requestChannel = prefix + "-request#" + nsp.Name() + "#"
+	a.responseChannel = prefix + "-response#" + nsp.Name() + "#"
+	a.specificResponseChannel = a.responseChannel + a.uid + "#"
+
+	if err := a.subClient.PSubscribe(a.onmessage, a.channel+"*"); err != nil {
+		redis_log.Error("failed to subscribe to %s: %v", a.channel+"*", err)
+	}
+	if err := a.subClient.Subscribe(a.onrequest, a.requestChannel, a.responseChannel, a.specificResponseChannel); err != nil {
+		redis_log.Error("failed to subscribe to %s: %v", a.requestChannel, err)
+	}
+
+	return a
+}
+
+func (a *redisAdapter) Close() {
+	a.subClient.PUnsubscribe(a.channel + "*")
+	a.subClient.Unsubscribe(a.requestChannel, a.responseChannel, a.specificResponseChannel)
+	a.Adapter.Close()
+}
+
+// Returns the number of Socket.IO servers in the cluster
+func (a *redisAdapter) ServerCount() int64 {
+	count, err := a.pubClient.NumSub(a.requestChannel)
+	if err != nil {
+		redis_log.Debug("failed to count the subscribers: %v", err)
+		return 1
+	}
+	return count
+}
+
+// The broadcast function of a parent namespace only forwards the packets to its children, which publish them.
+func (a *redisAdapter) SetBroadcast(broadcast func(*parser.Packet, *socket.BroadcastOptions) error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a._broadcast = broadcast
+}
+
+// Broadcasts a packet.
+//
+// Options:
+//   - `Flags` {*BroadcastFlags} flags for this packet
+//   - `Except` {*types.Set[Room]} sids that should be excluded
+//   - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
+func (a *redisAdapter) Broadcast(packet *parser.Packet, opts *socket.BroadcastOptions) error {
+	a.mu.RLock()
+	broadcast := a._broadcast
+	a.mu.RUnlock()
+
+	if broadcast != nil {
+		return broadcast(packet, opts)
+	}
+
+	packet.Nsp = a.Nsp().Name()
+	data, err := encodeData(packet.Data)
+	if err != nil {
+		return &parser.EncodeError{Packet: packet, Err: err}
+	}
+	packet.Data = data
+
+	// copied before the packet is encoded, since the binary packets are modified by the encoder
+	message := &broadcastMessage{
+		Uid:    a.uid,
+		Packet: encodePacket(packet),
+		Opts:   encodeOptions(opts),
+	}
+	// the packet is encoded locally first, so that a packet which cannot be encoded is not sent to the other servers
+	if err := a.Adapter.Broadcast(packet, opts); err != nil {
+		return err
+	}
+	if !isLocal(opts) {
+		msg, err := encode(message)
+		if err != nil {
+			redis_log.Debug("failed to encode the packet: %v", err)
+			return err
+		}
+		channel := a.channel
+		if opts != nil && opts.Rooms != nil && opts.Rooms.Len() == 1 {
+			channel += string(opts.Rooms.Keys()[0]) + "#"
+		}
+		redis_log.Debug("publishing message to channel %s", channel)
+		a.publish(channel, msg)
+	}
+	return nil
+}
+
+// Broadcasts a packet and expects multiple acknowledgements.
+//
+// Options:
+//   - `Flags` {*BroadcastFlags} flags for this packet
+//   - `Except` {*types.Set[Room]} sids that should be excluded
+//   - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
+func (a *redisAdapter) BroadcastWithAck(packet *parser.Packet, opts *socket.BroadcastOptions, clientsCallback func(*socket.BroadcastClients), ack func(*socket.BroadcastAck)) error {
+	packet.Nsp = a.Nsp().Name()
+	data, err := encodeData(packet.Data)
+	if err != nil {
+		return &parser.EncodeError{Packet: packet, Err: err}
+	}
+	packet.Data = data
+
+	// copied before the packet is encoded, since the binary packets are modified by the encoder
+	redisPacket := encodePacket(packet)
+	// the packet is encoded locally first, so that a packet which cannot be encoded is not sent to the other servers
+	if err := a.Adapter.BroadcastWithAck(packet, opts, func(clients *socket.BroadcastClients) {
+		clients.ServerId = socket.ServerId(a.uid)
+		clientsCallback(clients)
+	}, ack); err != nil {
+		return err
+	}
+	if !isLocal(opts) {
+		requestId, _ := utils.Base64Id().GenerateId()
+		a.ackRequests.Store(requestId, &ackRequest{
+			clientsCallback: clientsCallback,
+			ack:             ack,
+		})
+		a.publishRequest(&request{
+			Uid:       a.uid,
+			RequestId: requestId,
+			Type:      BROADCAST,
+			Packet:    redisPacket,
+			Opts:      encodeOptions(opts),
+		})
+		// we have no way to know at this level whether the server has received an acknowledgement from each client, so we
+		// will simply clean up the ackRequests map after the given delay
+		utils.SetTimeOut(func() {
+			a.ackRequests.Delete(requestId)
+		}, a.timeout(opts))
+	}
+	return nil
+}
+
+// Returns the matching socket instances, including the ones connected to the other servers of the cluster.
+func (a *redisAdapter) FetchSockets(opts *socket.BroadcastOptions) []any {
+	localSockets := a.Adapter.FetchSockets(opts)
+	if isLocal(opts) {
+		return localSockets
+	}
+
+	numSub := a.ServerCount()
+	redis_log.Debug(`waiting for %d responses to "fetchSockets" request`, numSub-1)
+	if numSub <= 1 {
+		return localSockets
+	}
+
+	requestId, _ := utils.Base64Id().GenerateId()
+	req := &fetchRequest{
+		numSub:   numSub,
+		msgCount: 1,
+		sockets:  localSockets,
+		done:     make(chan struct{}),
+	}
+	a.requests.Store(requestId, req)
+	defer a.requests.Delete(requestId)
+
+	a.publishRequest(&request{
+		Uid:       a.uid,
+		RequestId: requestId,
+		Type:      REMOTE_FETCH,
+		Opts:      encodeOptions(opts),
+	})
+
+	timeout := time.NewTimer(a.timeout(opts))
+	defer timeout.Stop()
+	select {
+	case <-req.done:
+	case <-timeout.C:
+		redis_log.Error("timeout reached while waiting for fetchSockets response")
+	}
+
+	req.mu.Lock()
+	defer req.mu.Unlock()
+
+	return append([]any{}, req.sockets...)
+}
+
+// Makes the matching socket instances join the specified rooms
+func (a *redisAdapter) AddSockets(opts *socket.BroadcastOptions, rooms []socket.Room) {
+	if !isLocal(opts) {
+		a.publishRequest(&request{
+			Uid:   a.uid,
+			Type:  REMOTE_JOIN,
+			Opts:  encodeOptions(opts),
+			Rooms: rooms,
+		})
+	}
+	a.Adapter.AddSockets(opts, rooms)
+}
+
+// Makes the matching socket instances leave the specified rooms
+func (a *redisAdapter) DelSockets(opts *socket.BroadcastOptions, rooms []socket.Room) {
+	if !isLocal(opts) {
+		a.publishRequest(&request{
+			Uid:   a.uid,
+			Type:  REMOTE_LEAVE,
+			Opts:  encodeOptions(opts),
+			Rooms: rooms,
+		})
+	}
+	a.Adapter.DelSockets(opts, rooms)
+}
+
+// Makes the matching socket instances disconnect
+func (a *redisAdapter) DisconnectSockets(opts *socket.BroadcastOptions, status bool) {
+	if !isLocal(opts) {
+		a.publishRequest(&request{
+			Uid:   a.uid,
+			Type:  REMOTE_DISCONNECT,
+			Opts:  encodeOptions(opts),
+			Close: status,
+		})
+	}
+	a.Adapter.DisconnectSockets(opts, status)
+}
+
+// Send a packet to the other Socket.IO servers in the cluster. If the last argument is a func(error, []any), it is
+// called with one response per server, or with an error once the requests timeout is reached.
+func (a *redisAdapter) ServerSideEmit(ev string, args ...any) error {
+	data := append([]any{ev}, args...)
+	ack, withAck := data[len(data)-1].(func(error, []any))
+	if withAck {
+		data = data[:len(data)-1]
+	}
+	encoded, err := encodeData(data)
+	if err != nil {
+		return err
+	}
+	if !withAck {
+		return a.publishRequest(&request{
+			Uid:  a.uid,
+			Type: SERVER_SIDE_EMIT,
+			Data: encoded.([]any),
+		})
+	}
+
+	numSub := a.ServerCount() - 1 // ignore self
+	redis_log.Debug(`waiting for %d responses to "serverSideEmit" request`, numSub)
+	if numSub <= 0 {
+		ack(nil, []any{})
+		return nil
+	}
+
+	requestId, _ := utils.Base64Id().GenerateId()
+	req := &serverSideEmitRequest{
+		numSub:    numSub,
+		responses: []any{},
+		ack:       ack,
+	}
+	a.requests.Store(requestId, req)
+	req.timeout = utils.SetTimeOut(func() {
+		if _, ok := a.requests.LoadAndDelete(requestId); ok {
+			req.mu.Lock()
+			responses := append([]any{}, req.responses...)
+			req.mu.Unlock()
+			ack(fmt.Errorf("timeout reached: only %d responses received out of %d", len(responses), numSub), responses)
+		}
+	}, a.opts.RequestsTimeout())
+
+	if err := a.publishRequest(&request{
+		Uid:       a.uid,
+		RequestId: requestId,
+		Type:      SERVER_SIDE_EMIT,
+		Data:      encoded.([]any),
+	}); err != nil {
+		a.requests.Delete(requestId)
+		utils.ClearTimeout(req.timeout)
+		return err
+	}
+	return nil
+}
+
+// Called with a message published on a broadcast channel.
+func (a *redisAdapter) onmessage(_ string, channel string, msg []byte) {
+	if !strings.HasPrefix(channel, a.channel) {
+		redis_log.Debug("ignore different channel")
+		return
+	}
+	if room := strings.TrimSuffix(channel[len(a.channel):], "#"); room != "" {
+		if _, ok := a.Rooms().Load(socket.Room(room)); !ok {
+			redis_log.Debug("ignore unknown room %s", room)
+			return
+		}
+	}
+
+	var message broadcastMessage
+	if err := decode(msg, &message); err != nil {
+		redis_log.Debug("ignore malformed message: %v", err)
+		return
+	}
+	if message.Uid == a.uid {
+		redis_log.Debug("ignore same uid")
+		return
+	}
+	packet := decodePacket(message.Packet)
+	if packet == nil || packet.Nsp != a.Nsp().Name() {
+		redis_log.Debug("ignore different namespace")
+		return
+	}
+	a.Adapter.Broadcast(packet, decodeOptions(message.Opts))
+}
+
+// Called with a message published on the request or the response channels.
+func (a *redisAdapter) onrequest(_ string, channel string, msg []byte) {
+	if strings.HasPrefix(channel, a.responseChannel) {
+		a.onresponse(msg)
+		return
+	}
+	if !strings.HasPrefix(channel, a.requestChannel) {
+		redis_log.Debug("ignore different channel")
+		return
+	}
+
+	var req request
+	if err := decode(msg, &req); err != nil {
+		redis_log.Debug("ignore malformed request: %v", err)
+		return
+	}
+	if req.Uid == a.uid {
+		redis_log.Debug("ignore same uid")
+		return
+	}
+	redis_log.Debug("received request %v", req.Type)
+
+	switch req.Type {
+	case REMOTE_JOIN:
+		if req.Opts != nil {
+			a.Adapter.AddSockets(decodeOptions(req.Opts), req.Rooms)
+		}
+
+	case REMOTE_LEAVE:
+		if req.Opts != nil {
+			a.Adapter.DelSockets(decodeOptions(req.Opts), req.Rooms)
+		}
+
+	case REMOTE_DISCONNECT:
+		if req.Opts != nil {
+			a.Adapter.DisconnectSockets(decodeOptions(req.Opts), req.Close)
+		}
+
+	case REMOTE_FETCH:
+		localSockets := a.Adapter.FetchSockets(decodeOptions(req.Opts))
+		sockets := make([]*socketDetails, 0, len(localSockets))
+		for _, s := range localSockets {
+			if details, ok := s.(socket.SocketDetails); ok {
+				sockets = append(sockets, newSocketDetails(details))
+			}
+		}
+		a.publishResponse(&req, map[string]any{
+			"requestId": req.RequestId,
+			"sockets":   sockets,
+		})
+
+	case SERVER_SIDE_EMIT:
+		data, _ := decodeData(req.Data).([]any)
+		if len(data) == 0 {
+			return
+		}
+		ev, _ := data[0].(string)
+		args := data[1:]
+		if req.RequestId != "" {
+			var once sync.Once
+			args = append(args, func(args ...any) {
+				once.Do(func() {
+					data, err := encodeData(serverSideEmitResponse(args))
+					if err != nil {
+						redis_log.Debug("failed to encode the response: %v", err)
+					}
+					a.publishResponse(&req, map[string]any{
+						"type":      SERVER_SIDE_EMIT,
+						"requestId": req.RequestId,
+						"data":      data,
+					})
+				})
+			})
+		}
+		// the listeners may issue requests of their own, whose responses are read by the subscription
+		go a.Nsp().EmitUntyped(ev, args...)
+
+	case BROADCAST:
+		packet := decodePacket(req.Packet)
+		if packet == nil {
+			return
+		}
+		a.Adapter.BroadcastWithAck(packet, decodeOptions(req.Opts), func(clients *socket.BroadcastClients) {
+			redis_log.Debug("waiting for %d client acknowledgements", clients.Count)
+			// the uid and the sids are ignored by the Node.js servers
+			a.publishResponse(&req, map[string]any{
+				"type":        BROADCAST_CLIENT_COUNT,
+				"requestId":   req.RequestId,
+				"clientCount": clients.Count,
+				"uid":         a.uid,
+				"sids":        clients.Sids,
+			})
+		}, func(clientAck *socket.BroadcastAck) {
+			redis_log.Debug("received acknowledgement with value %v", clientAck.Args)
+			response := map[string]any{
+				"type":      BROADCAST_ACK,
+				"requestId": req.RequestId,
+				"sid":       clientAck.Sid,
+			}
+			var disconnected *socket.DisconnectedError
+			if errors.As(clientAck.Err, &disconnected) {
+				response["disconnectReason"] = disconnected.Reason
+				response["packet"] = []any{}
+			} else {
+				// decoded from the ACK packet of the client, so it cannot hold a cycle
+				response["packet"] = _encodeData(clientAck.Args)
+			}
+			a.publishResponse(&req, response)
+		})
+
+	default:
+		redis_log.Debug("ignoring unknown request type: %v", req.Type)
+	}
+}
+
+// Called with a message published on the response channels.
+func (a *redisAdapter) onresponse(msg []byte) {
+	var res response
+	if err := decode(msg, &res); err != nil {
+		redis_log.Debug("ignore malformed response: %v", err)
+		return
+	}
+
+	if ack, ok := a.ackRequests.Load(res.RequestId); ok {
+		switch res.Type {
+		case BROADCAST_CLIENT_COUNT:
+			ack.(*ackRequest).clientsCallback(&socket.BroadcastClients{
+				ServerId: socket.ServerId(res.Uid),
+				Count:    res.ClientCount,
+				Sids:     res.Sids,
+			})
+		case BROADCAST_ACK:
+			clientAck := &socket.BroadcastAck{Sid: res.Sid}
+			if res.DisconnectReason != "" {
+				clientAck.Err = &socket.DisconnectedError{Reason: res.DisconnectReason}
+			} else {
+				clientAck.Args, _ = decodeData(res.Packet).([]any)
+			}
+			ack.(*ackRequest).ack(clientAck)
+		}
+		return
+	}
+
+	_req, ok := a.requests.Load(res.RequestId)
+	if !ok {
+		redis_log.Debug("ignoring unknown request")
+		return
+	}
+
+	switch req := _req.(type) {
+	case *fetchRequest:
+		req.mu.Lock()
+		defer req.mu.Unlock()
+
+		req.msgCount++
+		for _, s := range res.Sockets {
+			if s != nil {
+				req.sockets = append(req.sockets, s)
+			}
+		}
+		if req.msgCount == req.numSub {
+			close(req.done)
+		}
+
+	case *serverSideEmitRequest:
+		req.mu.Lock()
+		req.msgCount++
+		req.responses = append(req.responses, decodeData(res.Data))
+		if req.msgCount < req.numSub {
+			req.mu.Unlock()
+			return
+		}
+		responses := append([]any{}, req.responses...)
+		req.mu.Unlock()
+
+		if _, ok := a.requests.LoadAndDelete(res.RequestId); ok {
+			utils.ClearTimeout(req.timeout)
+			req.ack(nil, responses)
+		}
+	}
+}
+
+func (a *redisAdapter) publishRequest(req *request) error {
+	msg, err := encode(req)
+	if err != nil {
+		return err
+	}
+	return a.publish(a.requestChannel, msg)
+}
+
+func (a *redisAdapter) publishResponse(req *request, res map[string]any) {
+	msg, err := encode(res)
+	if err != nil {
+		redis_log.Debug("failed to encode the response: %v", err)
+		return
+	}
+	responseChannel := a.responseChannel
+	if a.opts.PublishOnSpecificResponseChannel() {
+		responseChannel += req.Uid + "#"
+	}
+	redis_log.Debug("publishing response to channel %s", responseChannel)
+	a.publish(responseChannel, msg)
+}
+
+func (a *redisAdapter) publish(channel string, msg []byte) error {
+	if _, err := a.pubClient.Publish(channel, msg); err != nil {
+		redis_log.Error("failed to publish to %s: %v", channel, err)
+		return err
+	}
+	return nil
+}
+
+func (a *redisAdapter) timeout(opts *socket.BroadcastOptions) time.Duration {
+	if opts != nil && opts.Flags != nil && opts.Flags.Timeout != nil {
+		return *opts.Flags.Timeout
+	}
+	return a.opts.RequestsTimeout()
+}
+
+// The Node.js adapter replies with the first argument of the acknowledgement only.
+func serverSideEmitResponse(args []any) any {
+	switch len(args) {
+	case 0:
+		return nil
+	case 1:
+		return args[0]
+	}
+	return args
+}
+
+func isLocal(opts *socket.BroadcastOptions) bool {
+	return opts != nil && opts.Flags != nil && opts.Flags.Local
+}
+
+func encode(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decodes a message, which is either MessagePack or JSON (as sent by the previous versions of the Node.js adapter).
+func decode(msg []byte, v any) error {
+	if len(msg) > 0 && msg[0] == '{' {
+		return json.Unmarshal(msg, v)
+	}
+	dec := msgpack.NewDecoder(bytes.NewReader(msg))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
@@ -0,0 +1,301 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/socket.io/client"
+	"github.com/zishang520/socket.io/parser"
+	"github.com/zishang520/socket.io/socket"
+)
+
+// Starts a Socket.IO server using the Redis adapter, and connects a client to it.
+func newNode(t *testing.T, server *fakeServer) (*socket.Server, *client.Socket) {
+	t.Helper()
+
+	pubClient, err := NewClient(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	subClient, err := NewClient("redis://" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := socket.DefaultServerOptions()
+	opts.SetAdapter(NewRedisAdapter(pubClient, subClient, nil))
+	io := socket.NewServer(listener, opts)
+	io.On("connection", func(args ...any) {
+		args[0].(*socket.Socket).Join("room1")
+	})
+
+	clientOpts := client.DefaultOptions()
+	clientOpts.SetForceNew(true)
+	c, err := client.Io("http://"+listener.Addr().String(), clientOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan struct{}, 1)
+	c.On("connect", func(...any) {
+		connected <- struct{}{}
+	})
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client did not connect")
+	}
+
+	t.Cleanup(func() {
+		c.Disconnect()
+		io.Close(nil)
+		pubClient.Close()
+		subClient.Close()
+	})
+	return io, c
+}
+
+// Starts two nodes sharing the same Redis server.
+func newCluster(t *testing.T) ([]*socket.Server, []*client.Socket) {
+	server := newFakeServer(t)
+	io1, c1 := newNode(t, server)
+	io2, c2 := newNode(t, server)
+
+	if count := io1.Sockets().Adapter().ServerCount(); count != 2 {
+		t.Fatalf("expected 2 servers, got %d", count)
+	}
+	return []*socket.Server{io1, io2}, []*client.Socket{c1, c2}
+}
+
+func receive(t *testing.T, events chan []any) []any {
+	t.Helper()
+
+	select {
+	case args := <-events:
+		return args
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout reached")
+	}
+	return nil
+}
+
+func TestBroadcast(t *testing.T) {
+	servers, clients := newCluster(t)
+
+	events := []chan []any{make(chan []any, 10), make(chan []any, 10)}
+	for i, c := range clients {
+		events := events[i]
+		c.On("news", func(args ...any) {
+			events <- args
+		})
+	}
+
+	servers[0].Sockets().Emit("news", "to all")
+	for i := range clients {
+		if args := receive(t, events[i]); len(args) != 1 || args[0] != "to all" {
+			t.Fatalf("client %d: unexpected event %v", i, args)
+		}
+	}
+
+	servers[0].Sockets().Local().Emit("news", "local")
+	servers[1].Sockets().Except("room1").Emit("news", "nobody")
+	servers[1].Sockets().To("room1").Emit("news", "to room1")
+	if args := receive(t, events[0]); len(args) != 1 || args[0] != "local" {
+		t.Fatalf("unexpected event %v", args)
+	}
+	for i := range clients {
+		if args := receive(t, events[i]); len(args) != 1 || args[0] != "to room1" {
+			t.Fatalf("client %d: unexpected event %v", i, args)
+		}
+	}
+}
+
+func TestBroadcastWithAck(t *testing.T) {
+	servers, clients := newCluster(t)
+
+	for _, c := range clients {
+		c := c
+		c.On("ask", func(args ...any) {
+			args[len(args)-1].(func(...any))("answer-" + string(c.Id()))
+		})
+	}
+
+	counts := make(chan *socket.BroadcastClients, 10)
+	acks := make(chan *socket.BroadcastAck, 10)
+	packet := &parser.Packet{Type: parser.EVENT, Data: []any{"ask"}}
+	opts := &socket.BroadcastOptions{
+		Rooms:  types.NewSet[socket.Room](),
+		Except: types.NewSet[socket.Room](),
+		Flags:  &socket.BroadcastFlags{},
+	}
+	if err := servers[0].Sockets().Adapter().BroadcastWithAck(packet, opts, func(clients *socket.BroadcastClients) {
+		counts <- clients
+	}, func(ack *socket.BroadcastAck) {
+		acks <- ack
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	serverIds := map[socket.ServerId]uint64{}
+	answers := []string{}
+	for i := 0; i < 2; i++ {
+		var clients *socket.BroadcastClients
+		select {
+		case clients = <-counts:
+		case <-time.After(5 * time.Second):
+			t.Fatal("missing client count")
+		}
+		serverIds[clients.ServerId] += clients.Count
+
+		select {
+		case ack := <-acks:
+			if ack.Err != nil || len(ack.Args) != 1 {
+				t.Fatalf("unexpected acknowledgement %v", ack)
+			}
+			answers = append(answers, ack.Args[0].(string))
+		case <-time.After(5 * time.Second):
+			t.Fatal("missing acknowledgement")
+		}
+	}
+	if len(serverIds) != 2 || serverIds[socket.ServerId(servers[0].Sockets().Adapter().(*redisAdapter).uid)] != 1 || serverIds[socket.ServerId(servers[1].Sockets().Adapter().(*redisAdapter).uid)] != 1 {
+		t.Fatalf("expected one client on each server, got %v", serverIds)
+	}
+	sort.Strings(answers)
+	expected := []string{"answer-" + string(clients[0].Id()), "answer-" + string(clients[1].Id())}
+	sort.Strings(expected)
+	if answers[0] != expected[0] || answers[1] != expected[1] {
+		t.Fatalf("expected %v, got %v", expected, answers)
+	}
+
+	responses, err := servers[1].Timeout(time.Second).EmitWithAck(context.Background(), "ask")
+	if err != nil || len(responses) != 2 {
+		t.Fatalf("expected 2 responses, got %v, %v", responses, err)
+	}
+}
+
+func TestFetchSockets(t *testing.T) {
+	servers, clients := newCluster(t)
+
+	sockets, err := servers[0].FetchSockets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := map[socket.SocketId]bool{}
+	for _, s := range sockets {
+		ids[s.Id()] = s.Rooms().Has("room1")
+	}
+	if len(ids) != 2 || !ids[clients[0].Id()] || !ids[clients[1].Id()] {
+		t.Fatalf("expected the sockets of both servers in room1, got %v", ids)
+	}
+
+	servers[0].Sockets().Local().SocketsJoin("room2")
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		sockets := servers[1].In("room2").FetchSockets()
+		if len(sockets) == 1 && sockets[0].Id() == clients[0].Id() {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the socket of the first server in room2, got %v", sockets)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestServerSideEmit(t *testing.T) {
+	servers, _ := newCluster(t)
+
+	events := make(chan []any, 1)
+	servers[1].On("hello", func(args ...any) {
+		events <- args
+	})
+	if err := servers[0].ServerSideEmit("hello", "world", 1); err != nil {
+		t.Fatal(err)
+	}
+	if args := receive(t, events); len(args) != 2 || args[0] != "world" {
+		t.Fatalf("unexpected event %v", args)
+	}
+
+	servers[1].On("count", func(args ...any) {
+		args[len(args)-1].(func(...any))(42)
+	})
+	responses := make(chan []any, 1)
+	if err := servers[0].ServerSideEmitWithAck("count", func(err error, res []any) {
+		if err != nil {
+			t.Error(err)
+		}
+		responses <- res
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if res := receive(t, responses); len(res) != 1 {
+		t.Fatalf("expected one response, got %v", res)
+	}
+}
+
+// The channels and the messages are the ones of the socket.io-redis-adapter package.
+func TestNodeCompatibility(t *testing.T) {
+	server := newFakeServer(t)
+	io, c := newNode(t, server)
+
+	node, err := NewClient(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	type published struct {
+		channel string
+		message []byte
+	}
+	messages := make(chan published, 10)
+	if err := node.PSubscribe(func(_ string, channel string, message []byte) {
+		messages <- published{channel, message}
+	}, "socket.io#/#*"); err != nil {
+		t.Fatal(err)
+	}
+
+	io.To("room1").Emit("news", "to room1")
+	var m published
+	select {
+	case m = <-messages:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout reached")
+	}
+	if m.channel != "socket.io#/#room1#" {
+		t.Fatalf("unexpected channel %s", m.channel)
+	}
+	var message []any
+	if err := msgpack.Unmarshal(m.message, &message); err != nil || len(message) != 3 {
+		t.Fatalf("unexpected message %v, %v", message, err)
+	}
+	packet, _ := message[1].(map[string]any)
+	opts, _ := message[2].(map[string]any)
+	if message[0] != io.Sockets().Adapter().(*redisAdapter).uid || packet["nsp"] != "/" || fmt.Sprint(packet["data"]) != "[news to room1]" || fmt.Sprint(opts["rooms"]) != "[room1]" {
+		t.Fatalf("unexpected message %v", message)
+	}
+
+	// a broadcast from a Node.js server
+	events := make(chan []any, 1)
+	c.On("news", func(args ...any) {
+		events <- args
+	})
+	msg, _ := msgpack.Marshal([]any{
+		"node-uid",
+		map[string]any{"type": 2, "data": []any{"news", "from node"}, "nsp": "/"},
+		map[string]any{"rooms": []any{}, "except": []any{}, "flags": map[string]any{}},
+	})
+	if _, err := node.Publish("socket.io#/#", msg); err != nil {
+		t.Fatal(err)
+	}
+	if args := receive(t, events); len(args) != 1 || args[0] != "from node" {
+		t.Fatalf("unexpected event %v", args)
+	}
+}
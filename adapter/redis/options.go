@@ -0,0 +1,63 @@
+package redis
+
+import (
+	"time"
+)
+
+type RedisAdapterOptions struct {
+	// the name of the key to pub/sub events on as prefix
+	key *string
+
+	// after this timeout the adapter will stop waiting from responses to request
+	requestsTimeout *time.Duration
+
+	// whether to publish a response to the channel specific to the requesting node
+	publishOnSpecificResponseChannel *bool
+}
+
+func DefaultRedisAdapterOptions() *RedisAdapterOptions {
+	r := &RedisAdapterOptions{}
+	return r
+}
+
+func (r *RedisAdapterOptions) SetKey(key string) {
+	r.key = &key
+}
+func (r *RedisAdapterOptions) GetRawKey() *string {
+	return r.key
+}
+func (r *RedisAdapterOptions) Key() string {
+	if r.key == nil {
+		return "socket.io"
+	}
+
+	return *r.key
+}
+
+func (r *RedisAdapterOptions) SetRequestsTimeout(requestsTimeout time.Duration) {
+	r.requestsTimeout = &requestsTimeout
+}
+func (r *RedisAdapterOptions) GetRawRequestsTimeout() *time.Duration {
+	return r.requestsTimeout
+}
+func (r *RedisAdapterOptions) RequestsTimeout() time.Duration {
+	if r.requestsTimeout == nil {
+		return time.Duration(5000 * time.Millisecond)
+	}
+
+	return *r.requestsTimeout
+}
+
+func (r *RedisAdapterOptions) SetPublishOnSpecificResponseChannel(publishOnSpecificResponseChannel bool) {
+	r.publishOnSpecificResponseChannel = &publishOnSpecificResponseChannel
+}
+func (r *RedisAdapterOptions) GetRawPublishOnSpecificResponseChannel() *bool {
+	return r.publishOnSpecificResponseChannel
+}
+func (r *RedisAdapterOptions) PublishOnSpecificResponseChannel() bool {
+	if r.publishOnSpecificResponseChannel == nil {
+		return false
+	}
+
+	return *r.publishOnSpecificResponseChannel
+}
@@ -0,0 +1,186 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeConn struct {
+	writer   *bufio.Writer
+	channels map[string]bool
+	patterns map[string]bool
+
+	mu sync.Mutex
+}
+
+func (c *fakeConn) write(reply string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.writer.WriteString(reply)
+	c.writer.Flush()
+}
+
+// An in-process server implementing the subset of the RESP protocol used by the Client.
+type fakeServer struct {
+	listener net.Listener
+	conns    map[*fakeConn]bool
+
+	mu sync.Mutex
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeServer{listener: listener, conns: map[*fakeConn]bool{}}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+
+	return s
+}
+
+func (s *fakeServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func bulkString(value string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		arg := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
+
+func (s *fakeServer) handle(conn net.Conn) {
+	c := &fakeConn{writer: bufio.NewWriter(conn), channels: map[string]bool{}, patterns: map[string]bool{}}
+	s.mu.Lock()
+	s.conns[c] = true
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		switch command := strings.ToLower(args[0]); command {
+		case "publish":
+			c.write(fmt.Sprintf(":%d\r\n", s.publish(args[1], args[2])))
+		case "pubsub":
+			c.write("*2\r\n" + bulkString(args[2]) + fmt.Sprintf(":%d\r\n", s.numSub(args[2])))
+		case "subscribe", "unsubscribe":
+			for _, channel := range args[1:] {
+				c.mu.Lock()
+				if command == "subscribe" {
+					c.channels[channel] = true
+				} else {
+					delete(c.channels, channel)
+				}
+				count := len(c.channels) + len(c.patterns)
+				c.mu.Unlock()
+				c.write("*3\r\n" + bulkString(command) + bulkString(channel) + fmt.Sprintf(":%d\r\n", count))
+			}
+		case "psubscribe", "punsubscribe":
+			for _, pattern := range args[1:] {
+				c.mu.Lock()
+				if command == "psubscribe" {
+					c.patterns[pattern] = true
+				} else {
+					delete(c.patterns, pattern)
+				}
+				count := len(c.channels) + len(c.patterns)
+				c.mu.Unlock()
+				c.write("*3\r\n" + bulkString(command) + bulkString(pattern) + fmt.Sprintf(":%d\r\n", count))
+			}
+		default:
+			c.write("-ERR unknown command '" + args[0] + "'\r\n")
+		}
+	}
+}
+
+func (s *fakeServer) publish(channel string, message string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for c := range s.conns {
+		c.mu.Lock()
+		if c.channels[channel] {
+			count++
+			c.writer.WriteString("*3\r\n" + bulkString("message") + bulkString(channel) + bulkString(message))
+		}
+		for pattern := range c.patterns {
+			if matchPattern(pattern, channel) {
+				count++
+				c.writer.WriteString("*4\r\n" + bulkString("pmessage") + bulkString(pattern) + bulkString(channel) + bulkString(message))
+			}
+		}
+		c.writer.Flush()
+		c.mu.Unlock()
+	}
+	return count
+}
+
+func (s *fakeServer) numSub(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := 0
+	for c := range s.conns {
+		c.mu.Lock()
+		if c.channels[channel] {
+			count++
+		}
+		c.mu.Unlock()
+	}
+	return count
+}
+
+// Only supports the trailing wildcard of the patterns used by the adapter.
+func matchPattern(pattern string, channel string) bool {
+	if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+		return strings.HasPrefix(channel, prefix)
+	}
+	return pattern == channel
+}
@@ -0,0 +1,367 @@
+package redis
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/engine.io/utils"
+	"github.com/zishang520/socket.io/internal/parameters"
+	"github.com/zishang520/socket.io/parser"
+	"github.com/zishang520/socket.io/socket"
+)
+
+type RequestType int
+
+// The request types, identical to the ones used by the socket.io-redis-adapter package.
+const (
+	SOCKETS RequestType = iota
+	ALL_ROOMS
+	REMOTE_JOIN
+	REMOTE_LEAVE
+	REMOTE_DISCONNECT
+	REMOTE_FETCH
+	SERVER_SIDE_EMIT
+	BROADCAST
+	BROADCAST_CLIENT_COUNT
+	BROADCAST_ACK
+)
+
+type broadcastFlags struct {
+	Volatile bool   `json:"volatile,omitempty"`
+	Compress bool   `json:"compress,omitempty"`
+	Local    bool   `json:"local,omitempty"`
+	Timeout  *int64 `json:"timeout,omitempty"`
+}
+
+type broadcastOptions struct {
+	Rooms  []socket.Room   `json:"rooms"`
+	Except []socket.Room   `json:"except"`
+	Flags  *broadcastFlags `json:"flags,omitempty"`
+}
+
+// The message published on the broadcast channels.
+type broadcastMessage struct {
+	_msgpack struct{} `msgpack:",as_array"`
+
+	Uid    string
+	Packet map[string]any
+	Opts   *broadcastOptions
+}
+
+// The message published on the request channel.
+type request struct {
+	Uid       string            `json:"uid"`
+	RequestId string            `json:"requestId,omitempty"`
+	Type      RequestType       `json:"type"`
+	Opts      *broadcastOptions `json:"opts,omitempty"`
+	Rooms     []socket.Room     `json:"rooms,omitempty"`
+	Close     bool              `json:"close,omitempty"`
+	Data      []any             `json:"data,omitempty"`
+	Packet    map[string]any    `json:"packet,omitempty"`
+}
+
+// The message published on the response channels.
+type response struct {
+	Type        RequestType      `json:"type"`
+	RequestId   string           `json:"requestId"`
+	Sockets     []*socketDetails `json:"sockets"`
+	ClientCount uint64           `json:"clientCount"`
+	Packet      []any            `json:"packet"`
+	Data        any              `json:"data"`
+
+	// Only sent by the Go servers, for the acknowledgements of a broadcast
+	Uid              string            `json:"uid"`
+	Sids             []socket.SocketId `json:"sids"`
+	Sid              socket.SocketId   `json:"sid"`
+	DisconnectReason string            `json:"disconnectReason"`
+}
+
+type handshake struct {
+	Headers map[string]any `json:"headers"`
+	Time    string         `json:"time"`
+	Address string         `json:"address"`
+	Xdomain bool           `json:"xdomain"`
+	Secure  bool           `json:"secure"`
+	Issued  int64          `json:"issued"`
+	Url     string         `json:"url"`
+	Query   map[string]any `json:"query"`
+	Auth    any            `json:"auth"`
+}
+
+// The serialized form of a socket hosted on another node, as returned by FetchSockets().
+type socketDetails struct {
+	SocketId        socket.SocketId `json:"id"`
+	SocketHandshake *handshake      `json:"handshake"`
+	SocketRooms     []socket.Room   `json:"rooms"`
+	SocketData      any             `json:"data"`
+}
+
+func newSocketDetails(s socket.SocketDetails) *socketDetails {
+	d := &socketDetails{
+		SocketId:    s.Id(),
+		SocketRooms: s.Rooms().Keys(),
+	}
+	if data, err := encodeData(s.Data()); err != nil {
+		redis_log.Debug("failed to encode the data of the socket %s: %v", s.Id(), err)
+	} else {
+		d.SocketData = data
+	}
+	if h := s.Handshake(); h != nil {
+		d.SocketHandshake = &handshake{
+			Headers: parameters.Values(h.Headers),
+			Time:    h.Time,
+			Address: h.Address,
+			Xdomain: h.Xdomain,
+			Secure:  h.Secure,
+			Issued:  h.Issued,
+			Url:     h.Url,
+			Query:   parameters.Values(h.Query),
+			// decoded from the CONNECT packet, so it cannot hold a cycle
+			Auth: _encodeData(h.Auth),
+		}
+	}
+	return d
+}
+
+func (d *socketDetails) Id() socket.SocketId {
+	return d.SocketId
+}
+
+func (d *socketDetails) Handshake() *socket.Handshake {
+	if d.SocketHandshake == nil {
+		return nil
+	}
+	return &socket.Handshake{
+		Headers: decodeParameterBag(d.SocketHandshake.Headers),
+		Time:    d.SocketHandshake.Time,
+		Address: d.SocketHandshake.Address,
+		Xdomain: d.SocketHandshake.Xdomain,
+		Secure:  d.SocketHandshake.Secure,
+		Issued:  d.SocketHandshake.Issued,
+		Url:     d.SocketHandshake.Url,
+		Query:   decodeParameterBag(d.SocketHandshake.Query),
+		Auth:    decodeData(d.SocketHandshake.Auth),
+	}
+}
+
+func (d *socketDetails) Rooms() *types.Set[socket.Room] {
+	return types.NewSet(d.SocketRooms...)
+}
+
+func (d *socketDetails) Data() any {
+	return decodeData(d.SocketData)
+}
+
+func decodeParameterBag(values map[string]any) *utils.ParameterBag {
+	parameters := map[string][]string{}
+	for k, v := range values {
+		switch tv := v.(type) {
+		case string:
+			parameters[k] = []string{tv}
+		case []any:
+			for _, value := range tv {
+				if s, ok := value.(string); ok {
+					parameters[k] = append(parameters[k], s)
+				}
+			}
+		}
+	}
+	return utils.NewParameterBag(parameters)
+}
+
+func encodeOptions(opts *socket.BroadcastOptions) *broadcastOptions {
+	o := &broadcastOptions{
+		Rooms:  []socket.Room{},
+		Except: []socket.Room{},
+	}
+	if opts == nil {
+		return o
+	}
+	if opts.Rooms != nil {
+		o.Rooms = opts.Rooms.Keys()
+	}
+	if opts.Except != nil {
+		o.Except = opts.Except.Keys()
+	}
+	if opts.Flags != nil {
+		o.Flags = &broadcastFlags{
+			Volatile: opts.Flags.Volatile,
+			Compress: opts.Flags.Compress,
+			Local:    opts.Flags.Local,
+		}
+		if opts.Flags.Timeout != nil {
+			timeout := opts.Flags.Timeout.Milliseconds()
+			o.Flags.Timeout = &timeout
+		}
+	}
+	return o
+}
+
+func decodeOptions(o *broadcastOptions) *socket.BroadcastOptions {
+	opts := &socket.BroadcastOptions{
+		Rooms:  types.NewSet[socket.Room](),
+		Except: types.NewSet[socket.Room](),
+		Flags:  &socket.BroadcastFlags{},
+	}
+	if o == nil {
+		return opts
+	}
+	opts.Rooms.Add(o.Rooms...)
+	opts.Except.Add(o.Except...)
+	if o.Flags != nil {
+		opts.Flags.Volatile = o.Flags.Volatile
+		opts.Flags.Compress = o.Flags.Compress
+		if o.Flags.Timeout != nil {
+			timeout := time.Duration(*o.Flags.Timeout) * time.Millisecond
+			opts.Flags.Timeout = &timeout
+		}
+	}
+	return opts
+}
+
+func encodePacket(packet *parser.Packet) map[string]any {
+	p := map[string]any{
+		"type": int(packet.Type - '0'),
+		"data": packet.Data,
+		"nsp":  packet.Nsp,
+	}
+	if packet.Id != nil {
+		p["id"] = *packet.Id
+	}
+	return p
+}
+
+func decodePacket(p map[string]any) *parser.Packet {
+	if p == nil {
+		return nil
+	}
+	packet := &parser.Packet{
+		Nsp:  "/",
+		Data: decodeData(p["data"]),
+	}
+	if nsp, ok := p["nsp"].(string); ok {
+		packet.Nsp = nsp
+	}
+	packetType, ok := toInt64(p["type"])
+	if !ok || packetType < 0 || packetType > 6 {
+		return nil
+	}
+	packet.Type = parser.PacketType('0' + packetType)
+	// the binary packets are re-encoded by the local encoder
+	switch packet.Type {
+	case parser.BINARY_EVENT:
+		packet.Type = parser.EVENT
+	case parser.BINARY_ACK:
+		packet.Type = parser.ACK
+	}
+	if id, ok := toInt64(p["id"]); ok && id >= 0 {
+		_id := uint64(id)
+		packet.Id = &_id
+	}
+	return packet
+}
+
+// Reads the binary attachments, so that the data can be both serialized and encoded by the local encoder. parser.ErrCycle is
+// returned if the data holds a cycle, which MessagePack does not detect.
+func encodeData(data any) (any, error) {
+	if err := parser.CheckCycles(data); err != nil {
+		return nil, err
+	}
+	return _encodeData(data), nil
+}
+
+func _encodeData(data any) any {
+	switch tdata := data.(type) {
+	case nil:
+		return nil
+	case json.Number:
+		// would be encoded as a MessagePack str otherwise
+		return parser.ConvertNumber(tdata)
+	case *types.StringBuffer:
+		return tdata.String()
+	case *strings.Reader:
+		rdata, _ := types.NewStringBufferReader(tdata)
+		return rdata.String()
+	case []byte:
+		return tdata
+	case io.Reader:
+		if c, ok := tdata.(io.Closer); ok {
+			defer c.Close()
+		}
+		rdata := types.NewBytesBuffer(nil)
+		rdata.ReadFrom(tdata)
+		return rdata.Bytes()
+	case []any:
+		newData := make([]any, 0, len(tdata))
+		for _, v := range tdata {
+			newData = append(newData, _encodeData(v))
+		}
+		return newData
+	case map[string]any:
+		newData := map[string]any{}
+		for k, v := range tdata {
+			newData[k] = _encodeData(v)
+		}
+		return newData
+	}
+	return data
+}
+
+// Normalizes the numbers decoded by the MessagePack decoder.
+func decodeData(data any) any {
+	switch tdata := data.(type) {
+	case int8, int16, int32, int64:
+		v, _ := toInt64(tdata)
+		return v
+	case uint8:
+		return uint64(tdata)
+	case uint16:
+		return uint64(tdata)
+	case uint32:
+		return uint64(tdata)
+	case float32:
+		return float64(tdata)
+	case []any:
+		newData := make([]any, 0, len(tdata))
+		for _, v := range tdata {
+			newData = append(newData, decodeData(v))
+		}
+		return newData
+	case map[string]any:
+		newData := map[string]any{}
+		for k, v := range tdata {
+			newData[k] = decodeData(v)
+		}
+		return newData
+	}
+	return data
+}
+
+func toInt64(v any) (int64, bool) {
+	switch n := v.(type) {
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		return int64(n), true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
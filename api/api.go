@@ -0,0 +1,439 @@
+// Package api exposes the namespaces, the rooms and the sockets of a Socket.IO server as JSON endpoints, and lets
+// backend services emit events and manage the sockets without opening a connection. With a cluster adapter, the
+// sockets of all the servers of the cluster are listed and targeted.
+//
+//	opts := api.DefaultHandlerOptions()
+//	opts.SetAuthorize(api.BearerToken(os.Getenv("API_TOKEN")))
+//	http.Handle("/api/", http.StripPrefix("/api", api.NewHandler(io, opts)))
+//
+// The endpoints, which target the namespace given by the `nsp` query parameter ("/" by default):
+//
+//	GET  /namespaces        the namespaces, with their number of sockets
+//	GET  /rooms             the rooms, with the ids of their sockets (the private room of each socket excluded)
+//	GET  /rooms/{room}      a room, with the ids of its sockets
+//	GET  /sockets           the sockets, possibly in the rooms given by the `room` query parameters
+//	GET  /sockets/{id}      a socket
+//	POST /emit              {"event": "news", "data": [...], "in": [...], "except": [...], "timeout": 5000}
+//	POST /join              {"rooms": [...], "in": [...], "except": [...]}
+//	POST /leave             {"rooms": [...], "in": [...], "except": [...]}
+//	POST /disconnect        {"close": false, "in": [...], "except": [...]}
+//
+// The sockets are targeted by the rooms (or socket ids) of `in`, all of them when it is empty, except the ones in the
+// rooms of `except`. With a `timeout` (in milliseconds), the emit waits for the acknowledgement of each socket.
+//
+// Without an Authorizer, the POST endpoints are rejected with a 403 status. A handler already protected by another
+// middleware can allow all the requests with an Authorizer returning nil.
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zishang520/engine.io/log"
+	"github.com/zishang520/engine.io/utils"
+	"github.com/zishang520/socket.io/internal/parameters"
+	"github.com/zishang520/socket.io/socket"
+)
+
+var api_log = log.NewLog("socket.io:api")
+
+// The maximum size of the body of a request.
+const maxBodySize = 1 << 20
+
+// The headers of the handshake which are not exposed, since they hold the credentials of the client.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization"}
+
+type handler struct {
+	server *socket.Server
+	opts   *HandlerOptions
+}
+
+// The body of the requests which target some sockets.
+type target struct {
+	In     []socket.Room `json:"in"`
+	Except []socket.Room `json:"except"`
+}
+
+type emitRequest struct {
+	target
+	Event   string `json:"event"`
+	Data    []any  `json:"data"`
+	Timeout int64  `json:"timeout"`
+}
+
+type roomsRequest struct {
+	target
+	Rooms []socket.Room `json:"rooms"`
+}
+
+type disconnectRequest struct {
+	target
+	Close bool `json:"close"`
+}
+
+type namespaceDetails struct {
+	Name         string `json:"name"`
+	SocketsCount int    `json:"socketsCount"`
+}
+
+type roomDetails struct {
+	Name    socket.Room       `json:"name"`
+	Sockets []socket.SocketId `json:"sockets"`
+}
+
+type socketDetails struct {
+	Id        socket.SocketId   `json:"id"`
+	Rooms     []socket.Room     `json:"rooms"`
+	Data      any               `json:"data"`
+	Handshake *handshakeDetails `json:"handshake,omitempty"`
+}
+
+// The handshake of a socket, without its auth payload and its sensitive headers, which may hold credentials.
+type handshakeDetails struct {
+	Headers map[string]any `json:"headers"`
+	Time    string         `json:"time"`
+	Address string         `json:"address"`
+	Xdomain bool           `json:"xdomain"`
+	Secure  bool           `json:"secure"`
+	Issued  int64          `json:"issued"`
+	Url     string         `json:"url"`
+	Query   map[string]any `json:"query"`
+}
+
+type emitResponses struct {
+	Responses    map[socket.SocketId][]any `json:"responses"`
+	TimedOut     []socket.SocketId         `json:"timedOut"`
+	Disconnected []socket.SocketId         `json:"disconnected"`
+}
+
+// Creates the handler of the JSON endpoints of the server.
+func NewHandler(server *socket.Server, opts *HandlerOptions) http.Handler {
+	if opts == nil {
+		opts = DefaultHandlerOptions()
+	}
+	if opts.Authorize() == nil {
+		api_log.Warning("authorization is disabled, only the read-only endpoints are served")
+	}
+
+	return &handler{server: server, opts: opts}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, param := splitPath(r.URL.Path)
+	write := false
+	switch route {
+	case "namespaces", "rooms", "sockets":
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+	case "emit", "join", "leave", "disconnect":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		write = true
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	authorize := h.opts.Authorize()
+	if authorize == nil && write {
+		writeError(w, http.StatusForbidden, "an authorizer is required to modify the sockets")
+		return
+	}
+	if authorize != nil {
+		if err := authorize(r, write); err != nil {
+			api_log.Debug("request %s %s is not allowed: %v", r.Method, r.URL.Path, err)
+			if errors.Is(err, ErrUnauthorized) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, err.Error())
+			} else {
+				writeError(w, http.StatusForbidden, err.Error())
+			}
+			return
+		}
+	}
+	if write && h.opts.Readonly() {
+		writeError(w, http.StatusForbidden, "read-only")
+		return
+	}
+
+	if route == "namespaces" {
+		h.namespaces(w)
+		return
+	}
+
+	nsp := h.namespace(r.URL.Query().Get("nsp"))
+	if nsp == nil {
+		writeError(w, http.StatusNotFound, "unknown namespace")
+		return
+	}
+
+	switch route {
+	case "rooms":
+		h.rooms(w, nsp, param)
+	case "sockets":
+		h.sockets(w, r, nsp, param)
+	case "emit":
+		h.emit(w, r, nsp)
+	case "join":
+		h.join(w, r, nsp, true)
+	case "leave":
+		h.join(w, r, nsp, false)
+	case "disconnect":
+		h.disconnect(w, r, nsp)
+	}
+}
+
+// Returns the first segment of the path, and the rest of it (unescaped).
+func splitPath(p string) (string, string) {
+	route, param, _ := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	if unescaped, err := url.PathUnescape(param); err == nil {
+		param = unescaped
+	}
+	return route, param
+}
+
+// Looks up an existing namespace, without creating it.
+func (h *handler) namespace(name string) socket.NamespaceInterface {
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+	if nsp, ok := h.server.Nsps().Load(name); ok {
+		return nsp.(socket.NamespaceInterface)
+	}
+	return nil
+}
+
+func (h *handler) namespaces(w http.ResponseWriter) {
+	namespaces := []*namespaceDetails{}
+	h.server.Nsps().Range(func(_, nsp any) bool {
+		namespace := nsp.(socket.NamespaceInterface)
+		namespaces = append(namespaces, &namespaceDetails{
+			Name:         namespace.Name(),
+			SocketsCount: len(namespace.Except().FetchSockets()),
+		})
+		return true
+	})
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	writeJSON(w, http.StatusOK, namespaces)
+}
+
+func (h *handler) rooms(w http.ResponseWriter, nsp socket.NamespaceInterface, room string) {
+	if room != "" {
+		details := &roomDetails{Name: socket.Room(room), Sockets: []socket.SocketId{}}
+		for _, remoteSocket := range nsp.In(socket.Room(room)).FetchSockets() {
+			details.Sockets = append(details.Sockets, remoteSocket.Id())
+		}
+		if len(details.Sockets) == 0 {
+			writeError(w, http.StatusNotFound, "unknown room")
+			return
+		}
+		writeJSON(w, http.StatusOK, details)
+		return
+	}
+
+	members := map[socket.Room][]socket.SocketId{}
+	for _, remoteSocket := range nsp.Except().FetchSockets() {
+		for _, room := range remoteSocket.Rooms().Keys() {
+			// each socket joins a room named after its own id
+			if room != socket.Room(remoteSocket.Id()) {
+				members[room] = append(members[room], remoteSocket.Id())
+			}
+		}
+	}
+	rooms := make([]*roomDetails, 0, len(members))
+	for room, sockets := range members {
+		rooms = append(rooms, &roomDetails{Name: room, Sockets: sockets})
+	}
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name < rooms[j].Name
+	})
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+func (h *handler) sockets(w http.ResponseWriter, r *http.Request, nsp socket.NamespaceInterface, id string) {
+	if id != "" {
+		for _, remoteSocket := range nsp.In(socket.Room(id)).FetchSockets() {
+			if remoteSocket.Id() == socket.SocketId(id) {
+				writeJSON(w, http.StatusOK, newSocketDetails(remoteSocket))
+				return
+			}
+		}
+		writeError(w, http.StatusNotFound, "unknown socket")
+		return
+	}
+
+	rooms := []socket.Room{}
+	for _, room := range r.URL.Query()["room"] {
+		rooms = append(rooms, socket.Room(room))
+	}
+	sockets := []*socketDetails{}
+	for _, remoteSocket := range nsp.Except().In(rooms...).FetchSockets() {
+		sockets = append(sockets, newSocketDetails(remoteSocket))
+	}
+	writeJSON(w, http.StatusOK, sockets)
+}
+
+func (h *handler) emit(w http.ResponseWriter, r *http.Request, nsp socket.NamespaceInterface) {
+	req := &emitRequest{}
+	if !readJSON(w, r, req) {
+		return
+	}
+	if req.Event == "" {
+		writeError(w, http.StatusBadRequest, "missing event")
+		return
+	}
+	if socket.SOCKET_RESERVED_EVENTS.Has(req.Event) {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("%q is a reserved event name", req.Event))
+		return
+	}
+
+	operator := req.operator(nsp)
+	if req.Timeout <= 0 {
+		if err := operator.Emit(req.Event, req.Data...); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	timeout := time.Duration(req.Timeout) * time.Millisecond
+	// the timeout of the broadcast answers before the context is done, so that the sockets which have not answered
+	// are reported
+	ctx, cancel := context.WithTimeout(r.Context(), 2*timeout)
+	defer cancel()
+
+	responses, err := operator.Timeout(timeout).EmitWithResponses(ctx, req.Event, req.Data...)
+	if responses == nil {
+		writeError(w, http.StatusGatewayTimeout, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, &emitResponses{
+		Responses:    responses.Responses,
+		TimedOut:     nonNil(responses.TimedOut),
+		Disconnected: nonNil(responses.Disconnected),
+	})
+}
+
+func (h *handler) join(w http.ResponseWriter, r *http.Request, nsp socket.NamespaceInterface, join bool) {
+	req := &roomsRequest{}
+	if !readJSON(w, r, req) {
+		return
+	}
+	if len(req.Rooms) == 0 {
+		writeError(w, http.StatusBadRequest, "missing rooms")
+		return
+	}
+
+	if join {
+		req.operator(nsp).SocketsJoin(req.Rooms...)
+	} else {
+		req.operator(nsp).SocketsLeave(req.Rooms...)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *handler) disconnect(w http.ResponseWriter, r *http.Request, nsp socket.NamespaceInterface) {
+	req := &disconnectRequest{}
+	if !readJSON(w, r, req) {
+		return
+	}
+
+	req.operator(nsp).DisconnectSockets(req.Close)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Targets the sockets in the rooms of `in` (all of them when it is empty) and not in the rooms of `except`.
+func (t *target) operator(nsp socket.NamespaceInterface) *socket.BroadcastOperator {
+	return nsp.Except(t.Except...).In(t.In...)
+}
+
+func newSocketDetails(s *socket.RemoteSocket) *socketDetails {
+	d := &socketDetails{
+		Id:    s.Id(),
+		Rooms: s.Rooms().Keys(),
+		Data:  s.Data(),
+	}
+	sort.Slice(d.Rooms, func(i, j int) bool {
+		return d.Rooms[i] < d.Rooms[j]
+	})
+	if handshake := s.Handshake(); handshake != nil {
+		d.Handshake = &handshakeDetails{
+			Headers: exposedHeaders(handshake.Headers),
+			Time:    handshake.Time,
+			Address: handshake.Address,
+			Xdomain: handshake.Xdomain,
+			Secure:  handshake.Secure,
+			Issued:  handshake.Issued,
+			Url:     handshake.Url,
+			Query:   parameters.Values(handshake.Query),
+		}
+	}
+	return d
+}
+
+// Returns the headers of the handshake, without the sensitive ones.
+func exposedHeaders(bag *utils.ParameterBag) map[string]any {
+	headers := parameters.Values(bag)
+	for name := range headers {
+		for _, sensitive := range sensitiveHeaders {
+			if strings.EqualFold(name, sensitive) {
+				delete(headers, name)
+				break
+			}
+		}
+	}
+	return headers
+}
+
+func nonNil(ids []socket.SocketId) []socket.SocketId {
+	if ids == nil {
+		return []socket.SocketId{}
+	}
+	return ids
+}
+
+// Decodes the body of the request, the numbers being kept as json.Number so that the large integers are not rounded.
+func readJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		api_log.Debug("failed to encode the response: %v", err)
+		writeError(w, http.StatusInternalServerError, "failed to encode the response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	body, _ := json.Marshal(map[string]string{"error": message})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zishang520/socket.io/client"
+	"github.com/zishang520/socket.io/socket"
+)
+
+// Starts a Socket.IO server, connects a client to it and serves the API of the server.
+func newAPI(t *testing.T, opts *HandlerOptions, headers http.Header) (*socket.Server, *client.Socket, *httptest.Server) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io := socket.NewServer(listener, nil)
+	io.On("connection", func(args ...any) {
+		s := args[0].(*socket.Socket)
+		s.Join("lobby")
+		s.SetData(map[string]any{"user": "alice"})
+	})
+
+	clientOpts := client.DefaultOptions()
+	clientOpts.SetForceNew(true)
+	clientOpts.SetExtraHeaders(headers)
+	c, err := client.Io("http://"+listener.Addr().String(), clientOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connected := make(chan struct{}, 1)
+	c.On("connect", func(...any) {
+		connected <- struct{}{}
+	})
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client did not connect")
+	}
+
+	server := httptest.NewServer(NewHandler(io, opts))
+	t.Cleanup(func() {
+		server.Close()
+		c.Disconnect()
+		io.Close(nil)
+	})
+	return io, c, server
+}
+
+// Sends a request to the API, and returns the status and the body of the response.
+func request(t *testing.T, server *httptest.Server, method string, path string, body string, token string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(data)
+}
+
+func TestSocketHeaders(t *testing.T) {
+	_, c, server := newAPI(t, nil, http.Header{
+		"Authorization": {"Bearer secret"},
+		"Cookie":        {"session=secret"},
+		"X-Custom":      {"value"},
+	})
+
+	status, body := request(t, server, http.MethodGet, "/sockets/"+string(c.Id()), "", "")
+	if status != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", status, body)
+	}
+	details := &socketDetails{}
+	if err := json.Unmarshal([]byte(body), details); err != nil {
+		t.Fatal(err)
+	}
+	if details.Handshake == nil {
+		t.Fatal("missing handshake")
+	}
+	custom := false
+	for name, value := range details.Handshake.Headers {
+		if strings.EqualFold(name, "Authorization") || strings.EqualFold(name, "Cookie") {
+			t.Fatalf("the %s header is exposed", name)
+		}
+		if strings.EqualFold(name, "X-Custom") {
+			custom = value == "value"
+		}
+	}
+	if !custom {
+		t.Fatalf("missing header X-Custom: %v", details.Handshake.Headers)
+	}
+	if strings.Contains(body, "secret") {
+		t.Fatalf("the credentials are exposed: %s", body)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	_, _, open := newAPI(t, nil, nil)
+	if status, body := request(t, open, http.MethodGet, "/namespaces", "", ""); status != http.StatusOK {
+		t.Fatalf("unexpected response %d %s", status, body)
+	}
+	// the sockets cannot be modified without an authorizer
+	if status, body := request(t, open, http.MethodPost, "/emit", `{"event": "news"}`, ""); status != http.StatusForbidden {
+		t.Fatalf("unexpected response %d %s", status, body)
+	}
+
+	opts := DefaultHandlerOptions()
+	opts.SetAuthorize(BearerToken("token"))
+	_, _, server := newAPI(t, opts, nil)
+	for _, token := range []string{"", "wrong"} {
+		if status, body := request(t, server, http.MethodGet, "/namespaces", "", token); status != http.StatusUnauthorized {
+			t.Fatalf("unexpected response %d %s", status, body)
+		}
+	}
+	if status, body := request(t, server, http.MethodPost, "/emit", `{"event": "news"}`, "token"); status != http.StatusNoContent {
+		t.Fatalf("unexpected response %d %s", status, body)
+	}
+
+	opts.SetReadonly(true)
+	if status, body := request(t, server, http.MethodPost, "/emit", `{"event": "news"}`, "token"); status != http.StatusForbidden {
+		t.Fatalf("unexpected response %d %s", status, body)
+	}
+}
+
+func TestReadEndpoints(t *testing.T) {
+	io, c, server := newAPI(t, nil, nil)
+	io.Of("/empty", nil)
+	id := socket.SocketId(c.Id())
+
+	for _, tc := range []struct {
+		path     string
+		status   int
+		expected any
+	}{
+		{"/namespaces", http.StatusOK, []*namespaceDetails{{Name: "/", SocketsCount: 1}, {Name: "/empty", SocketsCount: 0}}},
+		{"/rooms", http.StatusOK, []*roomDetails{{Name: "lobby", Sockets: []socket.SocketId{id}}}},
+		{"/rooms/lobby", http.StatusOK, &roomDetails{Name: "lobby", Sockets: []socket.SocketId{id}}},
+		{"/rooms?nsp=empty", http.StatusOK, []*roomDetails{}},
+		{"/sockets?room=unknown", http.StatusOK, []*socketDetails{}},
+	} {
+		status, body := request(t, server, http.MethodGet, tc.path, "", "")
+		if status != tc.status {
+			t.Fatalf("%s: unexpected response %d %s", tc.path, status, body)
+		}
+		expected, _ := json.Marshal(tc.expected)
+		if body != string(expected) {
+			t.Errorf("%s: expected %s, got %s", tc.path, expected, body)
+		}
+	}
+
+	status, body := request(t, server, http.MethodGet, "/sockets?room=lobby", "", "")
+	sockets := []*socketDetails{}
+	if err := json.Unmarshal([]byte(body), &sockets); status != http.StatusOK || err != nil {
+		t.Fatalf("unexpected response %d %s", status, body)
+	}
+	if len(sockets) != 1 || sockets[0].Id != id || !reflect.DeepEqual(sockets[0].Rooms, []socket.Room{socket.Room(id), "lobby"}) {
+		t.Errorf("unexpected sockets %s", body)
+	}
+	if !reflect.DeepEqual(sockets[0].Data, map[string]any{"user": "alice"}) {
+		t.Errorf("unexpected data %v", sockets[0].Data)
+	}
+
+	for path, status := range map[string]int{
+		"/rooms/unknown":   http.StatusNotFound,
+		"/sockets/unknown": http.StatusNotFound,
+		"/rooms?nsp=other": http.StatusNotFound,
+		"/unknown":         http.StatusNotFound,
+	} {
+		if s, body := request(t, server, http.MethodGet, path, "", ""); s != status {
+			t.Errorf("%s: unexpected response %d %s", path, s, body)
+		}
+	}
+	if status, body := request(t, server, http.MethodPost, "/rooms", "", ""); status != http.StatusMethodNotAllowed {
+		t.Errorf("unexpected response %d %s", status, body)
+	}
+}
+
+func TestWriteEndpoints(t *testing.T) {
+	opts := DefaultHandlerOptions()
+	opts.SetAuthorize(BearerToken("token"))
+	_, c, server := newAPI(t, opts, nil)
+	id := string(c.Id())
+
+	news := make(chan []any, 1)
+	c.On("news", func(args ...any) {
+		news <- args
+	})
+	c.On("ask", func(args ...any) {
+		args[len(args)-1].(func(...any))("pong", args[0])
+	})
+	disconnected := make(chan any, 1)
+	c.On("disconnect", func(args ...any) {
+		disconnected <- args[0]
+	})
+
+	post := func(path string, body string, expected int) string {
+		t.Helper()
+
+		status, response := request(t, server, http.MethodPost, path, body, "token")
+		if status != expected {
+			t.Fatalf("%s %s: unexpected response %d %s", path, body, status, response)
+		}
+		return response
+	}
+
+	post("/emit", `{"event": "news", "data": [1, "a"], "in": ["lobby"]}`, http.StatusNoContent)
+	select {
+	case args := <-news:
+		if !reflect.DeepEqual(args, []any{float64(1), "a"}) {
+			t.Errorf("unexpected event %v", args)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the event was not received")
+	}
+	// the sockets of the excluded rooms are skipped
+	post("/emit", `{"event": "news", "except": ["lobby"]}`, http.StatusNoContent)
+
+	body := post("/emit", `{"event": "ask", "data": [2], "timeout": 1000}`, http.StatusOK)
+	expected := `{"responses":{"` + id + `":["pong",2]},"timedOut":[],"disconnected":[]}`
+	if body != expected {
+		t.Errorf("expected %s, got %s", expected, body)
+	}
+	select {
+	case args := <-news:
+		t.Errorf("the event was sent to an excluded room: %v", args)
+	default:
+	}
+
+	post("/emit", `{"data": [1]}`, http.StatusBadRequest)
+	post("/emit", `{"event": "disconnect"}`, http.StatusBadRequest)
+	post("/emit", `{"event":`, http.StatusBadRequest)
+
+	post("/join", `{"rooms": ["vip"]}`, http.StatusNoContent)
+	if status, body := request(t, server, http.MethodGet, "/rooms/vip", "", "token"); status != http.StatusOK || !strings.Contains(body, id) {
+		t.Errorf("unexpected response %d %s", status, body)
+	}
+	post("/leave", `{"rooms": ["vip"], "in": ["lobby"]}`, http.StatusNoContent)
+	if status, body := request(t, server, http.MethodGet, "/rooms/vip", "", "token"); status != http.StatusNotFound {
+		t.Errorf("unexpected response %d %s", status, body)
+	}
+	post("/join", `{}`, http.StatusBadRequest)
+
+	post("/disconnect", `{"in": ["lobby"]}`, http.StatusNoContent)
+	select {
+	case reason := <-disconnected:
+		if reason != "io server disconnect" {
+			t.Errorf("unexpected reason %v", reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the socket was not disconnected")
+	}
+	if status, body := request(t, server, http.MethodGet, "/sockets", "", "token"); status != http.StatusOK || body != "[]" {
+		t.Errorf("unexpected response %d %s", status, body)
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Returned by an Authorizer when the credentials of the request are missing or invalid.
+var ErrUnauthorized = errors.New("missing or invalid token")
+
+// Checks whether a request is allowed, `write` being true for the requests which modify the sockets (emit, join,
+// leave and disconnect). The request is rejected with a 401 status when it returns ErrUnauthorized (or an error
+// wrapping it), and with a 403 status for any other error.
+type Authorizer func(r *http.Request, write bool) error
+
+// Allows the requests with the given bearer token in their Authorization header. The token must not be empty.
+func BearerToken(token string) Authorizer {
+	if token == "" {
+		panic(errors.New("the bearer token must not be empty"))
+	}
+	return func(r *http.Request, _ bool) error {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(auth[len("Bearer "):]), []byte(token)) != 1 {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+}
+
+type HandlerOptions struct {
+	// checks whether a request is allowed, only the read-only requests are allowed when it is nil
+	authorize Authorizer
+
+	// whether the requests which modify the sockets are rejected
+	readonly *bool
+}
+
+func DefaultHandlerOptions() *HandlerOptions {
+	h := &HandlerOptions{}
+	return h
+}
+
+func (h *HandlerOptions) SetAuthorize(authorize Authorizer) {
+	h.authorize = authorize
+}
+func (h *HandlerOptions) GetRawAuthorize() Authorizer {
+	return h.authorize
+}
+func (h *HandlerOptions) Authorize() Authorizer {
+	return h.authorize
+}
+
+func (h *HandlerOptions) SetReadonly(readonly bool) {
+	h.readonly = &readonly
+}
+func (h *HandlerOptions) GetRawReadonly() *bool {
+	return h.readonly
+}
+func (h *HandlerOptions) Readonly() bool {
+	if h.readonly == nil {
+		return false
+	}
+
+	return *h.readonly
+}
@@ -0,0 +1,12 @@
+// Package clientdist embeds the bundles of the Socket.IO client, which are served by the server under its path (see
+// socket.ServerOptions.SetServeClient()).
+package clientdist
+
+import (
+	"embed"
+)
+
+// The client bundles and their source maps.
+//
+//go:embed *.js *.map
+var FS embed.FS
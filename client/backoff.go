@@ -0,0 +1,67 @@
+package client
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var math_Inf = math.Inf(1)
+
+// Exponential backoff used between two reconnection attempts.
+type backoff struct {
+	ms       float64
+	max      float64
+	factor   float64
+	jitter   float64
+	attempts float64
+
+	mu sync.Mutex
+}
+
+func newBackoff(min time.Duration, max time.Duration, jitter float64) *backoff {
+	b := &backoff{}
+	b.ms = float64(min / time.Millisecond)
+	b.max = float64(max / time.Millisecond)
+	b.factor = 2
+	if jitter > 0 && jitter <= 1 {
+		b.jitter = jitter
+	}
+	b.attempts = 0
+	return b
+}
+
+// Returns the backoff duration.
+func (b *backoff) Duration() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ms := b.ms * math.Pow(b.factor, b.attempts)
+	b.attempts++
+	if b.jitter > 0 {
+		random := rand.Float64()
+		deviation := math.Floor(random * b.jitter * ms)
+		if int(math.Floor(random*10))&1 == 0 {
+			ms = ms - deviation
+		} else {
+			ms = ms + deviation
+		}
+	}
+	return time.Duration(math.Min(ms, b.max)) * time.Millisecond
+}
+
+// Reset the number of attempts.
+func (b *backoff) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.attempts = 0
+}
+
+func (b *backoff) Attempts() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.attempts
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	b := newBackoff(100*time.Millisecond, 500*time.Millisecond, 0)
+	for i, expected := range []time.Duration{100, 200, 400, 500, 500} {
+		if d := b.Duration(); d != expected*time.Millisecond {
+			t.Errorf("attempt %d: expected %v, got %v", i, expected*time.Millisecond, d)
+		}
+	}
+	if b.Attempts() != 5 {
+		t.Errorf("expected 5 attempts, got %v", b.Attempts())
+	}
+	b.Reset()
+	if d := b.Duration(); d != 100*time.Millisecond {
+		t.Errorf("expected 100ms after a reset, got %v", d)
+	}
+
+	jittered := newBackoff(100*time.Millisecond, time.Second, 0.5)
+	for i := 0; i < 20; i++ {
+		jittered.Reset()
+		if d := jittered.Duration(); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("expected a duration between 50ms and 150ms, got %v", d)
+		}
+	}
+}
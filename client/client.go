@@ -0,0 +1,68 @@
+package client
+
+import (
+	"net/url"
+	"sync"
+
+	"github.com/zishang520/engine.io/log"
+)
+
+var (
+	client_log = log.NewLog("socket.io-client")
+	cache      = &sync.Map{}
+)
+
+// Looks up an existing Manager for multiplexing. If the user summons:
+//
+//	client.Io("http://localhost/a")
+//	client.Io("http://localhost/b")
+//
+// We reuse the existing instance based on same scheme/port/host, and we initialize sockets for each namespace.
+func Io(uri string, opts *Options) (*Socket, error) {
+	if opts == nil {
+		opts = DefaultOptions()
+	}
+
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	nsp := parsed.Path
+	if nsp == "" {
+		nsp = "/"
+	}
+	id := parsed.Scheme + "://" + parsed.Host
+	source := &url.URL{Scheme: parsed.Scheme, Host: parsed.Host, User: parsed.User}
+	if len(parsed.Query()) > 0 && opts.Query() == nil {
+		opts.SetQuery(parsed.Query())
+	}
+
+	var io *Manager
+	_io, cached := cache.Load(id)
+	sameNamespace := cached && _io.(*Manager).hasSocket(nsp)
+	newConnection := opts.ForceNew() || !opts.Multiplex() || sameNamespace
+
+	if newConnection {
+		client_log.Debug("ignoring socket cache for %s", source.String())
+		io, err = NewManager(source.String(), &opts.ManagerOptions)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if !cached {
+			client_log.Debug("new io instance for %s", source.String())
+			manager, err := NewManager(source.String(), &opts.ManagerOptions)
+			if err != nil {
+				return nil, err
+			}
+			_io, _ = cache.LoadOrStore(id, manager)
+		}
+		io = _io.(*Manager)
+	}
+	return io.Socket(nsp, &opts.SocketOptions), nil
+}
+
+// Alias for Io().
+func Connect(uri string, opts *Options) (*Socket, error) {
+	return Io(uri, opts)
+}
@@ -0,0 +1,287 @@
+package client_test
+
+import (
+	"errors"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zishang520/socket.io/client"
+	"github.com/zishang520/socket.io/parser"
+	"github.com/zishang520/socket.io/socket"
+)
+
+// Starts a server listening on a random port, closed at the end of the test, and returns its address.
+func newTestServer(t *testing.T, opts *socket.ServerOptions) (*socket.Server, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io := socket.NewServer(listener, opts)
+	t.Cleanup(func() {
+		io.Close(nil)
+	})
+	return io, "http://" + listener.Addr().String()
+}
+
+// Creates a socket, disconnected at the end of the test.
+func newSocket(t *testing.T, url string, opts *client.Options) *client.Socket {
+	t.Helper()
+
+	c, err := client.Io(url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Disconnect()
+	})
+	return c
+}
+
+// Waits for a value sent to the channel.
+func receive[T any](t *testing.T, values chan T) T {
+	t.Helper()
+
+	select {
+	case value := <-values:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout reached")
+	}
+	var zero T
+	return zero
+}
+
+func TestEmit(t *testing.T) {
+	io, url := newTestServer(t, nil)
+	io.On("connection", func(args ...any) {
+		s := args[0].(*socket.Socket)
+		s.On("hello", func(args ...any) {
+			args[len(args)-1].(func(...any))("world", args[0], s.Handshake().Auth)
+		})
+		s.On("binary", func(args ...any) {
+			s.Emit("binary", args[0])
+		})
+		s.Emit("welcome", 1)
+	})
+
+	opts := client.DefaultOptions()
+	opts.SetForceNew(true)
+	opts.SetAuth(map[string]any{"token": "abc"})
+	c := newSocket(t, url, opts)
+
+	welcome := make(chan []any, 1)
+	c.On("welcome", func(args ...any) {
+		welcome <- args
+	})
+	// emitted before the connection, so buffered until then
+	acks := make(chan []any, 1)
+	c.Emit("hello", "x", func(args ...any) {
+		acks <- args
+	})
+	binary := make(chan any, 1)
+	c.On("binary", func(args ...any) {
+		binary <- args[0]
+	})
+	c.Emit("binary", []byte{1, 2, 3})
+
+	if args := receive(t, welcome); !reflect.DeepEqual(args, []any{float64(1)}) {
+		t.Errorf("unexpected welcome: %v", args)
+	}
+	expected := []any{"world", "x", map[string]any{"token": "abc"}}
+	if args := receive(t, acks); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected the ack %v, got %v", expected, args)
+	}
+	data, ok := receive(t, binary).(interface{ Bytes() []byte })
+	if !ok || !reflect.DeepEqual(data.Bytes(), []byte{1, 2, 3}) {
+		t.Errorf("unexpected binary data: %v", data)
+	}
+	if !c.Connected() || c.Id() == "" {
+		t.Errorf("expected a connected socket with an id")
+	}
+}
+
+func TestAckTimeout(t *testing.T) {
+	io, url := newTestServer(t, nil)
+	io.On("connection", func(args ...any) {
+		args[0].(*socket.Socket).On("ignored", func(...any) {})
+	})
+
+	opts := client.DefaultOptions()
+	opts.SetForceNew(true)
+	c := newSocket(t, url, opts)
+
+	acks := make(chan []any, 1)
+	c.Timeout(50*time.Millisecond).Emit("ignored", func(args ...any) {
+		acks <- args
+	})
+	if args := receive(t, acks); len(args) != 1 || args[0] == nil {
+		t.Errorf("expected a timeout error, got %v", args)
+	}
+}
+
+func TestMultiplex(t *testing.T) {
+	io, url := newTestServer(t, nil)
+	namespaces := make(chan string, 2)
+	for _, nsp := range []string{"/", "/admin"} {
+		io.Of(nsp, nil).On("connection", func(args ...any) {
+			namespaces <- args[0].(*socket.Socket).Nsp().Name()
+		})
+	}
+
+	root := newSocket(t, url, nil)
+	admin := newSocket(t, url+"/admin", nil)
+	if root.Io() != admin.Io() {
+		t.Errorf("expected the namespaces to share the manager")
+	}
+	received := map[string]bool{receive(t, namespaces): true, receive(t, namespaces): true}
+	if !received["/"] || !received["/admin"] {
+		t.Errorf("unexpected connections: %v", received)
+	}
+}
+
+func TestConnectError(t *testing.T) {
+	io, url := newTestServer(t, nil)
+	io.Of("/admin", nil).Use(func(s *socket.Socket, next func(*socket.ExtendedError)) {
+		next(socket.NewExtendedError("not authorized", map[string]any{"code": 1}))
+	})
+
+	opts := client.DefaultOptions()
+	opts.SetForceNew(true)
+	c := newSocket(t, url+"/admin", opts)
+
+	errs := make(chan any, 1)
+	c.On("connect_error", func(args ...any) {
+		errs <- args[0]
+	})
+	err, ok := receive(t, errs).(*socket.ExtendedError)
+	if !ok || err.Error() != "not authorized" || !reflect.DeepEqual(err.Data(), map[string]any{"code": float64(1)}) {
+		t.Errorf("unexpected connect error: %v", err)
+	}
+	if c.Connected() {
+		t.Errorf("expected the socket not to be connected")
+	}
+}
+
+func TestReconnection(t *testing.T) {
+	serverOpts := socket.DefaultServerOptions()
+	serverOpts.SetConnectionStateRecovery(socket.DefaultConnectionStateRecovery())
+	io, url := newTestServer(t, serverOpts)
+	io.On("connection", func(args ...any) {
+		s := args[0].(*socket.Socket)
+		if !s.Recovered() {
+			s.Join("room")
+		}
+	})
+
+	opts := client.DefaultOptions()
+	opts.SetForceNew(true)
+	opts.SetReconnectionDelay(50 * time.Millisecond)
+	c := newSocket(t, url, opts)
+
+	connected := make(chan bool, 2)
+	c.On("connect", func(...any) {
+		connected <- c.Recovered()
+	})
+	news := make(chan any, 2)
+	c.On("news", func(args ...any) {
+		news <- args[0]
+	})
+	disconnected := make(chan any, 1)
+	c.On("disconnect", func(args ...any) {
+		disconnected <- args[0]
+	})
+	if receive(t, connected) {
+		t.Errorf("expected the first connection not to be recovered")
+	}
+	id := c.Id()
+	// the offset of the last packet received is sent upon reconnection
+	io.To("room").Emit("news", 1)
+	if v := receive(t, news); v != float64(1) {
+		t.Errorf("expected 1, got %v", v)
+	}
+
+	// closes the low-level connection, like a network failure
+	io.Sockets().Sockets().Range(func(_, s any) bool {
+		s.(*socket.Socket).Conn().Close(false)
+		return true
+	})
+	receive(t, disconnected)
+	// missed by the client, then sent once the connection is recovered
+	io.To("room").Emit("news", 2)
+
+	if !receive(t, connected) {
+		t.Errorf("expected the connection to be recovered")
+	}
+	if c.Id() != id {
+		t.Errorf("expected the id %s to be kept, got %s", id, c.Id())
+	}
+	if v := receive(t, news); v != float64(2) {
+		t.Errorf("expected the missed packet, got %v", v)
+	}
+}
+
+func TestReconnectionAttempts(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing listens on the address anymore
+	listener.Close()
+
+	opts := client.DefaultOptions()
+	opts.SetForceNew(true)
+	opts.SetReconnectionAttempts(2)
+	opts.SetReconnectionDelay(10 * time.Millisecond)
+	opts.SetReconnectionDelayMax(10 * time.Millisecond)
+	c := newSocket(t, "http://"+listener.Addr().String(), opts)
+
+	attempts := make(chan any, 3)
+	c.Io().On("reconnect_attempt", func(args ...any) {
+		attempts <- args[0]
+	})
+	failed := make(chan struct{}, 1)
+	c.Io().On("reconnect_failed", func(...any) {
+		failed <- struct{}{}
+	})
+	receive(t, failed)
+	if len(attempts) != 2 {
+		t.Errorf("expected 2 reconnection attempts, got %d", len(attempts))
+	}
+}
+
+func TestEmitEncodeError(t *testing.T) {
+	io, url := newTestServer(t, nil)
+	received := make(chan []any, 2)
+	io.On("connection", func(args ...any) {
+		args[0].(*socket.Socket).OnAny(func(args ...any) {
+			received <- args
+		})
+	})
+
+	opts := client.DefaultOptions()
+	opts.SetForceNew(true)
+	c := newSocket(t, url, opts)
+	connected := make(chan struct{}, 1)
+	c.On("connect", func(...any) {
+		connected <- struct{}{}
+	})
+	receive(t, connected)
+
+	var encodeErr *parser.EncodeError
+	if err := c.Emit("ev", make(chan int)); !errors.As(err, &encodeErr) {
+		t.Fatalf("expected an *EncodeError, got %v", err)
+	}
+	if err := c.Emit("ev", math.NaN(), func(...any) {}); !errors.As(err, &encodeErr) {
+		t.Fatalf("expected an *EncodeError, got %v", err)
+	}
+	// the packets which cannot be encoded are not sent, not even without their data
+	c.Emit("ev", "ok")
+	if args := receive(t, received); !reflect.DeepEqual(args, []any{"ev", "ok"}) {
+		t.Errorf("expected only the last event, got %v", args)
+	}
+}
@@ -0,0 +1,262 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	ws "github.com/gorilla/websocket"
+	"github.com/zishang520/engine.io/events"
+	"github.com/zishang520/engine.io/log"
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/engine.io/utils"
+)
+
+var engine_log = log.NewLog("engine.io-client:socket")
+
+// Engine.IO protocol revision spoken by the client.
+const engineProtocol = 4
+
+type handshake struct {
+	Sid          string   `json:"sid"`
+	Upgrades     []string `json:"upgrades"`
+	PingInterval int64    `json:"pingInterval"`
+	PingTimeout  int64    `json:"pingTimeout"`
+	MaxPayload   int64    `json:"maxPayload"`
+}
+
+// A minimal Engine.IO client speaking the v4 protocol over the WebSocket transport.
+//
+// Events:
+//   - `open`  the handshake was received
+//   - `data`  a message was received (string or []byte)
+//   - `ping`  a ping was received from the server
+//   - `error` a transport error happened
+//   - `close` the connection was closed (reason, description)
+type engineSocket struct {
+	events.EventEmitter
+
+	conn *ws.Conn
+	id   string
+
+	pingInterval time.Duration
+	pingTimeout  time.Duration
+	maxPayload   int64
+
+	readyState       string
+	pingTimeoutTimer *utils.Timer
+
+	mu_readyState       sync.RWMutex
+	mu_pingTimeoutTimer sync.Mutex
+	mu_write            sync.Mutex
+}
+
+// Opens an Engine.IO connection and waits for the handshake.
+func dialEngine(uri *url.URL, opts *ManagerOptions) (*engineSocket, error) {
+	target := *uri
+	switch target.Scheme {
+	case "https", "wss":
+		target.Scheme = "wss"
+	default:
+		target.Scheme = "ws"
+	}
+	target.Path = strings.TrimRight(opts.Path(), "/") + "/"
+	query := url.Values{}
+	for k, v := range opts.Query() {
+		query[k] = append([]string{}, v...)
+	}
+	query.Set("EIO", fmt.Sprint(engineProtocol))
+	query.Set("transport", "websocket")
+	target.RawQuery = query.Encode()
+
+	dialer := &ws.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: opts.Timeout(),
+	}
+	engine_log.Debug("opening %s", target.String())
+	conn, _, err := dialer.Dial(target.String(), opts.ExtraHeaders())
+	if err != nil {
+		return nil, err
+	}
+
+	e := &engineSocket{}
+	e.EventEmitter = events.New()
+	e.conn = conn
+	e.readyState = "opening"
+
+	// the first packet sent by the server must be the handshake
+	conn.SetReadDeadline(time.Now().Add(opts.Timeout()))
+	mt, message, err := conn.ReadMessage()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+	if mt != ws.TextMessage || len(message) == 0 || message[0] != '0' {
+		conn.Close()
+		return nil, errors.New("invalid handshake")
+	}
+	var data *handshake
+	if err := json.Unmarshal(message[1:], &data); err != nil || data == nil {
+		conn.Close()
+		return nil, errors.New("invalid handshake")
+	}
+	e.onHandshake(data)
+
+	go e.read()
+
+	return e, nil
+}
+
+func (e *engineSocket) Id() string {
+	return e.id
+}
+
+func (e *engineSocket) ReadyState() string {
+	e.mu_readyState.RLock()
+	defer e.mu_readyState.RUnlock()
+
+	return e.readyState
+}
+
+func (e *engineSocket) setReadyState(readyState string) {
+	e.mu_readyState.Lock()
+	defer e.mu_readyState.Unlock()
+
+	e.readyState = readyState
+}
+
+// Called upon handshake completion.
+func (e *engineSocket) onHandshake(data *handshake) {
+	e.id = data.Sid
+	e.pingInterval = time.Duration(data.PingInterval) * time.Millisecond
+	e.pingTimeout = time.Duration(data.PingTimeout) * time.Millisecond
+	e.maxPayload = data.MaxPayload
+	e.setReadyState("open")
+	engine_log.Debug("socket open %s", e.id)
+	e.resetPingTimeout()
+}
+
+// Sets and resets ping timeout timer based on server pings.
+func (e *engineSocket) resetPingTimeout() {
+	e.mu_pingTimeoutTimer.Lock()
+	defer e.mu_pingTimeoutTimer.Unlock()
+
+	utils.ClearTimeout(e.pingTimeoutTimer)
+	e.pingTimeoutTimer = utils.SetTimeOut(func() {
+		e.onClose("ping timeout", nil)
+	}, e.pingInterval+e.pingTimeout)
+}
+
+// Reads the incoming frames until the connection is closed.
+func (e *engineSocket) read() {
+	for {
+		mt, message, err := e.conn.ReadMessage()
+		if err != nil {
+			if e.ReadyState() == "open" {
+				if ws.IsCloseError(err, ws.CloseNormalClosure, ws.CloseGoingAway) {
+					e.onClose("transport close", nil)
+				} else {
+					e.onError(err)
+				}
+			}
+			return
+		}
+		switch mt {
+		case ws.BinaryMessage:
+			e.Emit("data", message)
+		case ws.TextMessage:
+			e.onPacket(message)
+		}
+	}
+}
+
+// Handles a text packet.
+func (e *engineSocket) onPacket(message []byte) {
+	if len(message) == 0 {
+		return
+	}
+	engine_log.Debug(`socket receive: type "%c", data "%s"`, message[0], message[1:])
+	switch message[0] {
+	case '1': // close
+		e.onClose("transport close", nil)
+	case '2': // ping
+		e.resetPingTimeout()
+		e.write(ws.TextMessage, []byte{'3'})
+		e.Emit("ping")
+	case '4': // message
+		e.Emit("data", string(message[1:]))
+	case '6': // noop
+	default:
+		engine_log.Debug("ignoring packet of type %c", message[0])
+	}
+}
+
+// Sends a message.
+func (e *engineSocket) Write(data types.BufferInterface) error {
+	switch data.(type) {
+	case *types.StringBuffer:
+		return e.write(ws.TextMessage, append([]byte{'4'}, data.Bytes()...))
+	default:
+		return e.write(ws.BinaryMessage, data.Bytes())
+	}
+}
+
+func (e *engineSocket) write(messageType int, data []byte) error {
+	if e.ReadyState() != "open" {
+		return errors.New("socket is not open")
+	}
+	if e.maxPayload > 0 && int64(len(data)) > e.maxPayload {
+		return errors.New("payload exceeds the maximum size allowed by the server")
+	}
+
+	e.mu_write.Lock()
+	defer e.mu_write.Unlock()
+
+	if err := e.conn.WriteMessage(messageType, data); err != nil {
+		go e.onError(err)
+		return err
+	}
+	return nil
+}
+
+// Closes the connection.
+func (e *engineSocket) Close() {
+	if e.ReadyState() == "open" {
+		e.write(ws.TextMessage, []byte{'1'})
+		e.onClose("forced close", nil)
+	}
+}
+
+// Called upon transport error.
+func (e *engineSocket) onError(err error) {
+	engine_log.Debug("socket error %v", err)
+	e.Emit("error", err)
+	e.onClose("transport error", err)
+}
+
+// Called upon transport close.
+func (e *engineSocket) onClose(reason string, description error) {
+	e.mu_readyState.Lock()
+	if e.readyState != "open" && e.readyState != "opening" {
+		e.mu_readyState.Unlock()
+		return
+	}
+	e.readyState = "closed"
+	e.mu_readyState.Unlock()
+
+	engine_log.Debug(`socket close with reason: "%s"`, reason)
+
+	e.mu_pingTimeoutTimer.Lock()
+	utils.ClearTimeout(e.pingTimeoutTimer)
+	e.pingTimeoutTimer = nil
+	e.mu_pingTimeoutTimer.Unlock()
+
+	e.conn.Close()
+	e.Emit("close", reason, description)
+}
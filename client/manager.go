@@ -0,0 +1,442 @@
+package client
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+
+	"github.com/zishang520/engine.io/log"
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/engine.io/utils"
+	"github.com/zishang520/socket.io/parser"
+	"github.com/zishang520/socket.io/socket"
+)
+
+var manager_log = log.NewLog("socket.io-client:manager")
+
+// A Manager handles the underlying Engine.IO connection and the namespaces (Socket instances) multiplexed over it.
+//
+// Events:
+//   - `open`              the connection was established
+//   - `error`             the connection failed (error)
+//   - `ping`              a ping packet was received from the server
+//   - `packet`            a packet was decoded (*parser.Packet)
+//   - `close`             the connection was closed (reason)
+//   - `reconnect_attempt` a reconnection is attempted (attempt number)
+//   - `reconnect`         the reconnection succeeded (attempt number)
+//   - `reconnect_error`   a reconnection attempt failed (error)
+//   - `reconnect_failed`  the maximum number of reconnection attempts was reached
+type Manager struct {
+	*socket.StrictEventEmitter
+
+	uri     *url.URL
+	opts    *ManagerOptions
+	engine  *engineSocket
+	encoder parser.Encoder
+	decoder parser.Decoder
+	backoff *backoff
+
+	nsps           *sync.Map
+	sockets        *types.Set[*Socket]
+	readyState     string
+	skipReconnect  bool
+	reconnecting   bool
+	reconnectTimer *utils.Timer
+
+	mu sync.RWMutex
+}
+
+// Manager constructor.
+func NewManager(uri string, opts *ManagerOptions) (*Manager, error) {
+	_uri, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if opts == nil {
+		opts = DefaultManagerOptions()
+	}
+
+	m := &Manager{}
+	m.StrictEventEmitter = socket.NewStrictEventEmitter()
+	m.uri = _uri
+	m.opts = opts
+	m.nsps = &sync.Map{}
+	m.sockets = types.NewSet[*Socket]()
+	m.readyState = "closed"
+	m.backoff = newBackoff(opts.ReconnectionDelay(), opts.ReconnectionDelayMax(), opts.RandomizationFactor())
+	m.encoder = opts.Parser().Encoder()
+
+	if opts.AutoConnect() {
+		m.Open(nil)
+	}
+
+	return m, nil
+}
+
+func (m *Manager) Opts() *ManagerOptions {
+	return m.opts
+}
+
+func (m *Manager) ReadyState() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.readyState
+}
+
+func (m *Manager) Reconnecting() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.reconnecting
+}
+
+// The Engine.IO session id, empty when not connected.
+func (m *Manager) EngineId() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.engine == nil {
+		return ""
+	}
+	return m.engine.Id()
+}
+
+// Opens the connection, if not already opened or being opened.
+//
+// The callback is called with a nil error once the connection is established.
+func (m *Manager) Open(fn func(error)) *Manager {
+	m.mu.Lock()
+	if m.readyState == "open" || m.readyState == "opening" {
+		m.mu.Unlock()
+		return m
+	}
+	manager_log.Debug("opening %s", m.uri.String())
+	m.readyState = "opening"
+	m.skipReconnect = false
+	m.mu.Unlock()
+
+	go func() {
+		engine, err := dialEngine(m.uri, m.opts)
+		if err != nil {
+			manager_log.Debug("error %v", err)
+			m.mu.Lock()
+			m.readyState = "closed"
+			m.mu.Unlock()
+			m.onerror(err)
+			if fn != nil {
+				fn(err)
+			} else {
+				// Only do this if there is no fn to handle the error
+				m.maybeReconnectOnOpen()
+			}
+			return
+		}
+		m.mu.RLock()
+		skipReconnect := m.skipReconnect
+		m.mu.RUnlock()
+		if skipReconnect {
+			// the manager was closed in the meantime
+			engine.Close()
+			m.mu.Lock()
+			m.readyState = "closed"
+			m.mu.Unlock()
+			if fn != nil {
+				fn(errors.New("the manager was closed"))
+			}
+			return
+		}
+		m.onopen(engine)
+		if fn != nil {
+			fn(nil)
+		}
+	}()
+
+	return m
+}
+
+// Alias for Open()
+func (m *Manager) Connect(fn func(error)) *Manager {
+	return m.Open(fn)
+}
+
+// Called upon transport open.
+func (m *Manager) onopen(engine *engineSocket) {
+	manager_log.Debug("open")
+
+	m.mu.Lock()
+	m.engine = engine
+	m.decoder = m.opts.Parser().Decoder()
+	m.readyState = "open"
+	// the sockets subscribed from now on see the connection as open, see subscribe()
+	sockets := m.sockets.Keys()
+	m.mu.Unlock()
+
+	m.decoder.On("decoded", m.ondecoded)
+	m.decoder.On("error", m.ondecodeerror)
+	engine.On("data", m.ondata)
+	engine.On("ping", m.onping)
+	engine.On("error", m.onerror)
+	engine.On("close", m.onclose)
+
+	m.EmitReserved("open")
+
+	for _, socket := range sockets {
+		socket.onopen()
+	}
+}
+
+// Called upon a ping.
+func (m *Manager) onping(...any) {
+	m.EmitReserved("ping")
+}
+
+// Called with data.
+func (m *Manager) ondata(args ...any) {
+	m.mu.RLock()
+	decoder := m.decoder
+	m.mu.RUnlock()
+
+	if decoder == nil {
+		return
+	}
+	if err := decoder.Add(args[0]); err != nil {
+		m.ondecodeerror(err)
+	}
+}
+
+// Called when a packet is invalid or exceeds the limits of the parser.
+func (m *Manager) ondecodeerror(args ...any) {
+	manager_log.Debug("invalid packet format")
+	m.mu.RLock()
+	engine := m.engine
+	m.mu.RUnlock()
+	m.onclose("parse error", args[0])
+	if engine != nil {
+		engine.Close()
+	}
+}
+
+// Called when parser fully decodes a packet.
+func (m *Manager) ondecoded(args ...any) {
+	packet, _ := args[0].(*parser.Packet)
+	m.EmitReserved("packet", packet)
+	for _, socket := range m.sockets.Keys() {
+		socket.onpacket(packet)
+	}
+}
+
+// Called upon socket error.
+func (m *Manager) onerror(args ...any) {
+	manager_log.Debug("error %v", args[0])
+	m.EmitReserved("error", args[0])
+	for _, socket := range m.sockets.Keys() {
+		socket.onerror(args[0])
+	}
+}
+
+// Creates a new socket for the given `nsp`.
+func (m *Manager) Socket(nsp string, opts *SocketOptions) *Socket {
+	if socket, ok := m.nsps.Load(nsp); ok {
+		return socket.(*Socket)
+	}
+	socket := NewSocket(m, nsp, opts)
+	if actual, loaded := m.nsps.LoadOrStore(nsp, socket); loaded {
+		return actual.(*Socket)
+	}
+	if m.opts.AutoConnect() {
+		socket.Connect()
+	}
+	return socket
+}
+
+func (m *Manager) hasSocket(nsp string) bool {
+	_, ok := m.nsps.Load(nsp)
+	return ok
+}
+
+// Subscribes a socket to the lifecycle of the connection. Returns whether the connection is already open, in which
+// case the socket is not notified by onopen() and has to connect by itself.
+func (m *Manager) subscribe(socket *Socket) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sockets.Add(socket)
+	return m.readyState == "open"
+}
+
+// Unsubscribes a socket from the lifecycle of the connection.
+func (m *Manager) unsubscribe(socket *Socket) {
+	m.sockets.Delete(socket)
+}
+
+// Called upon a socket close.
+func (m *Manager) _destroy(socket *Socket) {
+	for _, s := range m.sockets.Keys() {
+		if s.Active() {
+			manager_log.Debug("socket %s is still active, skipping close", s.nsp)
+			return
+		}
+	}
+	m._close()
+}
+
+// Writes a packet.
+func (m *Manager) _packet(packet *parser.Packet) error {
+	manager_log.Debug("writing packet %v", packet)
+
+	m.mu.RLock()
+	engine := m.engine
+	m.mu.RUnlock()
+
+	if engine == nil {
+		return errors.New("the connection is not open")
+	}
+	encodedPackets, err := m.encoder.Encode(packet)
+	if err != nil {
+		return err
+	}
+	for _, encodedPacket := range encodedPackets {
+		if err := engine.Write(encodedPacket); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clean up transport subscriptions and packet buffer.
+func (m *Manager) cleanup() {
+	manager_log.Debug("cleanup")
+
+	m.mu.Lock()
+	engine, decoder := m.engine, m.decoder
+	m.engine, m.decoder = nil, nil
+	m.mu.Unlock()
+
+	if engine != nil {
+		engine.Clear()
+	}
+	if decoder != nil {
+		decoder.Clear()
+		decoder.Destroy()
+	}
+}
+
+// Close the current socket.
+func (m *Manager) _close() {
+	manager_log.Debug("disconnect")
+
+	m.mu.Lock()
+	m.skipReconnect = true
+	m.reconnecting = false
+	utils.ClearTimeout(m.reconnectTimer)
+	m.reconnectTimer = nil
+	engine := m.engine
+	m.mu.Unlock()
+
+	if engine != nil {
+		// the close event is emitted synchronously by the engine
+		engine.Close()
+	} else {
+		m.onclose("forced close", nil)
+	}
+}
+
+// Alias for _close()
+func (m *Manager) Disconnect() {
+	m._close()
+}
+
+// Called upon engine close.
+func (m *Manager) onclose(args ...any) {
+	reason, _ := args[0].(string)
+	manager_log.Debug("closed due to %s", reason)
+
+	m.cleanup()
+	m.backoff.Reset()
+
+	m.mu.Lock()
+	if m.readyState == "closed" {
+		m.mu.Unlock()
+		return
+	}
+	m.readyState = "closed"
+	skipReconnect := m.skipReconnect
+	m.mu.Unlock()
+
+	m.EmitReserved("close", reason)
+	for _, socket := range m.sockets.Keys() {
+		socket.onclose(reason)
+	}
+
+	if m.opts.Reconnection() && !skipReconnect {
+		m.reconnect()
+	}
+}
+
+// Called upon a failed connection attempt, when there is no callback to handle it.
+func (m *Manager) maybeReconnectOnOpen() {
+	m.mu.RLock()
+	reconnect := !m.reconnecting && !m.skipReconnect && m.opts.Reconnection() && m.backoff.Attempts() == 0
+	m.mu.RUnlock()
+
+	// Only try to reconnect if it's the first time we're connecting
+	if reconnect {
+		// keeps reconnection from firing twice for the same reconnection loop
+		m.reconnect()
+	}
+}
+
+// Attempt a reconnection.
+func (m *Manager) reconnect() {
+	m.mu.Lock()
+	if m.reconnecting || m.skipReconnect {
+		m.mu.Unlock()
+		return
+	}
+
+	if m.backoff.Attempts() >= m.opts.ReconnectionAttempts() {
+		manager_log.Debug("reconnect failed")
+		m.backoff.Reset()
+		m.mu.Unlock()
+		m.EmitReserved("reconnect_failed")
+		return
+	}
+
+	delay := m.backoff.Duration()
+	manager_log.Debug("will wait %dms before reconnect attempt", delay.Milliseconds())
+	m.reconnecting = true
+	m.reconnectTimer = utils.SetTimeOut(func() {
+		m.mu.RLock()
+		skipReconnect := m.skipReconnect
+		m.mu.RUnlock()
+		if skipReconnect {
+			return
+		}
+
+		manager_log.Debug("attempting reconnect")
+		m.EmitReserved("reconnect_attempt", m.backoff.Attempts())
+
+		m.Open(func(err error) {
+			m.mu.Lock()
+			m.reconnecting = false
+			m.mu.Unlock()
+			if err != nil {
+				manager_log.Debug("reconnect attempt error")
+				m.reconnect()
+				m.EmitReserved("reconnect_error", err)
+			} else {
+				manager_log.Debug("reconnect success")
+				m.onreconnect()
+			}
+		})
+	}, delay)
+	m.mu.Unlock()
+}
+
+// Called upon successful reconnect.
+func (m *Manager) onreconnect() {
+	attempt := m.backoff.Attempts()
+	m.backoff.Reset()
+	m.EmitReserved("reconnect", attempt)
+}
@@ -0,0 +1,250 @@
+package client
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/zishang520/socket.io/parser"
+)
+
+type ManagerOptions struct {
+	// the path the server is listening on
+	path *string
+
+	// additional query parameters sent during the handshake
+	query url.Values
+
+	// additional headers sent during the handshake
+	extraHeaders http.Header
+
+	// whether to reconnect automatically
+	reconnection *bool
+
+	// number of reconnection attempts before giving up
+	reconnectionAttempts *float64
+
+	// how long to initially wait before attempting a new reconnection
+	reconnectionDelay *time.Duration
+
+	// maximum amount of time to wait between reconnections
+	reconnectionDelayMax *time.Duration
+
+	// used in the exponential backoff jitter when reconnecting
+	randomizationFactor *float64
+
+	// the timeout for each connection attempt
+	timeout *time.Duration
+
+	// whether to automatically connect upon creation
+	autoConnect *bool
+
+	// the parser to use
+	parser parser.Parser
+}
+
+func DefaultManagerOptions() *ManagerOptions {
+	m := &ManagerOptions{}
+	return m
+}
+
+func (m *ManagerOptions) SetPath(path string) {
+	m.path = &path
+}
+func (m *ManagerOptions) GetRawPath() *string {
+	return m.path
+}
+func (m *ManagerOptions) Path() string {
+	if m.path == nil {
+		return "/socket.io"
+	}
+
+	return *m.path
+}
+
+func (m *ManagerOptions) SetQuery(query url.Values) {
+	m.query = query
+}
+func (m *ManagerOptions) Query() url.Values {
+	return m.query
+}
+
+func (m *ManagerOptions) SetExtraHeaders(extraHeaders http.Header) {
+	m.extraHeaders = extraHeaders
+}
+func (m *ManagerOptions) ExtraHeaders() http.Header {
+	return m.extraHeaders
+}
+
+func (m *ManagerOptions) SetReconnection(reconnection bool) {
+	m.reconnection = &reconnection
+}
+func (m *ManagerOptions) GetRawReconnection() *bool {
+	return m.reconnection
+}
+func (m *ManagerOptions) Reconnection() bool {
+	if m.reconnection == nil {
+		return true
+	}
+
+	return *m.reconnection
+}
+
+func (m *ManagerOptions) SetReconnectionAttempts(reconnectionAttempts float64) {
+	m.reconnectionAttempts = &reconnectionAttempts
+}
+func (m *ManagerOptions) GetRawReconnectionAttempts() *float64 {
+	return m.reconnectionAttempts
+}
+func (m *ManagerOptions) ReconnectionAttempts() float64 {
+	if m.reconnectionAttempts == nil {
+		return math_Inf
+	}
+
+	return *m.reconnectionAttempts
+}
+
+func (m *ManagerOptions) SetReconnectionDelay(reconnectionDelay time.Duration) {
+	m.reconnectionDelay = &reconnectionDelay
+}
+func (m *ManagerOptions) GetRawReconnectionDelay() *time.Duration {
+	return m.reconnectionDelay
+}
+func (m *ManagerOptions) ReconnectionDelay() time.Duration {
+	if m.reconnectionDelay == nil {
+		return time.Duration(1000 * time.Millisecond)
+	}
+
+	return *m.reconnectionDelay
+}
+
+func (m *ManagerOptions) SetReconnectionDelayMax(reconnectionDelayMax time.Duration) {
+	m.reconnectionDelayMax = &reconnectionDelayMax
+}
+func (m *ManagerOptions) GetRawReconnectionDelayMax() *time.Duration {
+	return m.reconnectionDelayMax
+}
+func (m *ManagerOptions) ReconnectionDelayMax() time.Duration {
+	if m.reconnectionDelayMax == nil {
+		return time.Duration(5000 * time.Millisecond)
+	}
+
+	return *m.reconnectionDelayMax
+}
+
+func (m *ManagerOptions) SetRandomizationFactor(randomizationFactor float64) {
+	m.randomizationFactor = &randomizationFactor
+}
+func (m *ManagerOptions) GetRawRandomizationFactor() *float64 {
+	return m.randomizationFactor
+}
+func (m *ManagerOptions) RandomizationFactor() float64 {
+	if m.randomizationFactor == nil {
+		return 0.5
+	}
+
+	return *m.randomizationFactor
+}
+
+func (m *ManagerOptions) SetTimeout(timeout time.Duration) {
+	m.timeout = &timeout
+}
+func (m *ManagerOptions) GetRawTimeout() *time.Duration {
+	return m.timeout
+}
+func (m *ManagerOptions) Timeout() time.Duration {
+	if m.timeout == nil {
+		return time.Duration(20000 * time.Millisecond)
+	}
+
+	return *m.timeout
+}
+
+func (m *ManagerOptions) SetAutoConnect(autoConnect bool) {
+	m.autoConnect = &autoConnect
+}
+func (m *ManagerOptions) GetRawAutoConnect() *bool {
+	return m.autoConnect
+}
+func (m *ManagerOptions) AutoConnect() bool {
+	if m.autoConnect == nil {
+		return true
+	}
+
+	return *m.autoConnect
+}
+
+func (m *ManagerOptions) SetParser(parser parser.Parser) {
+	m.parser = parser
+}
+func (m *ManagerOptions) GetRawParser() parser.Parser {
+	return m.parser
+}
+func (m *ManagerOptions) Parser() parser.Parser {
+	if m.parser == nil {
+		return parser.NewParser()
+	}
+	return m.parser
+}
+
+type SocketOptions struct {
+	// the authentication payload sent when connecting to the namespace, either a map[string]any or a
+	// func(func(map[string]any)) which is called upon each connection attempt
+	auth any
+}
+
+func DefaultSocketOptions() *SocketOptions {
+	s := &SocketOptions{}
+	return s
+}
+
+func (s *SocketOptions) SetAuth(auth any) {
+	s.auth = auth
+}
+func (s *SocketOptions) Auth() any {
+	return s.auth
+}
+
+type Options struct {
+	ManagerOptions
+	SocketOptions
+
+	// whether to create a new Manager instead of reusing an existing one
+	forceNew *bool
+
+	// whether to reuse an existing Manager for the same host
+	multiplex *bool
+}
+
+func DefaultOptions() *Options {
+	o := &Options{}
+	return o
+}
+
+func (o *Options) SetForceNew(forceNew bool) {
+	o.forceNew = &forceNew
+}
+func (o *Options) GetRawForceNew() *bool {
+	return o.forceNew
+}
+func (o *Options) ForceNew() bool {
+	if o.forceNew == nil {
+		return false
+	}
+
+	return *o.forceNew
+}
+
+func (o *Options) SetMultiplex(multiplex bool) {
+	o.multiplex = &multiplex
+}
+func (o *Options) GetRawMultiplex() *bool {
+	return o.multiplex
+}
+func (o *Options) Multiplex() bool {
+	if o.multiplex == nil {
+		return true
+	}
+
+	return *o.multiplex
+}
@@ -0,0 +1,606 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zishang520/engine.io/events"
+	"github.com/zishang520/engine.io/log"
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/engine.io/utils"
+	"github.com/zishang520/socket.io/parser"
+	"github.com/zishang520/socket.io/socket"
+)
+
+var (
+	// Internal events.
+	// These events can't be emitted by the user.
+	RESERVED_EVENTS = types.NewSet("connect", "connect_error", "disconnect", "disconnecting", "newListener", "removeListener")
+	socket_log      = log.NewLog("socket.io-client:socket")
+)
+
+type Flags struct {
+	Volatile bool
+	Timeout  *time.Duration
+}
+
+// A Socket is the fundamental class for interacting with the server.
+//
+// A Socket belongs to a certain Namespace (by default /) and uses an underlying Manager to communicate.
+//
+// Events:
+//   - `connect`       the socket is connected to the namespace
+//   - `connect_error` the connection was refused by the server (*socket.ExtendedError) or failed (error)
+//   - `disconnect`    the socket was disconnected (reason)
+type Socket struct {
+	*socket.StrictEventEmitter
+
+	io   *Manager
+	nsp  string
+	opts *SocketOptions
+
+	id        socket.SocketId
+	pid       socket.PrivateSessionId
+	offset    string
+	connected bool
+	recovered bool
+	active    bool
+
+	receiveBuffer         [][]any
+	sendBuffer            []*parser.Packet
+	ids                   uint64
+	acks                  *sync.Map
+	flags                 *Flags
+	_anyListeners         []events.Listener
+	_anyOutgoingListeners []events.Listener
+
+	mu                       sync.RWMutex
+	flags_mu                 sync.RWMutex
+	_anyListeners_mu         sync.RWMutex
+	_anyOutgoingListeners_mu sync.RWMutex
+}
+
+// Socket constructor.
+func NewSocket(io *Manager, nsp string, opts *SocketOptions) *Socket {
+	if opts == nil {
+		opts = DefaultSocketOptions()
+	}
+
+	s := &Socket{}
+	s.StrictEventEmitter = socket.NewStrictEventEmitter()
+	s.io = io
+	s.nsp = nsp
+	s.opts = opts
+	s.receiveBuffer = [][]any{}
+	s.sendBuffer = []*parser.Packet{}
+	s.acks = &sync.Map{}
+	s.flags = &Flags{}
+
+	return s
+}
+
+// The Manager this socket belongs to.
+func (s *Socket) Io() *Manager {
+	return s.io
+}
+
+// The namespace of this socket.
+func (s *Socket) Nsp() string {
+	return s.nsp
+}
+
+// A unique identifier for the session, empty when the socket is not connected.
+func (s *Socket) Id() socket.SocketId {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.id
+}
+
+// Whether the socket is currently connected to the server.
+func (s *Socket) Connected() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.connected
+}
+
+// Whether the socket is currently disconnected
+func (s *Socket) Disconnected() bool {
+	return !s.Connected()
+}
+
+// Whether the connection state was recovered after a temporary disconnection. In that case, any missed packets will
+// be transmitted by the server.
+func (s *Socket) Recovered() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.recovered
+}
+
+// Whether the Socket will try to reconnect when its Manager connects or reconnects.
+func (s *Socket) Active() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.active
+}
+
+// "Opens" the socket.
+func (s *Socket) Connect() *Socket {
+	if s.Connected() {
+		return s
+	}
+
+	s.mu.Lock()
+	s.active = true
+	s.mu.Unlock()
+
+	// the CONNECT packet is sent either here or by the manager once open, never by both
+	if s.io.subscribe(s) {
+		s.onopen()
+	} else if !s.io.Reconnecting() {
+		s.io.Open(nil) // ensure open
+	}
+	return s
+}
+
+// Alias for Connect().
+func (s *Socket) Open() *Socket {
+	return s.Connect()
+}
+
+// Sends a `message` event.
+func (s *Socket) Send(args ...any) *Socket {
+	s.Emit("message", args...)
+	return s
+}
+
+// Emits an event to the server. If the last argument is a func(...any), it is called when the server acknowledges
+// the event.
+func (s *Socket) Emit(ev string, args ...any) error {
+	if RESERVED_EVENTS.Has(ev) {
+		return errors.New(fmt.Sprintf(`"%s" is a reserved event name`, ev))
+	}
+
+	data := append([]any{ev}, args...)
+	data_len := len(data)
+	packet := &parser.Packet{
+		Type: parser.EVENT,
+		Data: data,
+	}
+
+	s.flags_mu.Lock()
+	flags := *s.flags
+	s.flags = &Flags{}
+	s.flags_mu.Unlock()
+
+	// event ack callback
+	if fn, ok := data[data_len-1].(func(...any)); ok {
+		id := atomic.AddUint64(&s.ids, 1) - 1
+		socket_log.Debug("emitting packet with ack id %d", id)
+		packet.Data = data[:data_len-1]
+		s.registerAckCallback(id, flags.Timeout, fn)
+		packet.Id = &id
+	}
+
+	s.mu.Lock()
+	connected := s.connected
+	discardPacket := flags.Volatile && !connected
+	if !discardPacket && !connected {
+		s.sendBuffer = append(s.sendBuffer, packet)
+	}
+	s.mu.Unlock()
+
+	if discardPacket {
+		socket_log.Debug("discard packet as the transport is not currently writable")
+	} else if connected {
+		s.notifyOutgoingListeners(packet)
+		if err := s.packet(packet); err != nil {
+			if packet.Id != nil {
+				// the packet has not been sent, so it will never be acknowledged
+				s.acks.Delete(*packet.Id)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Socket) registerAckCallback(id uint64, timeout *time.Duration, ack func(...any)) {
+	if timeout == nil {
+		s.acks.Store(id, ack)
+		return
+	}
+	timer := utils.SetTimeOut(func() {
+		if _, ok := s.acks.LoadAndDelete(id); ok {
+			socket_log.Debug("event with ack id %d has timed out after %d ms", id, *timeout/time.Millisecond)
+			ack(errors.New("operation has timed out"))
+		}
+	}, *timeout)
+	s.acks.Store(id, func(args ...any) {
+		utils.ClearTimeout(timer)
+		ack(append([]any{nil}, args...)...)
+	})
+}
+
+// Sends a packet.
+func (s *Socket) packet(packet *parser.Packet) error {
+	packet.Nsp = s.nsp
+	return s.io._packet(packet)
+}
+
+// Called upon engine `open`.
+func (s *Socket) onopen() {
+	socket_log.Debug("transport is open - connecting")
+	switch auth := s.opts.Auth().(type) {
+	case func(func(map[string]any)):
+		auth(s.sendConnectPacket)
+	case map[string]any:
+		s.sendConnectPacket(auth)
+	default:
+		s.sendConnectPacket(nil)
+	}
+}
+
+// Sends a CONNECT packet to initiate the Socket.IO session.
+func (s *Socket) sendConnectPacket(auth map[string]any) {
+	data := map[string]any{}
+	for k, v := range auth {
+		data[k] = v
+	}
+	s.mu.RLock()
+	if s.pid != "" {
+		data["pid"] = s.pid
+		data["offset"] = s.offset
+	}
+	s.mu.RUnlock()
+	s.packet(&parser.Packet{
+		Type: parser.CONNECT,
+		Data: data,
+	})
+}
+
+// Called upon manager `error`.
+func (s *Socket) onerror(err any) {
+	if !s.Connected() {
+		s.EmitReserved("connect_error", err)
+	}
+}
+
+// Called upon engine or manager `close`.
+func (s *Socket) onclose(reason string) {
+	socket_log.Debug("close (%s)", reason)
+
+	s.mu.Lock()
+	if !s.connected {
+		s.mu.Unlock()
+		return
+	}
+	s.connected = false
+	s.id = ""
+	s.mu.Unlock()
+
+	s.EmitReserved("disconnect", reason)
+}
+
+// Called with socket packet.
+func (s *Socket) onpacket(packet *parser.Packet) {
+	if packet.Nsp != s.nsp {
+		return
+	}
+
+	switch packet.Type {
+	case parser.CONNECT:
+		if data, ok := packet.Data.(map[string]any); ok {
+			if sid, ok := data["sid"].(string); ok {
+				pid, _ := data["pid"].(string)
+				s.onconnect(socket.SocketId(sid), socket.PrivateSessionId(pid))
+				return
+			}
+		}
+		s.EmitReserved("connect_error", errors.New("It seems you are trying to reach a Socket.IO server in v2.x with a v3.x client, but they are not compatible (more information here: https://socket.io/docs/v3/migrating-from-2-x-to-3-0/)"))
+	case parser.EVENT, parser.BINARY_EVENT:
+		s.onevent(packet)
+	case parser.ACK, parser.BINARY_ACK:
+		s.onack(packet)
+	case parser.DISCONNECT:
+		s.ondisconnect()
+	case parser.CONNECT_ERROR:
+		s.destroy()
+		switch data := packet.Data.(type) {
+		case map[string]any:
+			message, _ := data["message"].(string)
+			s.EmitReserved("connect_error", socket.NewExtendedError(message, data["data"]))
+		case string:
+			s.EmitReserved("connect_error", socket.NewExtendedError(data, nil))
+		default:
+			s.EmitReserved("connect_error", socket.NewExtendedError("", nil))
+		}
+	}
+}
+
+// Called upon a server event.
+func (s *Socket) onevent(packet *parser.Packet) {
+	args, _ := packet.Data.([]any)
+	socket_log.Debug("emitting event %v", args)
+	if nil != packet.Id {
+		socket_log.Debug("attaching ack callback to event")
+		args = append(args, s.ack(*packet.Id))
+	}
+
+	s.mu.Lock()
+	if !s.connected {
+		s.receiveBuffer = append(s.receiveBuffer, args)
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+
+	s.emitEvent(args)
+}
+
+func (s *Socket) emitEvent(args []any) {
+	s._anyListeners_mu.RLock()
+	listeners := append([]events.Listener{}, s._anyListeners...)
+	s._anyListeners_mu.RUnlock()
+	for _, listener := range listeners {
+		listener(args...)
+	}
+	if ev, ok := args[0].(string); ok {
+		s.EmitUntyped(ev, args[1:]...)
+	}
+	s.mu.Lock()
+	if s.pid != "" && len(args) > 0 {
+		if offset, ok := args[len(args)-1].(string); ok {
+			s.offset = offset
+		}
+	}
+	s.mu.Unlock()
+}
+
+// Produces an ack callback to emit with an event.
+func (s *Socket) ack(id uint64) func(...any) {
+	sent := int32(0)
+	return func(args ...any) {
+		// prevent double callbacks
+		if atomic.CompareAndSwapInt32(&sent, 0, 1) {
+			socket_log.Debug("sending ack %v", args)
+			s.packet(&parser.Packet{
+				Id:   &id,
+				Type: parser.ACK,
+				Data: args,
+			})
+		}
+	}
+}
+
+// Called upon a server acknowledgement.
+func (s *Socket) onack(packet *parser.Packet) {
+	if packet.Id == nil {
+		socket_log.Debug("bad ack nil")
+		return
+	}
+	if ack, ok := s.acks.LoadAndDelete(*packet.Id); ok {
+		socket_log.Debug("calling ack %d with %v", *packet.Id, packet.Data)
+		args, _ := packet.Data.([]any)
+		(ack.(func(...any)))(args...)
+	} else {
+		socket_log.Debug("bad ack %d", *packet.Id)
+	}
+}
+
+// Called upon server connect.
+func (s *Socket) onconnect(id socket.SocketId, pid socket.PrivateSessionId) {
+	socket_log.Debug("socket connected with id %s", id)
+
+	s.mu.Lock()
+	s.id = id
+	s.recovered = pid != "" && s.pid == pid
+	s.pid = pid // defined only if connection state recovery is enabled
+	s.connected = true
+	s.mu.Unlock()
+
+	s.emitBuffered()
+	s.EmitReserved("connect")
+}
+
+// Emit buffered events (received and emitted).
+func (s *Socket) emitBuffered() {
+	s.mu.Lock()
+	receiveBuffer, sendBuffer := s.receiveBuffer, s.sendBuffer
+	s.receiveBuffer, s.sendBuffer = [][]any{}, []*parser.Packet{}
+	s.mu.Unlock()
+
+	for _, args := range receiveBuffer {
+		s.emitEvent(args)
+	}
+	for _, packet := range sendBuffer {
+		s.notifyOutgoingListeners(packet)
+		s.packet(packet)
+	}
+}
+
+// Called upon server disconnect.
+func (s *Socket) ondisconnect() {
+	socket_log.Debug("server disconnect (%s)", s.nsp)
+	s.destroy()
+	s.onclose("io server disconnect")
+}
+
+// Called upon forced client/server side disconnections,
+// this method ensures the manager stops tracking us and
+// that reconnections don't get triggered for this.
+func (s *Socket) destroy() {
+	s.mu.Lock()
+	s.active = false
+	s.mu.Unlock()
+
+	s.io.unsubscribe(s)
+	s.io._destroy(s)
+}
+
+// Disconnects the socket manually. In that case, the socket will not try to reconnect.
+//
+// If this is the last active Socket instance of the Manager, the low-level connection will be closed.
+func (s *Socket) Disconnect() *Socket {
+	if s.Connected() {
+		socket_log.Debug("performing disconnect (%s)", s.nsp)
+		s.packet(&parser.Packet{
+			Type: parser.DISCONNECT,
+		})
+	}
+
+	// remove socket from pool
+	s.destroy()
+
+	// fire events
+	s.onclose("io client disconnect")
+	return s
+}
+
+// Alias for Disconnect().
+func (s *Socket) Close() *Socket {
+	return s.Disconnect()
+}
+
+// Sets a modifier for a subsequent event emission that the event message will be dropped when this socket is not
+// ready to send messages.
+func (s *Socket) Volatile() *Socket {
+	s.flags_mu.Lock()
+	s.flags.Volatile = true
+	s.flags_mu.Unlock()
+	return s
+}
+
+// Sets a modifier for a subsequent event emission that the callback will be called with an error when the
+// given number of milliseconds have elapsed without an acknowledgement from the server:
+//
+// ```
+//
+//	socket.Timeout(5000 * time.Millisecond).Emit("my-event", func(args ...any) {
+//	  if args[0] != nil {
+//	    // the server did not acknowledge the event in the given delay
+//	  }
+//	})
+//
+// ```
+func (s *Socket) Timeout(timeout time.Duration) *Socket {
+	s.flags_mu.Lock()
+	s.flags.Timeout = &timeout
+	s.flags_mu.Unlock()
+	return s
+}
+
+// Adds a listener that will be fired when any event is received. The event name is passed as the first argument to
+// the callback.
+func (s *Socket) OnAny(listener events.Listener) *Socket {
+	s._anyListeners_mu.Lock()
+	defer s._anyListeners_mu.Unlock()
+
+	s._anyListeners = append(s._anyListeners, listener)
+	return s
+}
+
+// Adds a listener that will be fired when any event is received. The event name is passed as the first argument to
+// the callback. The listener is added to the beginning of the listeners array.
+func (s *Socket) PrependAny(listener events.Listener) *Socket {
+	s._anyListeners_mu.Lock()
+	defer s._anyListeners_mu.Unlock()
+
+	s._anyListeners = append([]events.Listener{listener}, s._anyListeners...)
+	return s
+}
+
+// Removes the listener that will be fired when any event is received.
+func (s *Socket) OffAny(listener events.Listener) *Socket {
+	s._anyListeners_mu.Lock()
+	defer s._anyListeners_mu.Unlock()
+
+	if listener != nil {
+		listenerPointer := reflect.ValueOf(listener).Pointer()
+		for i, _listener := range s._anyListeners {
+			if listenerPointer == reflect.ValueOf(_listener).Pointer() {
+				s._anyListeners = append(s._anyListeners[:i], s._anyListeners[i+1:]...)
+				return s
+			}
+		}
+	} else {
+		s._anyListeners = []events.Listener{}
+	}
+	return s
+}
+
+// Returns an array of listeners that are listening for any event that is specified.
+func (s *Socket) ListenersAny() []events.Listener {
+	s._anyListeners_mu.RLock()
+	defer s._anyListeners_mu.RUnlock()
+
+	return append([]events.Listener{}, s._anyListeners...)
+}
+
+// Adds a listener that will be fired when any event is emitted. The event name is passed as the first argument to the
+// callback.
+func (s *Socket) OnAnyOutgoing(listener events.Listener) *Socket {
+	s._anyOutgoingListeners_mu.Lock()
+	defer s._anyOutgoingListeners_mu.Unlock()
+
+	s._anyOutgoingListeners = append(s._anyOutgoingListeners, listener)
+	return s
+}
+
+// Adds a listener that will be fired when any event is emitted. The event name is passed as the first argument to the
+// callback. The listener is added to the beginning of the listeners array.
+func (s *Socket) PrependAnyOutgoing(listener events.Listener) *Socket {
+	s._anyOutgoingListeners_mu.Lock()
+	defer s._anyOutgoingListeners_mu.Unlock()
+
+	s._anyOutgoingListeners = append([]events.Listener{listener}, s._anyOutgoingListeners...)
+	return s
+}
+
+// Removes the listener that will be fired when any event is emitted.
+func (s *Socket) OffAnyOutgoing(listener events.Listener) *Socket {
+	s._anyOutgoingListeners_mu.Lock()
+	defer s._anyOutgoingListeners_mu.Unlock()
+
+	if listener != nil {
+		listenerPointer := reflect.ValueOf(listener).Pointer()
+		for i, _listener := range s._anyOutgoingListeners {
+			if listenerPointer == reflect.ValueOf(_listener).Pointer() {
+				s._anyOutgoingListeners = append(s._anyOutgoingListeners[:i], s._anyOutgoingListeners[i+1:]...)
+				return s
+			}
+		}
+	} else {
+		s._anyOutgoingListeners = []events.Listener{}
+	}
+	return s
+}
+
+// Returns an array of listeners that are listening for any event that is specified.
+func (s *Socket) ListenersAnyOutgoing() []events.Listener {
+	s._anyOutgoingListeners_mu.RLock()
+	defer s._anyOutgoingListeners_mu.RUnlock()
+
+	return append([]events.Listener{}, s._anyOutgoingListeners...)
+}
+
+// Notify the listeners for each packet sent
+func (s *Socket) notifyOutgoingListeners(packet *parser.Packet) {
+	s._anyOutgoingListeners_mu.RLock()
+	listeners := append([]events.Listener{}, s._anyOutgoingListeners...)
+	s._anyOutgoingListeners_mu.RUnlock()
+	for _, listener := range listeners {
+		if args, ok := packet.Data.([]any); ok {
+			listener(args...)
+		} else {
+			listener(packet.Data)
+		}
+	}
+}
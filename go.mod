@@ -1,18 +1,20 @@
 module github.com/zishang520/socket.io
 
-go 1.18
+go 1.20
 
 retract v1.0.8
 
 require (
 	github.com/andybalholm/brotli v1.0.4
+	github.com/gorilla/websocket v1.5.0
 	github.com/mitchellh/mapstructure v1.5.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
 	github.com/zishang520/engine.io v1.2.0
 )
 
 require (
 	github.com/gookit/color v1.5.0 // indirect
-	github.com/gorilla/websocket v1.5.0 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
 	github.com/xo/terminfo v0.0.0-20210125001918-ca9a967f8778 // indirect
 	golang.org/x/sys v0.0.0-20210330210617-4fbd30eecc44 // indirect
 )
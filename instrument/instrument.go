@@ -0,0 +1,479 @@
+// Package instrument reports the state of a Socket.IO server to the Socket.IO Admin UI
+// (https://admin.socket.io), and handles its commands.
+//
+//	opts := instrument.DefaultInstrumentOptions()
+//	opts.SetAuth(&instrument.BasicAuth{Username: "admin", Password: os.Getenv("ADMIN_PASSWORD")})
+//	instrument.Instrument(io, opts)
+package instrument
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zishang520/engine.io/engine"
+	"github.com/zishang520/engine.io/log"
+	"github.com/zishang520/engine.io/transports"
+	"github.com/zishang520/engine.io/utils"
+	"github.com/zishang520/socket.io/internal/parameters"
+	"github.com/zishang520/socket.io/socket"
+)
+
+var instrument_log = log.NewLog("socket.io:admin")
+
+// The time the process was started at, for the uptime of the server stats.
+var startTime = time.Now()
+
+// The features of the Admin UI supported by the server.
+const (
+	FEATURE_EMIT              = "EMIT"
+	FEATURE_JOIN              = "JOIN"
+	FEATURE_LEAVE             = "LEAVE"
+	FEATURE_DISCONNECT        = "DISCONNECT"
+	FEATURE_MJOIN             = "MJOIN"
+	FEATURE_MLEAVE            = "MLEAVE"
+	FEATURE_MDISCONNECT       = "MDISCONNECT"
+	FEATURE_AGGREGATED_EVENTS = "AGGREGATED_EVENTS"
+	FEATURE_ALL_EVENTS        = "ALL_EVENTS"
+)
+
+// The instrumentation of a server, returned by Instrument().
+type Instrumentation struct {
+	server   *socket.Server
+	admin    socket.NamespaceInterface
+	opts     *InstrumentOptions
+	serverId string
+
+	// the ids of the sessions of the Admin UI
+	sessions *sync.Map
+	// the session created for an admin socket, sent once it is connected
+	pendingSessions *sync.Map
+
+	events *eventBuffer
+	// the timer of the server stats
+	statsTimer *utils.Timer
+	closed     int32
+}
+
+// Registers the admin namespace on the server, which reports the server stats to the Admin UI and, in development
+// mode, the sockets, the rooms and the events of the other namespaces (including the ones created afterwards).
+//
+// The server stats are emitted until the returned instrumentation is closed.
+func Instrument(server *socket.Server, opts *InstrumentOptions) *Instrumentation {
+	if opts == nil {
+		opts = DefaultInstrumentOptions()
+	}
+
+	i := &Instrumentation{
+		server:          server,
+		opts:            opts,
+		serverId:        opts.ServerId(),
+		sessions:        &sync.Map{},
+		pendingSessions: &sync.Map{},
+		events:          newEventBuffer(),
+	}
+	i.admin = server.Of(opts.NamespaceName(), nil)
+
+	i.initAuthentication()
+	i.admin.On("connection", i.onconnection)
+
+	if eio := server.Engine(); eio != nil {
+		i.instrumentEngine(eio)
+	}
+	server.Sockets().On("new_namespace", func(args ...any) {
+		if nsp, ok := args[0].(socket.NamespaceInterface); ok && nsp.Name() != i.admin.Name() {
+			i.instrumentNamespace(nsp)
+		}
+	})
+	server.Nsps().Range(func(_, nsp any) bool {
+		if nsp := nsp.(socket.NamespaceInterface); nsp.Name() != i.admin.Name() {
+			i.instrumentNamespace(nsp)
+		}
+		return true
+	})
+
+	i.statsTimer = utils.SetInterval(i.emitStats, opts.ServerStatsInterval())
+
+	return i
+}
+
+// Stops emitting the server stats, for example when the server is closed.
+func (i *Instrumentation) Close() {
+	atomic.StoreInt32(&i.closed, 1)
+	utils.ClearInterval(i.statsTimer)
+}
+
+func (i *Instrumentation) initAuthentication() {
+	auth := i.opts.Auth()
+	if auth == nil {
+		instrument_log.Warning("authentication is disabled, please use with caution")
+		return
+	}
+
+	i.admin.Use(func(client *socket.Socket, next func(*socket.ExtendedError)) {
+		credentials, _ := client.Handshake().Auth.(map[string]any)
+		sessionId, _ := credentials["sessionId"].(string)
+		username, _ := credentials["username"].(string)
+		password, _ := credentials["password"].(string)
+
+		if _, ok := i.sessions.Load(sessionId); ok && sessionId != "" {
+			instrument_log.Debug("authentication success with valid session ID")
+			next(nil)
+			return
+		}
+		if username == "" || password == "" {
+			instrument_log.Debug("missing credentials")
+			next(socket.NewExtendedError("missing credentials", nil))
+			return
+		}
+		if !auth.verify(username, password) {
+			instrument_log.Debug("invalid credentials")
+			next(socket.NewExtendedError("invalid credentials", nil))
+			return
+		}
+
+		instrument_log.Debug("authentication success with valid credentials")
+		sessionId, err := utils.Base64Id().GenerateId()
+		if err != nil {
+			next(socket.NewExtendedError("internal server error", nil))
+			return
+		}
+		i.sessions.Store(sessionId, struct{}{})
+		i.pendingSessions.Store(client.Id(), sessionId)
+		next(nil)
+	})
+}
+
+// Whether the credentials submitted by the Admin UI are valid.
+func (a *BasicAuth) verify(username string, password string) bool {
+	validUsername := subtle.ConstantTimeCompare([]byte(username), []byte(a.Username)) == 1
+	if a.ComparePassword != nil {
+		return a.ComparePassword(a.Password, password) && validUsername
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(a.Password)) == 1 && validUsername
+}
+
+// Called with each socket of the Admin UI.
+func (i *Instrumentation) onconnection(args ...any) {
+	client := args[0].(*socket.Socket)
+
+	if sessionId, ok := i.pendingSessions.LoadAndDelete(client.Id()); ok {
+		client.Emit("session", sessionId)
+	}
+	client.Emit("config", map[string]any{
+		"supportedFeatures": i.supportedFeatures(),
+	})
+
+	if i.opts.Mode() == MODE_DEVELOPMENT {
+		client.Emit("all_sockets", i.fetchAllSockets())
+	}
+
+	if !i.opts.Readonly() {
+		i.registerCommands(client)
+	}
+}
+
+func (i *Instrumentation) supportedFeatures() []string {
+	features := []string{}
+	if !i.opts.Readonly() {
+		features = append(features,
+			FEATURE_EMIT, FEATURE_JOIN, FEATURE_LEAVE, FEATURE_DISCONNECT,
+			FEATURE_MJOIN, FEATURE_MLEAVE, FEATURE_MDISCONNECT,
+		)
+	}
+	features = append(features, FEATURE_AGGREGATED_EVENTS)
+	if i.opts.Mode() == MODE_DEVELOPMENT {
+		features = append(features, FEATURE_ALL_EVENTS)
+	}
+	return features
+}
+
+// Handles the commands of the Admin UI, which target all the sockets of a namespace or the ones in a room (or with a
+// given id).
+func (i *Instrumentation) registerCommands(client *socket.Socket) {
+	target := func(nsp string, filter string) *socket.BroadcastOperator {
+		namespace := i.server.Of(nsp, nil)
+		if filter == "" {
+			return namespace.Except()
+		}
+		return namespace.In(socket.Room(filter))
+	}
+
+	client.On("_join", func(args ...any) {
+		nsp, room, filter := stringArg(args, 0), stringArg(args, 1), stringArg(args, 2)
+		instrument_log.Debug("%s/%s join room %s", nsp, filter, room)
+		target(nsp, filter).SocketsJoin(socket.Room(room))
+	})
+	client.On("_leave", func(args ...any) {
+		nsp, room, filter := stringArg(args, 0), stringArg(args, 1), stringArg(args, 2)
+		instrument_log.Debug("%s/%s leave room %s", nsp, filter, room)
+		target(nsp, filter).SocketsLeave(socket.Room(room))
+	})
+	client.On("_disconnect", func(args ...any) {
+		nsp, filter := stringArg(args, 0), stringArg(args, 2)
+		close, _ := arg(args, 1).(bool)
+		instrument_log.Debug("%s/%s disconnect (close: %t)", nsp, filter, close)
+		target(nsp, filter).DisconnectSockets(close)
+	})
+	client.On("_emit", func(args ...any) {
+		args = withoutAck(args)
+		nsp, filter, ev := stringArg(args, 0), stringArg(args, 1), stringArg(args, 2)
+		if ev == "" {
+			return
+		}
+		instrument_log.Debug("%s/%s emit %s", nsp, filter, ev)
+		var data []any
+		if len(args) > 3 {
+			data = args[3:]
+		}
+		target(nsp, filter).Emit(ev, data...)
+	})
+}
+
+func (i *Instrumentation) instrumentEngine(eio engine.Server) {
+	eio.On("connection", func(args ...any) {
+		i.events.push("rawConnection", "")
+		if conn, ok := args[0].(engine.Socket); ok {
+			conn.Once("close", func(...any) {
+				i.events.push("rawDisconnection", "")
+			})
+		}
+	})
+}
+
+func (i *Instrumentation) instrumentNamespace(nsp socket.NamespaceInterface) {
+	name := nsp.Name()
+	development := i.opts.Mode() == MODE_DEVELOPMENT
+	instrument_log.Debug("instrumenting namespace %s", name)
+
+	nsp.On("connection", func(args ...any) {
+		client, ok := args[0].(*socket.Socket)
+		if !ok {
+			return
+		}
+		i.events.push("connection", name)
+		client.On("disconnect", func(args ...any) {
+			i.events.push("disconnection", name)
+			if development {
+				i.admin.Emit("socket_disconnected", name, client.Id(), arg(args, 0), timestamp())
+			}
+		})
+
+		if !development {
+			return
+		}
+		i.admin.Emit("socket_connected", serialize(client, name), timestamp())
+		client.Conn().On("upgrade", func(args ...any) {
+			if transport, ok := args[0].(transports.Transport); ok {
+				i.admin.Emit("socket_updated", map[string]any{
+					"id":        client.Id(),
+					"nsp":       name,
+					"transport": transport.Name(),
+				})
+			}
+		})
+		client.OnAny(func(args ...any) {
+			i.admin.Emit("event_received", name, withoutAck(args), client.Id(), timestamp())
+		})
+		client.OnAnyOutgoing(func(args ...any) {
+			i.admin.Emit("event_sent", name, withoutAck(args), client.Id(), timestamp())
+		})
+	})
+
+	if development {
+		nsp.Adapter().On("join-room", func(args ...any) {
+			i.admin.Emit("room_joined", name, arg(args, 0), arg(args, 1), timestamp())
+		})
+		nsp.Adapter().On("leave-room", func(args ...any) {
+			i.admin.Emit("room_left", name, arg(args, 0), arg(args, 1), timestamp())
+		})
+	}
+}
+
+func (i *Instrumentation) emitStats() {
+	if atomic.LoadInt32(&i.closed) == 1 {
+		// the interval is not cleared when Close() is called while it fires, it is rearmed by now
+		utils.ClearInterval(i.statsTimer)
+		return
+	}
+
+	namespaces := []map[string]any{}
+	i.server.Nsps().Range(func(_, nsp any) bool {
+		count := 0
+		nsp.(socket.NamespaceInterface).Sockets().Range(func(any, any) bool {
+			count++
+			return true
+		})
+		namespaces = append(namespaces, map[string]any{
+			"name":         nsp.(socket.NamespaceInterface).Name(),
+			"socketsCount": count,
+		})
+		return true
+	})
+
+	clientsCount, pollingClientsCount := uint64(0), uint64(0)
+	if eio := i.server.Engine(); eio != nil {
+		clientsCount = eio.ClientsCount()
+		eio.Clients().Range(func(_, conn any) bool {
+			if conn, ok := conn.(engine.Socket); ok && conn.Transport() != nil && conn.Transport().Name() == "polling" {
+				pollingClientsCount++
+			}
+			return true
+		})
+	}
+
+	hostname, _ := os.Hostname()
+	i.admin.Emit("server_stats", map[string]any{
+		"serverId":            i.serverId,
+		"hostname":            hostname,
+		"pid":                 os.Getpid(),
+		"uptime":              time.Since(startTime).Seconds(),
+		"clientsCount":        clientsCount,
+		"pollingClientsCount": pollingClientsCount,
+		"aggregatedEvents":    i.events.valuesAndClear(),
+		"namespaces":          namespaces,
+	})
+}
+
+// Returns the sockets of all the namespaces, including the ones hosted on the other servers of the cluster.
+func (i *Instrumentation) fetchAllSockets() []map[string]any {
+	sockets := []map[string]any{}
+	i.server.Nsps().Range(func(_, nsp any) bool {
+		namespace := nsp.(socket.NamespaceInterface)
+		if namespace.Name() == i.admin.Name() {
+			return true
+		}
+		remoteSockets, err := namespace.FetchSockets()
+		if err != nil {
+			instrument_log.Debug("failed to fetch the sockets of namespace %s: %v", namespace.Name(), err)
+			return true
+		}
+		for _, remoteSocket := range remoteSockets {
+			if client, ok := namespace.Sockets().Load(remoteSocket.Id()); ok {
+				sockets = append(sockets, serialize(client.(*socket.Socket), namespace.Name()))
+			} else {
+				sockets = append(sockets, serializeDetails(remoteSocket, namespace.Name()))
+			}
+		}
+		return true
+	})
+	return sockets
+}
+
+// The serialized form of a socket connected to this server.
+func serialize(client *socket.Socket, nsp string) map[string]any {
+	s := serializeDetails(client, nsp)
+	s["clientId"] = client.Conn().Id()
+	if transport := client.Conn().Transport(); transport != nil {
+		s["transport"] = transport.Name()
+	}
+	return s
+}
+
+// The serialized form of a socket, possibly hosted on another server.
+func serializeDetails(client socket.SocketDetails, nsp string) map[string]any {
+	s := map[string]any{
+		"id":    client.Id(),
+		"nsp":   nsp,
+		"data":  client.Data(),
+		"rooms": client.Rooms().Keys(),
+	}
+	if handshake := client.Handshake(); handshake != nil {
+		s["handshake"] = map[string]any{
+			"address": handshake.Address,
+			"headers": parameters.Values(handshake.Headers),
+			"query":   parameters.Values(handshake.Query),
+			"issued":  handshake.Issued,
+			"secure":  handshake.Secure,
+			"time":    handshake.Time,
+			"url":     handshake.Url,
+			"xdomain": handshake.Xdomain,
+		}
+	}
+	return s
+}
+
+// The dates are sent as strings, like the Date objects serialized by the Node.js server.
+func timestamp() string {
+	return time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func arg(args []any, index int) any {
+	if index < len(args) {
+		return args[index]
+	}
+	return nil
+}
+
+func stringArg(args []any, index int) string {
+	if value := arg(args, index); value != nil {
+		if s, ok := value.(string); ok {
+			return s
+		}
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// Removes the acknowledgement callback, which cannot be serialized.
+func withoutAck(args []any) []any {
+	if l := len(args); l > 0 && args[l-1] != nil && reflect.TypeOf(args[l-1]).Kind() == reflect.Func {
+		return args[:l-1]
+	}
+	return args
+}
+
+// The events counted between two server stats, by second.
+type eventBuffer struct {
+	values map[eventKey]*aggregatedEvent
+	keys   []eventKey
+
+	mu sync.Mutex
+}
+
+type eventKey struct {
+	timestamp int64
+	kind      string
+	subType   string
+}
+
+type aggregatedEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Type      string `json:"type"`
+	SubType   string `json:"subType,omitempty"`
+	Count     int64  `json:"count"`
+}
+
+func newEventBuffer() *eventBuffer {
+	return &eventBuffer{values: map[eventKey]*aggregatedEvent{}}
+}
+
+func (b *eventBuffer) push(kind string, subType string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := eventKey{timestamp: time.Now().Truncate(time.Second).UnixMilli(), kind: kind, subType: subType}
+	if value, ok := b.values[key]; ok {
+		value.Count++
+		return
+	}
+	b.values[key] = &aggregatedEvent{Timestamp: key.timestamp, Type: kind, SubType: subType, Count: 1}
+	b.keys = append(b.keys, key)
+}
+
+func (b *eventBuffer) valuesAndClear() []*aggregatedEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	values := make([]*aggregatedEvent, 0, len(b.keys))
+	for _, key := range b.keys {
+		values = append(values, b.values[key])
+	}
+	b.values = map[eventKey]*aggregatedEvent{}
+	b.keys = nil
+	return values
+}
@@ -0,0 +1,212 @@
+package instrument
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/zishang520/socket.io/client"
+	"github.com/zishang520/socket.io/socket"
+)
+
+// Starts a server listening on a random port, closed at the end of the test, and returns its address.
+func newTestServer(t *testing.T) (*socket.Server, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io := socket.NewServer(listener, nil)
+	t.Cleanup(func() {
+		io.Close(nil)
+	})
+	return io, "http://" + listener.Addr().String()
+}
+
+// Creates a socket with the given auth payload, which records the events it receives, disconnected at the end of the
+// test.
+func newSocket(t *testing.T, url string, auth map[string]any) (*client.Socket, chan []any) {
+	t.Helper()
+
+	opts := client.DefaultOptions()
+	opts.SetForceNew(true)
+	opts.SetReconnection(false)
+	if auth != nil {
+		opts.SetAuth(auth)
+	}
+	c, err := client.Io(url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Disconnect()
+	})
+	events := make(chan []any, 100)
+	c.OnAny(func(args ...any) {
+		events <- args
+	})
+	c.On("connect_error", func(args ...any) {
+		events <- append([]any{"connect_error"}, args...)
+	})
+	return c, events
+}
+
+// Waits for the next event of the given name, skipping the other ones.
+func waitEvent(t *testing.T, events chan []any, ev string) []any {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case args := <-events:
+			if args[0] == ev {
+				return args[1:]
+			}
+		case <-timeout:
+			t.Fatalf("the %s event was not received", ev)
+		}
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	io, url := newTestServer(t)
+	opts := DefaultInstrumentOptions()
+	opts.SetAuth(&BasicAuth{
+		Username: "admin",
+		Password: "hashed:secret",
+		ComparePassword: func(password string, submitted string) bool {
+			return password == "hashed:"+submitted
+		},
+	})
+	defer Instrument(io, opts).Close()
+
+	for auth, expected := range map[*map[string]any]string{
+		{"username": "admin"}:                      "missing credentials",
+		{"username": "admin", "password": "wrong"}: "invalid credentials",
+		{"username": "root", "password": "secret"}: "invalid credentials",
+		{"sessionId": "unknown"}:                   "missing credentials",
+	} {
+		_, events := newSocket(t, url+"/admin", *auth)
+		if err, ok := waitEvent(t, events, "connect_error")[0].(error); !ok || err.Error() != expected {
+			t.Errorf("%v: expected %q, got %v", *auth, expected, err)
+		}
+	}
+
+	_, events := newSocket(t, url+"/admin", map[string]any{"username": "admin", "password": "secret"})
+	sessionId, ok := waitEvent(t, events, "session")[0].(string)
+	if !ok || sessionId == "" {
+		t.Fatalf("expected a session id, got %v", sessionId)
+	}
+
+	// the session id is enough to reconnect
+	_, events = newSocket(t, url+"/admin", map[string]any{"sessionId": sessionId})
+	waitEvent(t, events, "config")
+}
+
+func TestDevelopmentMode(t *testing.T) {
+	io, url := newTestServer(t)
+	opts := DefaultInstrumentOptions()
+	opts.SetServerStatsInterval(time.Hour)
+	defer Instrument(io, opts).Close()
+
+	admin, events := newSocket(t, url+"/admin", nil)
+	config := waitEvent(t, events, "config")[0].(map[string]any)
+	features := []any{
+		FEATURE_EMIT, FEATURE_JOIN, FEATURE_LEAVE, FEATURE_DISCONNECT, FEATURE_MJOIN, FEATURE_MLEAVE,
+		FEATURE_MDISCONNECT, FEATURE_AGGREGATED_EVENTS, FEATURE_ALL_EVENTS,
+	}
+	if !reflect.DeepEqual(config["supportedFeatures"], features) {
+		t.Errorf("unexpected features %v", config["supportedFeatures"])
+	}
+	if sockets := waitEvent(t, events, "all_sockets")[0]; !reflect.DeepEqual(sockets, []any{}) {
+		t.Errorf("expected no socket, got %v", sockets)
+	}
+
+	io.On("connection", func(args ...any) {
+		s := args[0].(*socket.Socket)
+		s.On("hello", func(...any) {})
+	})
+	c, received := newSocket(t, url, map[string]any{"token": "abc"})
+	details := waitEvent(t, events, "socket_connected")[0].(map[string]any)
+	id := details["id"].(string)
+	if details["nsp"] != "/" || details["handshake"].(map[string]any)["auth"] != nil {
+		t.Errorf("unexpected socket %v", details)
+	}
+
+	c.Emit("hello", "world")
+	if args := waitEvent(t, events, "event_received"); args[0] != "/" || !reflect.DeepEqual(args[1], []any{"hello", "world"}) || args[2] != id {
+		t.Errorf("unexpected received event %v", args)
+	}
+
+	admin.Emit("_join", "/", "room1", id)
+	if args := waitEvent(t, events, "room_joined"); args[0] != "/" || args[1] != "room1" || args[2] != id {
+		t.Errorf("unexpected joined room %v", args)
+	}
+	admin.Emit("_emit", "/", "room1", "news", 1)
+	if args := waitEvent(t, received, "news"); !reflect.DeepEqual(args, []any{float64(1)}) {
+		t.Errorf("unexpected event %v", args)
+	}
+	if args := waitEvent(t, events, "event_sent"); !reflect.DeepEqual(args[1], []any{"news", float64(1)}) {
+		t.Errorf("unexpected sent event %v", args)
+	}
+
+	admin.Emit("_disconnect", "/", false, "")
+	if args := waitEvent(t, events, "socket_disconnected"); args[0] != "/" || args[1] != id || args[2] != "server namespace disconnect" {
+		t.Errorf("unexpected disconnection %v", args)
+	}
+}
+
+func TestProductionMode(t *testing.T) {
+	io, url := newTestServer(t)
+	opts := DefaultInstrumentOptions()
+	opts.SetMode(MODE_PRODUCTION)
+	opts.SetReadonly(true)
+	opts.SetServerId("server-1")
+	opts.SetServerStatsInterval(100 * time.Millisecond)
+	instrumentation := Instrument(io, opts)
+
+	admin, events := newSocket(t, url+"/admin", nil)
+	config := waitEvent(t, events, "config")[0].(map[string]any)
+	if !reflect.DeepEqual(config["supportedFeatures"], []any{FEATURE_AGGREGATED_EVENTS}) {
+		t.Errorf("unexpected features %v", config["supportedFeatures"])
+	}
+
+	c, _ := newSocket(t, url, nil)
+	connections := 0
+	var stats map[string]any
+	for deadline := time.Now().Add(2 * time.Second); connections == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("the connection was not reported")
+		}
+		stats = waitEvent(t, events, "server_stats")[0].(map[string]any)
+		for _, event := range stats["aggregatedEvents"].([]any) {
+			if event := event.(map[string]any); event["type"] == "connection" && event["subType"] == "/" {
+				connections += int(event["count"].(float64))
+			}
+		}
+	}
+	if stats["serverId"] != "server-1" || stats["clientsCount"] != float64(2) {
+		t.Errorf("unexpected stats %v", stats)
+	}
+	// the commands are ignored
+	admin.Emit("_disconnect", "/", false, "")
+	time.Sleep(100 * time.Millisecond)
+	if !c.Connected() {
+		t.Error("the command of a read-only instrumentation was handled")
+	}
+
+	instrumentation.Close()
+	// the stats which were being emitted
+	time.Sleep(50 * time.Millisecond)
+	for len(events) > 0 {
+		<-events
+	}
+	time.Sleep(300 * time.Millisecond)
+	for len(events) > 0 {
+		if args := <-events; args[0] == "server_stats" {
+			t.Fatalf("the stats were emitted after Close(): %v", args)
+		}
+	}
+}
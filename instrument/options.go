@@ -0,0 +1,135 @@
+package instrument
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// What is reported to the Admin UI.
+type Mode string
+
+const (
+	// Reports the server stats, the sockets, the rooms and the events.
+	MODE_DEVELOPMENT Mode = "development"
+	// Only reports the server stats, which is less expensive with many sockets.
+	MODE_PRODUCTION Mode = "production"
+)
+
+// The credentials of the Admin UI.
+type BasicAuth struct {
+	Username string
+
+	// The password, or its hash when ComparePassword is set
+	Password string
+
+	// Compares the password submitted by the Admin UI with Password, e.g. with bcrypt.CompareHashAndPassword() for a
+	// hashed password. The passwords are compared in constant time when it is nil.
+	ComparePassword func(password string, submitted string) bool
+}
+
+type InstrumentOptions struct {
+	// the name of the admin namespace
+	namespaceName *string
+
+	// the credentials of the Admin UI, the authentication is disabled when it is nil
+	auth *BasicAuth
+
+	// whether the admin commands (join, leave, disconnect and emit) are disabled
+	readonly *bool
+
+	// the id of the server in the Admin UI
+	serverId *string
+
+	// what is reported to the Admin UI
+	mode *Mode
+
+	// how often the server stats are reported
+	serverStatsInterval *time.Duration
+}
+
+func DefaultInstrumentOptions() *InstrumentOptions {
+	i := &InstrumentOptions{}
+	return i
+}
+
+func (i *InstrumentOptions) SetNamespaceName(namespaceName string) {
+	i.namespaceName = &namespaceName
+}
+func (i *InstrumentOptions) GetRawNamespaceName() *string {
+	return i.namespaceName
+}
+func (i *InstrumentOptions) NamespaceName() string {
+	if i.namespaceName == nil {
+		return "/admin"
+	}
+
+	return *i.namespaceName
+}
+
+func (i *InstrumentOptions) SetAuth(auth *BasicAuth) {
+	i.auth = auth
+}
+func (i *InstrumentOptions) GetRawAuth() *BasicAuth {
+	return i.auth
+}
+func (i *InstrumentOptions) Auth() *BasicAuth {
+	return i.auth
+}
+
+func (i *InstrumentOptions) SetReadonly(readonly bool) {
+	i.readonly = &readonly
+}
+func (i *InstrumentOptions) GetRawReadonly() *bool {
+	return i.readonly
+}
+func (i *InstrumentOptions) Readonly() bool {
+	if i.readonly == nil {
+		return false
+	}
+
+	return *i.readonly
+}
+
+func (i *InstrumentOptions) SetServerId(serverId string) {
+	i.serverId = &serverId
+}
+func (i *InstrumentOptions) GetRawServerId() *string {
+	return i.serverId
+}
+func (i *InstrumentOptions) ServerId() string {
+	if i.serverId == nil {
+		hostname, _ := os.Hostname()
+		return fmt.Sprintf("%s#%d", hostname, os.Getpid())
+	}
+
+	return *i.serverId
+}
+
+func (i *InstrumentOptions) SetMode(mode Mode) {
+	i.mode = &mode
+}
+func (i *InstrumentOptions) GetRawMode() *Mode {
+	return i.mode
+}
+func (i *InstrumentOptions) Mode() Mode {
+	if i.mode == nil {
+		return MODE_DEVELOPMENT
+	}
+
+	return *i.mode
+}
+
+func (i *InstrumentOptions) SetServerStatsInterval(serverStatsInterval time.Duration) {
+	i.serverStatsInterval = &serverStatsInterval
+}
+func (i *InstrumentOptions) GetRawServerStatsInterval() *time.Duration {
+	return i.serverStatsInterval
+}
+func (i *InstrumentOptions) ServerStatsInterval() time.Duration {
+	if i.serverStatsInterval == nil {
+		return time.Duration(2000 * time.Millisecond)
+	}
+
+	return *i.serverStatsInterval
+}
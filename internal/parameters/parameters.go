@@ -0,0 +1,22 @@
+// Package parameters converts the parameters of a handshake into plain values, for the packages serializing the
+// sockets.
+package parameters
+
+import (
+	"github.com/zishang520/engine.io/utils"
+)
+
+// Returns the values of the bag, a single value being unwrapped from its slice.
+func Values(bag *utils.ParameterBag) map[string]any {
+	values := map[string]any{}
+	if bag != nil {
+		for k, v := range bag.All() {
+			if len(v) == 1 {
+				values[k] = v[0]
+			} else {
+				values[k] = v
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,334 @@
+// Package metrics collects the metrics of a Socket.IO server, and serves them in the Prometheus text exposition
+// format.
+//
+//	m := metrics.New()
+//	m.Instrument(io)
+//	http.Handle("/metrics", m)
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zishang520/engine.io/events"
+	"github.com/zishang520/engine.io/log"
+	"github.com/zishang520/socket.io/parser"
+	"github.com/zishang520/socket.io/socket"
+)
+
+var metrics_log = log.NewLog("socket.io:metrics")
+
+// The upper bounds of the buckets of the ack latency histogram, in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// The event label of the received events which have no listener, since their names are chosen by the clients.
+const UnknownEvent = "_unknown"
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// The metrics of one or several Socket.IO servers.
+type Metrics struct {
+	buckets []float64
+
+	connectedSockets     map[string]int64
+	connects             map[string]uint64
+	disconnects          map[[2]string]uint64
+	eventsReceived       map[[2]string]uint64
+	eventsSent           map[[2]string]uint64
+	encodedPackets       uint64
+	encodedBytes         uint64
+	ackLatency           map[string]*histogram
+	ackTimeouts          map[string]uint64
+	middlewareRejections map[string]uint64
+	rooms                map[string]int64
+
+	mu sync.Mutex
+}
+
+// Creates the metrics, with the default buckets for the ack latency.
+func New() *Metrics {
+	return NewWithBuckets(DefaultBuckets)
+}
+
+// Creates the metrics, with the given upper bounds (in seconds) for the buckets of the ack latency histogram.
+func NewWithBuckets(buckets []float64) *Metrics {
+	m := &Metrics{}
+	m.buckets = append([]float64{}, buckets...)
+	sort.Float64s(m.buckets)
+	m.connectedSockets = map[string]int64{}
+	m.connects = map[string]uint64{}
+	m.disconnects = map[[2]string]uint64{}
+	m.eventsReceived = map[[2]string]uint64{}
+	m.eventsSent = map[[2]string]uint64{}
+	m.ackLatency = map[string]*histogram{}
+	m.ackTimeouts = map[string]uint64{}
+	m.middlewareRejections = map[string]uint64{}
+	m.rooms = map[string]int64{}
+	return m
+}
+
+// Collects the metrics of the server, including the namespaces created afterwards.
+func (m *Metrics) Instrument(server *socket.Server) {
+	server.Observe(&socket.Observer{
+		Encoded: func(_ *parser.Packet, size int) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			m.encodedPackets++
+			m.encodedBytes += uint64(size)
+		},
+		MiddlewareError: func(nsp string, _ *socket.ExtendedError) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			m.middlewareRejections[nsp]++
+		},
+		Acknowledged: m.observeAck,
+		AckTimedOut: func(nsp string) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+
+			m.ackTimeouts[nsp]++
+		},
+	})
+
+	server.Sockets().On("new_namespace", func(args ...any) {
+		if nsp, ok := args[0].(socket.NamespaceInterface); ok {
+			m.instrumentNamespace(nsp)
+		}
+	})
+	server.Nsps().Range(func(_, nsp any) bool {
+		m.instrumentNamespace(nsp.(socket.NamespaceInterface))
+		return true
+	})
+}
+
+func (m *Metrics) instrumentNamespace(nsp socket.NamespaceInterface) {
+	name := nsp.Name()
+	metrics_log.Debug("instrumenting namespace %s", name)
+
+	isPrivateRoom := func(room socket.Room) bool {
+		// each socket joins a room named after its own id
+		_, ok := nsp.Adapter().Sids().Load(socket.SocketId(room))
+		return ok
+	}
+	nsp.Adapter().On("create-room", func(args ...any) {
+		if room, ok := args[0].(socket.Room); ok && !isPrivateRoom(room) {
+			m.addRooms(name, 1)
+		}
+	})
+	nsp.Adapter().On("delete-room", func(args ...any) {
+		if room, ok := args[0].(socket.Room); ok && !isPrivateRoom(room) {
+			m.addRooms(name, -1)
+		}
+	})
+	rooms := int64(0)
+	nsp.Adapter().Rooms().Range(func(room, _ any) bool {
+		if !isPrivateRoom(room.(socket.Room)) {
+			rooms++
+		}
+		return true
+	})
+	m.addRooms(name, rooms)
+
+	nsp.On("connection", func(args ...any) {
+		if client, ok := args[0].(*socket.Socket); ok {
+			m.mu.Lock()
+			m.connects[name]++
+			m.mu.Unlock()
+
+			m.instrumentSocket(name, client)
+		}
+	})
+	nsp.Sockets().Range(func(_, client any) bool {
+		m.instrumentSocket(name, client.(*socket.Socket))
+		return true
+	})
+}
+
+func (m *Metrics) instrumentSocket(nsp string, client *socket.Socket) {
+	m.mu.Lock()
+	m.connectedSockets[nsp]++
+	m.mu.Unlock()
+
+	client.On("disconnect", func(args ...any) {
+		reason := ""
+		if len(args) > 0 {
+			reason = fmt.Sprint(args[0])
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.connectedSockets[nsp]--
+		m.disconnects[[2]string{nsp, reason}]++
+	})
+	client.OnAny(func(args ...any) {
+		ev, _ := args[0].(string)
+		if client.ListenerCount(events.EventName(ev)) == 0 {
+			ev = UnknownEvent
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.eventsReceived[[2]string{nsp, ev}]++
+	})
+	client.OnAnyOutgoing(func(args ...any) {
+		ev, _ := args[0].(string)
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.eventsSent[[2]string{nsp, ev}]++
+	})
+}
+
+func (m *Metrics) addRooms(nsp string, delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rooms[nsp] += delta
+}
+
+func (m *Metrics) observeAck(nsp string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.ackLatency[nsp]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.ackLatency[nsp] = h
+	}
+	seconds := latency.Seconds()
+	for i, bound := range m.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// Serves the metrics in the Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// Writes the metrics in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+
+	m.mu.Lock()
+
+	writeHeader(&b, "socketio_connected_sockets", "gauge", "Number of sockets currently connected.")
+	for _, nsp := range sortedKeys(m.connectedSockets) {
+		fmt.Fprintf(&b, "socketio_connected_sockets{namespace=%s} %d\n", quote(nsp), m.connectedSockets[nsp])
+	}
+
+	writeHeader(&b, "socketio_connects_total", "counter", "Total number of sockets which have connected.")
+	for _, nsp := range sortedKeys(m.connects) {
+		fmt.Fprintf(&b, "socketio_connects_total{namespace=%s} %d\n", quote(nsp), m.connects[nsp])
+	}
+
+	writeHeader(&b, "socketio_disconnects_total", "counter", "Total number of sockets which have disconnected, by reason.")
+	for _, key := range sortedPairs(m.disconnects) {
+		fmt.Fprintf(&b, "socketio_disconnects_total{namespace=%s,reason=%s} %d\n", quote(key[0]), quote(key[1]), m.disconnects[key])
+	}
+
+	writeHeader(&b, "socketio_events_received_total", "counter", "Total number of events received from the clients, by name.")
+	for _, key := range sortedPairs(m.eventsReceived) {
+		fmt.Fprintf(&b, "socketio_events_received_total{namespace=%s,event=%s} %d\n", quote(key[0]), quote(key[1]), m.eventsReceived[key])
+	}
+
+	writeHeader(&b, "socketio_events_sent_total", "counter", "Total number of events sent to the clients, by name.")
+	for _, key := range sortedPairs(m.eventsSent) {
+		fmt.Fprintf(&b, "socketio_events_sent_total{namespace=%s,event=%s} %d\n", quote(key[0]), quote(key[1]), m.eventsSent[key])
+	}
+
+	writeHeader(&b, "socketio_encoded_packets_total", "counter", "Total number of packets encoded by the parser.")
+	fmt.Fprintf(&b, "socketio_encoded_packets_total %d\n", m.encodedPackets)
+
+	writeHeader(&b, "socketio_encoded_bytes_total", "counter", "Total number of bytes encoded by the parser.")
+	fmt.Fprintf(&b, "socketio_encoded_bytes_total %d\n", m.encodedBytes)
+
+	writeHeader(&b, "socketio_ack_latency_seconds", "histogram", "Time elapsed until the clients acknowledge the packets.")
+	for _, nsp := range sortedKeys(m.ackLatency) {
+		h := m.ackLatency[nsp]
+		for i, bound := range m.buckets {
+			fmt.Fprintf(&b, "socketio_ack_latency_seconds_bucket{namespace=%s,le=%s} %d\n", quote(nsp), quote(formatFloat(bound)), h.counts[i])
+		}
+		fmt.Fprintf(&b, "socketio_ack_latency_seconds_bucket{namespace=%s,le=\"+Inf\"} %d\n", quote(nsp), h.count)
+		fmt.Fprintf(&b, "socketio_ack_latency_seconds_sum{namespace=%s} %s\n", quote(nsp), formatFloat(h.sum))
+		fmt.Fprintf(&b, "socketio_ack_latency_seconds_count{namespace=%s} %d\n", quote(nsp), h.count)
+	}
+
+	writeHeader(&b, "socketio_ack_timeouts_total", "counter", "Total number of packets which were not acknowledged in the given delay.")
+	for _, nsp := range sortedKeys(m.ackTimeouts) {
+		fmt.Fprintf(&b, "socketio_ack_timeouts_total{namespace=%s} %d\n", quote(nsp), m.ackTimeouts[nsp])
+	}
+
+	writeHeader(&b, "socketio_middleware_rejections_total", "counter", "Total number of sockets rejected by a middleware.")
+	for _, nsp := range sortedKeys(m.middlewareRejections) {
+		fmt.Fprintf(&b, "socketio_middleware_rejections_total{namespace=%s} %d\n", quote(nsp), m.middlewareRejections[nsp])
+	}
+
+	writeHeader(&b, "socketio_rooms", "gauge", "Number of rooms, excluding the private room of each socket.")
+	for _, nsp := range sortedKeys(m.rooms) {
+		fmt.Fprintf(&b, "socketio_rooms{namespace=%s} %d\n", quote(nsp), m.rooms[nsp])
+	}
+
+	m.mu.Unlock()
+
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// Quotes a label value, as expected by the text exposition format.
+func quote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
+
+func formatFloat(f float64) string {
+	return fmt.Sprint(f)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedPairs(m map[[2]string]uint64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	return keys
+}
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zishang520/socket.io/client"
+	"github.com/zishang520/socket.io/socket"
+)
+
+// Waits until the exposition contains every line.
+func waitForLines(t *testing.T, m *Metrics, lines ...string) {
+	t.Helper()
+
+	var b strings.Builder
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		b.Reset()
+		m.WriteTo(&b)
+		missing := ""
+		for _, line := range lines {
+			if !strings.Contains(b.String(), line+"\n") {
+				missing = line
+				break
+			}
+		}
+		if missing == "" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q in:\n%s", missing, b.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestInstrument(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io := socket.NewServer(listener, nil)
+	defer io.Close(nil)
+	url := "http://" + listener.Addr().String()
+
+	m := New()
+	m.Instrument(io)
+
+	io.Of("/deny", nil).Use(func(_ *socket.Socket, next func(*socket.ExtendedError)) {
+		next(socket.NewExtendedError("denied", nil))
+	})
+	sockets := make(chan *socket.Socket, 1)
+	io.On("connection", func(args ...any) {
+		s := args[0].(*socket.Socket)
+		s.Join("room1", "room2")
+		s.On("hello", func(...any) {})
+		sockets <- s
+	})
+
+	opts := client.DefaultOptions()
+	opts.SetForceNew(true)
+	c, err := client.Io(url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+	c.On("ask", func(args ...any) {
+		args[len(args)-1].(func(...any))(1)
+	})
+	c.On("ignore", func(...any) {})
+	denied, err := client.Io(url+"/deny", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer denied.Disconnect()
+
+	var s *socket.Socket
+	select {
+	case s = <-sockets:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client did not connect")
+	}
+	c.Emit("hello", 1)
+	c.Emit("unknown", 1)
+	if _, err := s.EmitWithAck(context.Background(), "ask"); err != nil {
+		t.Fatal(err)
+	}
+	s.Timeout(10*time.Millisecond).EmitWithAck(context.Background(), "ignore")
+
+	waitForLines(t, m,
+		`socketio_connected_sockets{namespace="/"} 1`,
+		`socketio_connects_total{namespace="/"} 1`,
+		`socketio_events_received_total{namespace="/",event="hello"} 1`,
+		`socketio_events_received_total{namespace="/",event="_unknown"} 1`,
+		`socketio_events_sent_total{namespace="/",event="ask"} 1`,
+		`socketio_events_sent_total{namespace="/",event="ignore"} 1`,
+		`socketio_ack_latency_seconds_count{namespace="/"} 1`,
+		`socketio_ack_timeouts_total{namespace="/"} 1`,
+		`socketio_middleware_rejections_total{namespace="/deny"} 1`,
+		`socketio_rooms{namespace="/"} 2`,
+	)
+
+	c.Disconnect()
+	waitForLines(t, m,
+		`socketio_connected_sockets{namespace="/"} 0`,
+		`socketio_disconnects_total{namespace="/",reason="client namespace disconnect"} 1`,
+		`socketio_rooms{namespace="/"} 0`,
+	)
+
+	var b strings.Builder
+	m.WriteTo(&b)
+	if strings.Contains(b.String(), "socketio_encoded_packets_total 0\n") {
+		t.Errorf("expected the encoded packets to be counted:\n%s", b.String())
+	}
+}
+
+func TestAckLatency(t *testing.T) {
+	m := NewWithBuckets([]float64{1, 0.1})
+	m.observeAck("/", 50*time.Millisecond)
+	m.observeAck("/", 500*time.Millisecond)
+	m.observeAck("/", 2*time.Second)
+
+	waitForLines(t, m,
+		`socketio_ack_latency_seconds_bucket{namespace="/",le="0.1"} 1`,
+		`socketio_ack_latency_seconds_bucket{namespace="/",le="1"} 2`,
+		`socketio_ack_latency_seconds_bucket{namespace="/",le="+Inf"} 3`,
+		`socketio_ack_latency_seconds_sum{namespace="/"} 2.55`,
+		`socketio_ack_latency_seconds_count{namespace="/"} 3`,
+	)
+}
+
+func TestServeHTTP(t *testing.T) {
+	m := New()
+	m.addRooms("/a\"b\\c", 1)
+
+	recorder := httptest.NewRecorder()
+	m.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("unexpected content type %s", contentType)
+	}
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE socketio_rooms gauge",
+		`socketio_rooms{namespace="/a\"b\\c"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected %q in:\n%s", line, body)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package parser
 import (
 	"errors"
 	"io"
+	"reflect"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/zishang520/engine.io/types"
@@ -13,21 +14,22 @@ type Placeholder struct {
 	Num         int  `json:"num" mapstructure:"num"`
 }
 
-// Replaces every io.Reader | []byte in packet with a numbered placeholder.
-func DeconstructPacket(packet *Packet) (pack *Packet, buffers []types.BufferInterface) {
+// Replaces every io.Reader | []byte in packet, including the ones held by structs, typed slices and maps, with a
+// numbered placeholder. ErrCycle is returned if the data holds a cycle, the packet being left as is.
+func DeconstructPacket(packet *Packet) (pack *Packet, buffers []types.BufferInterface, err error) {
+	data, err := _deconstructPacket(packet.Data, &buffers)
+	if err != nil {
+		return nil, nil, err
+	}
 	pack = packet
-	pack.Data = _deconstructPacket(packet.Data, &buffers)
+	pack.Data = data
 	attachments := uint64(len(buffers))
 	pack.Attachments = &attachments // number of binary 'attachments'
-	return pack, buffers
+	return pack, buffers, nil
 }
 
-func _deconstructPacket(data any, buffers *[]types.BufferInterface) any {
-	if data == nil {
-		return nil
-	}
-
-	if IsBinary(data) {
+func _deconstructPacket(data any, buffers *[]types.BufferInterface) (any, error) {
+	return ReplaceValues(data, isBinaryType, func(data any) any {
 		_placeholder := &Placeholder{Placeholder: true, Num: len(*buffers)}
 		rdata := types.NewBytesBuffer(nil)
 		switch tdata := data.(type) {
@@ -38,31 +40,21 @@ func _deconstructPacket(data any, buffers *[]types.BufferInterface) any {
 			rdata.ReadFrom(tdata)
 		case []byte:
 			rdata.Write(tdata)
+		default:
+			// named byte slices
+			rdata.Write(reflect.ValueOf(data).Bytes())
 		}
 		*buffers = append(*buffers, rdata)
 		return _placeholder
-	} else {
-		switch tdata := data.(type) {
-		case []any:
-			newData := make([]any, 0, len(tdata))
-			for _, v := range tdata {
-				newData = append(newData, _deconstructPacket(v, buffers))
-			}
-			return newData
-		case map[string]any:
-			newData := map[string]any{}
-			for k, v := range tdata {
-				newData[k] = _deconstructPacket(v, buffers)
-			}
-			return newData
-		}
-	}
-	return data
+	})
 }
 
 // Reconstructs a binary packet from its placeholder packet and buffers
 func ReconstructPacket(data *Packet, buffers []types.BufferInterface) (_ *Packet, err error) {
 	data.Data, err = _reconstructPacket(data.Data, &buffers)
+	if err != nil {
+		return nil, err
+	}
 	data.Attachments = nil // no longer useful
 	return data, nil
 }
@@ -0,0 +1,162 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+)
+
+// A JSON implementation, such as jsoniter.ConfigCompatibleWithStandardLibrary or sonic.ConfigStd, used instead of
+// encoding/json. Unmarshal is called with a pointer to an `any`, the numbers being decoded as the implementation does:
+// NUMBER_MODE_JSON_NUMBER requires an implementation configured to produce json.Number, while NUMBER_MODE_INT64
+// converts both the json.Number and the integral float64 it produces (the integers above 2^53 keeping their
+// precision only with json.Number).
+type JSON interface {
+	Marshal(any) ([]byte, error)
+	Unmarshal([]byte, any) error
+}
+
+// encoding/json, decoding the numbers as json.Number when useNumber is true.
+type stdJSON struct {
+	useNumber bool
+}
+
+func (stdJSON) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (j stdJSON) Unmarshal(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if j.useNumber {
+		decoder.UseNumber()
+	}
+	return decoder.Decode(v)
+}
+
+// Converts the values of a type when a packet is encoded, and back when it is decoded, like the replacer and the
+// reviver of JSON.stringify() and JSON.parse().
+type TypeCodec struct {
+	// The type of the values which are converted by Encode, including the ones held by pointers, structs, slices and
+	// maps.
+	Type reflect.Type
+
+	// Returns the value which is sent instead of v, e.g. a string or a map[string]any.
+	Encode func(v any) (any, error)
+
+	// Returns the value revived from a decoded value (a string, a number, a []any, a map[string]any or a binary
+	// attachment), false if it is not an encoded value of the type.
+	Decode func(v any) (any, bool)
+}
+
+// Creates the codec of the type T. Either function can be nil.
+//
+//	parser.NewTypeCodec(func(t time.Time) (any, error) {
+//	    return map[string]any{"$date": t.Format(time.RFC3339Nano)}, nil
+//	}, func(v any) (time.Time, bool) {
+//	    m, _ := v.(map[string]any)
+//	    s, ok := m["$date"].(string)
+//	    if !ok || len(m) != 1 {
+//	        return time.Time{}, false
+//	    }
+//	    t, err := time.Parse(time.RFC3339Nano, s)
+//	    return t, err == nil
+//	})
+func NewTypeCodec[T any](encode func(T) (any, error), decode func(any) (T, bool)) *TypeCodec {
+	codec := &TypeCodec{Type: reflect.TypeOf((*T)(nil)).Elem()}
+	if encode != nil {
+		codec.Encode = func(v any) (any, error) {
+			return encode(v.(T))
+		}
+	}
+	if decode != nil {
+		codec.Decode = func(v any) (any, bool) {
+			return decode(v)
+		}
+	}
+	return codec
+}
+
+// Converts the values of the types of the codecs of the options held by the arguments of an event or an
+// acknowledgement, before the packet is encoded. The event name is left as is.
+func EncodePacketTypes(opts *ParserOptions, packet *Packet) error {
+	codecs := map[reflect.Type]*TypeCodec{}
+	for _, codec := range opts.TypeCodecs() {
+		if codec.Type != nil && codec.Encode != nil {
+			codecs[codec.Type] = codec
+		}
+	}
+	args, start := packetArguments(packet)
+	if len(codecs) == 0 || start >= len(args) {
+		return nil
+	}
+
+	var encodeErr error
+	encoded, err := ReplaceValues(args[start:], func(t reflect.Type) bool {
+		_, ok := codecs[t]
+		return ok
+	}, func(v any) any {
+		value, err := codecs[reflect.TypeOf(v)].Encode(v)
+		if err != nil && encodeErr == nil {
+			encodeErr = err
+		}
+		return value
+	})
+	if err != nil {
+		return err
+	}
+	if encodeErr != nil {
+		return encodeErr
+	}
+	packet.Data = append(append(make([]any, 0, len(args)), args[:start]...), encoded.([]any)...)
+	return nil
+}
+
+// Revives the values of the types of the codecs of the options held by the arguments of an event or an
+// acknowledgement, once the packet is decoded (with its attachments). The innermost values are revived first, by the
+// first codec which recognizes them.
+func DecodePacketTypes(opts *ParserOptions, packet *Packet) {
+	codecs := []*TypeCodec{}
+	for _, codec := range opts.TypeCodecs() {
+		if codec.Decode != nil {
+			codecs = append(codecs, codec)
+		}
+	}
+	args, start := packetArguments(packet)
+	if len(codecs) == 0 {
+		return
+	}
+	for i := start; i < len(args); i++ {
+		args[i] = decodeTypes(codecs, args[i])
+	}
+}
+
+// Returns the payload of an event or an acknowledgement, along with the index of its first argument.
+func packetArguments(packet *Packet) ([]any, int) {
+	args, _ := packet.Data.([]any)
+	switch packet.Type {
+	case EVENT, BINARY_EVENT:
+		return args, 1
+	case ACK, BINARY_ACK:
+		return args, 0
+	}
+	return nil, 0
+}
+
+func decodeTypes(codecs []*TypeCodec, data any) any {
+	switch tdata := data.(type) {
+	case []any:
+		for i, v := range tdata {
+			tdata[i] = decodeTypes(codecs, v)
+		}
+	case map[string]any:
+		for k, v := range tdata {
+			tdata[k] = decodeTypes(codecs, v)
+		}
+	}
+	for _, codec := range codecs {
+		if value, ok := codec.Decode(data); ok {
+			return value
+		}
+	}
+	return data
+}
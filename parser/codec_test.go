@@ -0,0 +1,152 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type userId string
+
+type checksum [2]byte
+
+type message struct {
+	From userId     `json:"from"`
+	At   *time.Time `json:"at"`
+}
+
+func testCodecs() []*TypeCodec {
+	return []*TypeCodec{
+		NewTypeCodec(func(t time.Time) (any, error) {
+			return map[string]any{"$date": t.Format(time.RFC3339Nano)}, nil
+		}, func(v any) (time.Time, bool) {
+			m, _ := v.(map[string]any)
+			s, ok := m["$date"].(string)
+			if !ok || len(m) != 1 {
+				return time.Time{}, false
+			}
+			t, err := time.Parse(time.RFC3339Nano, s)
+			return t, err == nil
+		}),
+		NewTypeCodec(func(id userId) (any, error) {
+			if id == "" {
+				return nil, errors.New("empty user id")
+			}
+			return map[string]any{"$user": string(id)}, nil
+		}, func(v any) (userId, bool) {
+			m, _ := v.(map[string]any)
+			s, ok := m["$user"].(string)
+			return userId(s), ok && len(m) == 1
+		}),
+		// sent as a binary attachment
+		NewTypeCodec(func(c checksum) (any, error) {
+			return c[:], nil
+		}, func(v any) (checksum, bool) {
+			b, ok := v.(interface{ Bytes() []byte })
+			if !ok || len(b.Bytes()) != 2 {
+				return checksum{}, false
+			}
+			return checksum{b.Bytes()[0], b.Bytes()[1]}, true
+		}),
+	}
+}
+
+// Encodes then decodes a packet with the given options.
+func roundTrip(t *testing.T, opts *ParserOptions, packet *Packet) *Packet {
+	t.Helper()
+
+	buffers, err := NewEncoderWithOptions(opts).Encode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded *Packet
+	decoder := NewDecoderWithOptions(opts)
+	decoder.On("decoded", func(args ...any) {
+		decoded = args[0].(*Packet)
+	})
+	for _, buffer := range buffers {
+		if err := decoder.Add(buffer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if decoded == nil {
+		t.Fatal("the packet was not decoded")
+	}
+	return decoded
+}
+
+func TestTypeCodecs(t *testing.T) {
+	opts := DefaultParserOptions()
+	opts.SetTypeCodecs(testCodecs())
+	at := time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC)
+
+	decoded := roundTrip(t, opts, &Packet{Type: EVENT, Nsp: "/", Data: []any{
+		"event",
+		at,
+		message{From: "alice", At: &at},
+		map[string]any{"users": []userId{"bob"}},
+		checksum{1, 2},
+	}})
+	if decoded.Type != BINARY_EVENT {
+		t.Errorf("expected the checksum to be sent as an attachment, got the type %v", decoded.Type)
+	}
+	expected := []any{
+		"event",
+		at,
+		map[string]any{"from": userId("alice"), "at": at},
+		map[string]any{"users": []any{userId("bob")}},
+		checksum{1, 2},
+	}
+	if !reflect.DeepEqual(decoded.Data, expected) {
+		t.Errorf("expected %v, got %v", expected, decoded.Data)
+	}
+
+	// the arguments of an acknowledgement start at the first value
+	id := uint64(1)
+	decoded = roundTrip(t, opts, &Packet{Type: ACK, Nsp: "/", Id: &id, Data: []any{userId("carol")}})
+	if !reflect.DeepEqual(decoded.Data, []any{userId("carol")}) {
+		t.Errorf("unexpected acknowledgement %v", decoded.Data)
+	}
+}
+
+func TestTypeCodecsEncodeError(t *testing.T) {
+	opts := DefaultParserOptions()
+	opts.SetTypeCodecs(testCodecs())
+
+	_, err := NewEncoderWithOptions(opts).Encode(&Packet{Type: EVENT, Nsp: "/", Data: []any{"event", userId("")}})
+	var encodeErr *EncodeError
+	if !errors.As(err, &encodeErr) || encodeErr.Err.Error() != "empty user id" {
+		t.Fatalf("expected the *EncodeError of the codec, got %v", err)
+	}
+}
+
+// Counts the calls to encoding/json.
+type countingJSON struct {
+	marshal, unmarshal int
+}
+
+func (c *countingJSON) Marshal(v any) ([]byte, error) {
+	c.marshal++
+	return json.Marshal(v)
+}
+
+func (c *countingJSON) Unmarshal(data []byte, v any) error {
+	c.unmarshal++
+	return json.Unmarshal(data, v)
+}
+
+func TestCustomJSON(t *testing.T) {
+	codec := &countingJSON{}
+	opts := DefaultParserOptions()
+	opts.SetJSON(codec)
+
+	decoded := roundTrip(t, opts, &Packet{Type: EVENT, Nsp: "/", Data: []any{"event", map[string]any{"a": 1}, []byte{1}}})
+	if codec.marshal != 1 || codec.unmarshal != 1 {
+		t.Errorf("expected the custom implementation to be used once each way, got %+v", codec)
+	}
+	if data := decoded.Data.([]any); data[1].(map[string]any)["a"] != float64(1) {
+		t.Errorf("unexpected data %v", data)
+	}
+}
@@ -1,13 +1,13 @@
 package parser
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/zishang520/engine.io/events"
 	"github.com/zishang520/engine.io/log"
@@ -20,12 +20,22 @@ var parser_log = log.NewLog("socket.io:parser")
 type decoder struct {
 	events.EventEmitter
 
+	opts          *ParserOptions
 	reconstructor *binaryreconstructor
 	mu            sync.RWMutex
 }
 
 func NewDecoder() Decoder {
-	return &decoder{EventEmitter: events.New()}
+	return NewDecoderWithOptions(nil)
+}
+
+// Creates a decoder enforcing the limits of the options. Add() returns a *LimitError when a packet exceeds one of
+// them, while an "error" event is emitted with it when the attachments of a packet are not received in time.
+func NewDecoderWithOptions(opts *ParserOptions) Decoder {
+	if opts == nil {
+		opts = DefaultParserOptions()
+	}
+	return &decoder{EventEmitter: events.New(), opts: opts}
 }
 
 // Decodes an encoded packet string into packet JSON.
@@ -69,11 +79,11 @@ func (d *decoder) Add(data any) error {
 		if IsBinary(data) {
 			// raw binary data
 			d.mu.RLock()
-			if d.reconstructor == nil {
-				defer d.mu.RUnlock()
+			reconstructor := d.reconstructor
+			d.mu.RUnlock()
+			if reconstructor == nil {
 				return errors.New("got binary data when not reconstructing a packet")
 			}
-			d.mu.RUnlock()
 
 			rdata := types.NewBytesBuffer(nil)
 			switch tdata := data.(type) {
@@ -89,18 +99,19 @@ func (d *decoder) Add(data any) error {
 					return err
 				}
 			}
-			d.mu.RLock()
-			packet, err := d.reconstructor.takeBinaryData(rdata)
-			d.mu.RUnlock()
+			packet, err := reconstructor.takeBinaryData(rdata)
 			if err != nil {
+				// the buffers already received are released
+				d.reset(reconstructor)
+				if _, ok := err.(*LimitError); ok {
+					return err
+				}
 				return errors.New(fmt.Sprintf("Decode error: %v", err.Error()))
 			}
 			if packet != nil {
 				// received final buffer
-				d.mu.Lock()
-				d.reconstructor = nil
-				d.mu.Unlock()
-				d.Emit("decoded", packet)
+				d.reset(reconstructor)
+				d.emitDecoded(packet)
 			}
 		} else {
 			return errors.New(fmt.Sprintf("Unknown type: %v", data))
@@ -117,21 +128,38 @@ func (d *decoder) decodeAsString(str types.BufferInterface) error {
 		return err
 	}
 	if packet.Type == BINARY_EVENT || packet.Type == BINARY_ACK {
-		// binary packet's json
-		d.mu.Lock()
-		d.reconstructor = NewBinaryReconstructor(packet)
-		d.mu.Unlock()
 		// no attachments, labeled binary but no binary data to follow
 		if attachments := packet.Attachments; attachments != nil && *attachments == 0 {
-			d.Emit("decoded", packet)
+			d.emitDecoded(packet)
+			return nil
+		}
+		// binary packet's json
+		reconstructor := NewBinaryReconstructor(packet)
+		reconstructor.maxBytes = d.opts.MaxAttachmentBytes()
+		if timeout := d.opts.ReconstructionTimeout(); timeout > 0 {
+			reconstructor.timer = time.AfterFunc(timeout, func() {
+				if d.reset(reconstructor) {
+					parser_log.Debug("the attachments of the packet were not received in time")
+					d.Emit("error", &LimitError{Err: ErrReconstructionTimeout, Limit: timeout.Milliseconds()})
+				}
+			})
 		}
+		d.mu.Lock()
+		d.reconstructor = reconstructor
+		d.mu.Unlock()
 	} else {
 		// non-binary full packet
-		d.Emit("decoded", packet)
+		d.emitDecoded(packet)
 	}
 	return nil
 }
 
+// Emits a decoded packet, once the values of the type codecs are revived.
+func (d *decoder) emitDecoded(packet *Packet) {
+	DecodePacketTypes(d.opts, packet)
+	d.Emit("decoded", packet)
+}
+
 // Decode a packet String (JSON data)
 func (d *decoder) decodeString(str types.BufferInterface) (packet *Packet, err error) {
 	defer func(str string) {
@@ -165,6 +193,9 @@ func (d *decoder) decodeString(str types.BufferInterface) (packet *Packet, err e
 		if err != nil {
 			return nil, errors.New("Illegal attachments")
 		}
+		if max := d.opts.MaxAttachments(); max > 0 && attachments > max {
+			return nil, &LimitError{Err: ErrTooManyAttachments, Limit: int64(max)}
+		}
 		packet.Attachments = &attachments
 	}
 
@@ -232,15 +263,23 @@ func (d *decoder) decodeString(str types.BufferInterface) (packet *Packet, err e
 
 	// look up json data
 	if str.Len() > 0 {
+		if err := checkJSONDepth(d.opts, str.Bytes()); err != nil {
+			return nil, err
+		}
 		var payload any
-		if json.NewDecoder(str).Decode(&payload) != nil {
+		if d.opts.JSON().Unmarshal(str.Bytes(), &payload) != nil {
 			return nil, errors.New("invalid payload")
 		}
-		if isPayloadValid(packet.Type, payload) {
-			packet.Data = payload
-		} else {
+		if d.opts.NumberMode() == NUMBER_MODE_INT64 {
+			payload = ConvertNumbers(payload)
+		}
+		if !isPayloadValid(packet.Type, payload) {
 			return nil, errors.New("invalid payload")
 		}
+		if err := CheckArguments(d.opts, packet.Type, payload); err != nil {
+			return nil, err
+		}
+		packet.Data = payload
 	}
 
 	return packet, nil
@@ -269,12 +308,27 @@ func isPayloadValid(t PacketType, payload any) bool {
 	return false
 }
 
+// Stops the reconstruction of a packet, returns false if it is not the current one anymore.
+func (d *decoder) reset(reconstructor *binaryreconstructor) bool {
+	d.mu.Lock()
+	current := d.reconstructor == reconstructor
+	if current {
+		d.reconstructor = nil
+	}
+	d.mu.Unlock()
+
+	reconstructor.finishedReconstruction()
+	return current
+}
+
 // Deallocates a parser's resources
 func (d *decoder) Destroy() {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
+	d.mu.Lock()
+	reconstructor := d.reconstructor
+	d.reconstructor = nil
+	d.mu.Unlock()
 
-	if d.reconstructor != nil {
-		d.reconstructor.finishedReconstruction()
+	if reconstructor != nil {
+		reconstructor.finishedReconstruction()
 	}
 }
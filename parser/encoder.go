@@ -1,27 +1,59 @@
 package parser
 
 import (
-	"encoding/json"
+	"fmt"
+	"reflect"
 	"strconv"
 	"strings"
 
 	"github.com/zishang520/engine.io/types"
 )
 
+// The error returned by an encoder when the data of a packet cannot be encoded, e.g. a channel or a NaN float.
+type EncodeError struct {
+	// The packet which has not been encoded
+	Packet *Packet
+	Err    error
+}
+
+func (e *EncodeError) Error() string {
+	return fmt.Sprintf("cannot encode the data of the packet: %v", e.Err)
+}
+
+func (e *EncodeError) Unwrap() error {
+	return e.Err
+}
+
 // A socket.io Encoder instance
 type encoder struct {
+	opts *ParserOptions
 }
 
 func NewEncoder() Encoder {
-	return &encoder{}
+	return NewEncoderWithOptions(nil)
+}
+
+// Creates an encoder using the JSON implementation and the type codecs of the options.
+func NewEncoderWithOptions(opts *ParserOptions) Encoder {
+	if opts == nil {
+		opts = DefaultParserOptions()
+	}
+	return &encoder{opts: opts}
 }
 
 // Encode a packet as a single string if non-binary, or as a
 // buffer sequence, depending on packet type.
-func (e *encoder) Encode(packet *Packet) []types.BufferInterface {
+func (e *encoder) Encode(packet *Packet) ([]types.BufferInterface, error) {
 	parser_log.Debug("encoding packet %v", packet)
+	if err := EncodePacketTypes(e.opts, packet); err != nil {
+		return nil, &EncodeError{Packet: packet, Err: err}
+	}
 	if packet.Type == EVENT || packet.Type == ACK {
-		if HasBinary(packet.Data) {
+		hasBinary, err := HasBinary(packet.Data)
+		if err != nil {
+			return nil, &EncodeError{Packet: packet, Err: err}
+		}
+		if hasBinary {
 			if packet.Type == EVENT {
 				packet.Type = BINARY_EVENT
 			} else {
@@ -30,40 +62,23 @@ func (e *encoder) Encode(packet *Packet) []types.BufferInterface {
 			return e.encodeAsBinary(packet)
 		}
 	}
-	return []types.BufferInterface{e.encodeAsString(packet)}
-}
-
-func _encodeData(data any) any {
-	if data == nil {
-		return nil
+	str, err := e.encodeAsString(packet)
+	if err != nil {
+		return nil, err
 	}
+	return []types.BufferInterface{str}, nil
+}
 
-	// *strings.Reader special handling
-	if tdata, ok := data.(*strings.Reader); ok {
-		rdata, _ := types.NewStringBufferReader(tdata)
+// Replaces every *strings.Reader with a *types.StringBuffer, which is encoded as a JSON string.
+func _encodeData(data any) (any, error) {
+	return ReplaceValues(data, func(t reflect.Type) bool { return t == stringReaderType }, func(data any) any {
+		rdata, _ := types.NewStringBufferReader(data.(*strings.Reader))
 		return rdata
-	}
-
-	switch tdata := data.(type) {
-	case []any:
-		newData := make([]any, 0, len(tdata))
-		for _, v := range tdata {
-			newData = append(newData, _encodeData(v))
-		}
-		return newData
-	case map[string]any:
-		newData := map[string]any{}
-		for k, v := range tdata {
-			newData[k] = _encodeData(v)
-		}
-		return newData
-	}
-
-	return data
+	})
 }
 
 // Encode packet as string.
-func (e *encoder) encodeAsString(packet *Packet) types.BufferInterface {
+func (e *encoder) encodeAsString(packet *Packet) (types.BufferInterface, error) {
 	// first is type
 	str := types.NewStringBuffer([]byte{byte(packet.Type)})
 	// attachments if we have them
@@ -83,18 +98,32 @@ func (e *encoder) encodeAsString(packet *Packet) types.BufferInterface {
 	}
 	// json data
 	if nil != packet.Data {
-		if b, err := json.Marshal(_encodeData(packet.Data)); err == nil {
-			str.Write(b)
+		data, err := _encodeData(packet.Data)
+		if err != nil {
+			return nil, &EncodeError{Packet: packet, Err: err}
+		}
+		b, err := e.opts.JSON().Marshal(data)
+		if err != nil {
+			parser_log.Debug("encoding error %v", err)
+			return nil, &EncodeError{Packet: packet, Err: err}
 		}
+		str.Write(b)
 	}
 	parser_log.Debug("encoded %v as %v", packet, str)
-	return str
+	return str, nil
 }
 
 // Encode packet as 'buffer sequence' by removing blobs, and
 // deconstructing packet into object with placeholders and
 // a list of buffers.
-func (e *encoder) encodeAsBinary(obj *Packet) []types.BufferInterface {
-	packet, buffers := DeconstructPacket(obj)
-	return append([]types.BufferInterface{e.encodeAsString(packet)}, buffers...) // write all the buffers
+func (e *encoder) encodeAsBinary(obj *Packet) ([]types.BufferInterface, error) {
+	packet, buffers, err := DeconstructPacket(obj)
+	if err != nil {
+		return nil, &EncodeError{Packet: obj, Err: err}
+	}
+	str, err := e.encodeAsString(packet)
+	if err != nil {
+		return nil, err
+	}
+	return append([]types.BufferInterface{str}, buffers...), nil // write all the buffers
 }
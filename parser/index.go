@@ -2,6 +2,7 @@ package parser
 
 import (
 	"sync"
+	"time"
 
 	"github.com/zishang520/engine.io/types"
 )
@@ -16,6 +17,12 @@ type binaryreconstructor struct {
 	buffers   []types.BufferInterface
 	reconPack *Packet
 
+	// the number of bytes of the buffers, and the maximum (no limit when it is lower than 1)
+	size     int64
+	maxBytes int64
+	// discards the packet when its buffers are not received in time
+	timer *time.Timer
+
 	mu sync.Mutex
 }
 
@@ -36,6 +43,10 @@ func (b *binaryreconstructor) takeBinaryData(binData types.BufferInterface) (*Pa
 		return nil, nil
 	}
 
+	b.size += int64(binData.Len())
+	if b.maxBytes > 0 && b.size > b.maxBytes {
+		return nil, &LimitError{Err: ErrAttachmentsTooLarge, Limit: b.maxBytes}
+	}
 	b.buffers = append(b.buffers, binData)
 
 	if attachments := b.reconPack.Attachments; attachments != nil && uint64(len(b.buffers)) == *attachments {
@@ -59,4 +70,8 @@ func (b *binaryreconstructor) finishedReconstruction() {
 
 	b.reconPack = nil
 	b.buffers = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
 }
@@ -2,40 +2,50 @@ package parser
 
 import (
 	"io"
+	"reflect"
 	"strings"
 
 	"github.com/zishang520/engine.io/types"
 )
 
+var (
+	readerType       = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	stringBufferType = reflect.TypeOf((*types.StringBuffer)(nil))
+	stringReaderType = reflect.TypeOf((*strings.Reader)(nil))
+)
+
 // Returns true if obj is a Buffer or a File.
 func IsBinary(data any) bool {
 	switch data.(type) {
+	case nil:
+		return false
 	case *types.StringBuffer: // false
+		return false
 	case *strings.Reader: // false
+		return false
 	case []byte:
 		return true
 	case io.Reader:
 		return true
 	}
-	return false
+	return isBinaryType(reflect.TypeOf(data))
 }
 
-func HasBinary(data any) bool {
-	switch o := data.(type) {
-	case nil:
+// Whether the values of the type are sent as binary attachments: the io.Reader (except the text readers) and the byte
+// slices, including the named ones, which json.Marshal would encode as base64.
+func isBinaryType(t reflect.Type) bool {
+	if t == stringBufferType || t == stringReaderType {
 		return false
-	case []any:
-		for _, v := range o {
-			if HasBinary(v) {
-				return true
-			}
-		}
-	case map[string]any:
-		for _, v := range o {
-			if HasBinary(v) {
-				return true
-			}
-		}
 	}
-	return IsBinary(data)
+	if t.Implements(readerType) {
+		return true
+	}
+	return t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 && !isOpaqueType(t) && !isOpaqueType(t.Elem())
+}
+
+// Returns true if the data holds a binary value, looking into the structs (like json.Marshal does), pointers, slices,
+// arrays and maps. ErrCycle is returned if the data holds a cycle.
+func HasBinary(data any) (bool, error) {
+	w := &walker{match: isBinaryType, types: map[reflect.Type]bool{}}
+	return w.has(reflect.ValueOf(data))
 }
@@ -0,0 +1,189 @@
+package msgpack
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/zishang520/engine.io/events"
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/socket.io/parser"
+)
+
+// A socket.io Decoder instance
+type decoder struct {
+	events.EventEmitter
+
+	opts *parser.ParserOptions
+}
+
+func NewDecoder() parser.Decoder {
+	return NewDecoderWithOptions(nil)
+}
+
+// Creates a decoder enforcing the depth and arguments limits of the options, Add() returning a *parser.LimitError
+// when a packet exceeds one of them, and reviving the values of the type codecs of the options.
+func NewDecoderWithOptions(opts *parser.ParserOptions) parser.Decoder {
+	if opts == nil {
+		opts = parser.DefaultParserOptions()
+	}
+	return &decoder{EventEmitter: events.New(), opts: opts}
+}
+
+// Decodes a MessagePack binary frame into a packet.
+func (d *decoder) Add(data any) error {
+	var reader io.Reader
+	switch tdata := data.(type) {
+	case []byte:
+		reader = types.NewBytesBuffer(tdata)
+	case *types.BytesBuffer:
+		reader = tdata
+	case *types.StringBuffer, string:
+		return errors.New("got plaintext data with a MessagePack parser")
+	case io.Reader:
+		if c, ok := tdata.(io.Closer); ok {
+			defer c.Close()
+		}
+		reader = tdata
+	default:
+		return errors.New(fmt.Sprintf("Unknown type: %v", data))
+	}
+
+	packet, err := d.decode(reader)
+	if err != nil {
+		msgpack_log.Debug("decode err %v", err)
+		return err
+	}
+	msgpack_log.Debug("decoded %v", packet)
+	parser.DecodePacketTypes(d.opts, packet)
+	d.Emit("decoded", packet)
+	return nil
+}
+
+func (d *decoder) decode(r io.Reader) (*parser.Packet, error) {
+	dec := msgpack.NewDecoder(r)
+
+	decoded, err := dec.DecodeInterface()
+	if err != nil {
+		return nil, errors.New("invalid payload")
+	}
+	obj, ok := _decodeData(decoded).(map[string]any)
+	if !ok {
+		return nil, errors.New("invalid payload")
+	}
+
+	packet := &parser.Packet{}
+
+	t, ok := toUint(obj["type"])
+	if !ok || t > CONNECT_ERROR {
+		return nil, errors.New("invalid packet type")
+	}
+	packet.Type = parser.CONNECT + parser.PacketType(t)
+
+	nsp, ok := obj["nsp"].(string)
+	if !ok {
+		return nil, errors.New("invalid namespace")
+	}
+	packet.Nsp = nsp
+
+	if data, ok := obj["data"]; ok {
+		packet.Data = _decodeData(data)
+	}
+	if !isDataValid(packet.Type, packet.Data) {
+		return nil, errors.New("invalid payload")
+	}
+	if err := parser.CheckDepth(d.opts, packet.Data); err != nil {
+		return nil, err
+	}
+	if err := parser.CheckArguments(d.opts, packet.Type, packet.Data); err != nil {
+		return nil, err
+	}
+
+	if _id, ok := obj["id"]; ok {
+		id, ok := toUint(_id)
+		if !ok {
+			return nil, errors.New("invalid packet id")
+		}
+		packet.Id = &id
+	}
+
+	return packet, nil
+}
+
+func toUint(v any) (uint64, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return n, true
+	case int64:
+		return uint64(n), n >= 0
+	case float64:
+		return uint64(n), n >= 0 && n == float64(uint64(n))
+	}
+	return 0, false
+}
+
+// Converts the keys of nested maps to strings, every integer to int64 (or uint64) and every bin to a buffer, like the
+// default parser does for attachments.
+func _decodeData(data any) any {
+	switch tdata := data.(type) {
+	case int8:
+		return int64(tdata)
+	case int16:
+		return int64(tdata)
+	case int32:
+		return int64(tdata)
+	case uint8:
+		return uint64(tdata)
+	case uint16:
+		return uint64(tdata)
+	case uint32:
+		return uint64(tdata)
+	case float32:
+		return float64(tdata)
+	case []byte:
+		return types.NewBytesBuffer(tdata)
+	case []any:
+		for i, v := range tdata {
+			tdata[i] = _decodeData(v)
+		}
+		return tdata
+	case map[string]any:
+		for k, v := range tdata {
+			tdata[k] = _decodeData(v)
+		}
+		return tdata
+	case map[any]any:
+		newData := map[string]any{}
+		for k, v := range tdata {
+			newData[fmt.Sprint(k)] = _decodeData(v)
+		}
+		return newData
+	}
+	return data
+}
+
+func isDataValid(t parser.PacketType, payload any) bool {
+	switch t {
+	case parser.CONNECT:
+		if payload == nil {
+			return true
+		}
+		_, ok := payload.(map[string]any)
+		return ok
+	case parser.DISCONNECT:
+		return payload == nil
+	case parser.CONNECT_ERROR:
+		_, ok := payload.(map[string]any)
+		if !ok {
+			_, ok = payload.(string)
+		}
+		return ok
+	}
+	_, ok := payload.([]any)
+	return ok
+}
+
+// Deallocates a parser's resources
+func (d *decoder) Destroy() {
+}
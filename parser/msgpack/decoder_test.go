@@ -0,0 +1,173 @@
+package msgpack
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/socket.io/parser"
+)
+
+// Decodes a frame, returning the packet emitted by the decoder.
+func decode(t *testing.T, opts *parser.ParserOptions, data any) (*parser.Packet, error) {
+	t.Helper()
+
+	var packet *parser.Packet
+	decoder := NewDecoderWithOptions(opts)
+	decoder.On("decoded", func(args ...any) {
+		packet = args[0].(*parser.Packet)
+	})
+	err := decoder.Add(data)
+	return packet, err
+}
+
+func TestEncode(t *testing.T) {
+	id := uint64(3)
+	buffers, err := NewEncoder().Encode(&parser.Packet{Type: parser.BINARY_EVENT, Nsp: "/", Data: []any{"a", []byte{1}}, Id: &id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// {"type": 2, "data": ["a", <Buffer 01>], "nsp": "/", "id": 3}, a binary event being a regular event
+	expected := []byte{
+		0x84,
+		0xa4, 't', 'y', 'p', 'e', 0x02,
+		0xa4, 'd', 'a', 't', 'a', 0x92, 0xa1, 'a', 0xc4, 0x01, 0x01,
+		0xa3, 'n', 's', 'p', 0xa1, '/',
+		0xa2, 'i', 'd', 0x03,
+	}
+	if len(buffers) != 1 || !bytes.Equal(buffers[0].Bytes(), expected) {
+		t.Errorf("expected a single frame % x, got %v", expected, buffers)
+	}
+
+	buffers, err = NewEncoder().Encode(&parser.Packet{Type: parser.EVENT, Nsp: "/", Data: []any{"a", strings.NewReader("text"), types.NewBytesBuffer([]byte{2})}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := decode(t, nil, buffers[0].Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := packet.Data.([]any); data[1] != "text" || !bytes.Equal(data[2].(*types.BytesBuffer).Bytes(), []byte{2}) {
+		t.Errorf("expected the readers to be encoded as their content, got %v", data)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	// {"nsp": "/admin", "id": 300, "type": 3, "data": [{"n": -1, "b": <Buffer 0a>}]}, as another encoder may order it
+	frame := []byte{
+		0x84,
+		0xa3, 'n', 's', 'p', 0xa6, '/', 'a', 'd', 'm', 'i', 'n',
+		0xa2, 'i', 'd', 0xcd, 0x01, 0x2c,
+		0xa4, 't', 'y', 'p', 'e', 0x03,
+		0xa4, 'd', 'a', 't', 'a', 0x91, 0x82, 0xa1, 'n', 0xff, 0xa1, 'b', 0xc4, 0x01, 0x0a,
+	}
+	packet, err := decode(t, nil, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if packet.Type != parser.ACK || packet.Nsp != "/admin" || packet.Id == nil || *packet.Id != 300 {
+		t.Errorf("unexpected packet %v", packet)
+	}
+	data := packet.Data.([]any)[0].(map[string]any)
+	if data["n"] != int64(-1) || !bytes.Equal(data["b"].(*types.BytesBuffer).Bytes(), []byte{0x0a}) {
+		t.Errorf("unexpected data %v", data)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for name, data := range map[string]any{
+		"text":         "2[\"a\"]",
+		"not a map":    []byte{0x92, 0x01, 0x02},
+		"invalid type": []byte{0x82, 0xa4, 't', 'y', 'p', 'e', 0x05, 0xa3, 'n', 's', 'p', 0xa1, '/'},
+		"no namespace": []byte{0x81, 0xa4, 't', 'y', 'p', 'e', 0x00},
+		"event data":   []byte{0x83, 0xa4, 't', 'y', 'p', 'e', 0x02, 0xa4, 'd', 'a', 't', 'a', 0x01, 0xa3, 'n', 's', 'p', 0xa1, '/'},
+		"truncated":    []byte{0x84, 0xa4, 't', 'y'},
+	} {
+		if packet, err := decode(t, nil, data); err == nil {
+			t.Errorf("%s: expected an error, got %v", name, packet)
+		}
+	}
+
+	opts := parser.DefaultParserOptions()
+	opts.SetMaxArguments(1)
+	// {"type": 2, "data": ["a", 1, 2], "nsp": "/"}
+	frame := []byte{0x83, 0xa4, 't', 'y', 'p', 'e', 0x02, 0xa4, 'd', 'a', 't', 'a', 0x93, 0xa1, 'a', 0x01, 0x02, 0xa3, 'n', 's', 'p', 0xa1, '/'}
+	if _, err := decode(t, opts, frame); !errors.Is(err, parser.ErrTooManyArguments) {
+		t.Errorf("expected ErrTooManyArguments, got %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	type point struct {
+		X int64 `json:"x"`
+		Y int64 `json:"y"`
+	}
+	packet := &parser.Packet{Type: parser.CONNECT_ERROR, Nsp: "/", Data: map[string]any{"message": "nope", "data": point{1, 2}}}
+	buffers, err := NewEncoder().Encode(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decode(t, nil, buffers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{"message": "nope", "data": map[string]any{"x": int64(1), "y": int64(2)}}
+	if decoded.Type != parser.CONNECT_ERROR || !reflect.DeepEqual(decoded.Data, expected) {
+		t.Errorf("expected %v, got %v", expected, decoded.Data)
+	}
+}
+
+func TestTypeCodecs(t *testing.T) {
+	type checksum [2]byte
+	opts := parser.DefaultParserOptions()
+	opts.SetTypeCodecs([]*parser.TypeCodec{
+		parser.NewTypeCodec(func(t time.Time) (any, error) {
+			return map[string]any{"$date": t.UnixMilli()}, nil
+		}, func(v any) (time.Time, bool) {
+			m, _ := v.(map[string]any)
+			// the positive integers are decoded as uint64
+			ms, ok := m["$date"].(uint64)
+			return time.UnixMilli(int64(ms)).UTC(), ok && len(m) == 1
+		}),
+		parser.NewTypeCodec(func(c checksum) (any, error) {
+			return c[:], nil
+		}, func(v any) (checksum, bool) {
+			b, ok := v.(*types.BytesBuffer)
+			if !ok || b.Len() != 2 {
+				return checksum{}, false
+			}
+			return checksum{b.Bytes()[0], b.Bytes()[1]}, true
+		}),
+	})
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	buffers, err := NewEncoderWithOptions(opts).Encode(&parser.Packet{Type: parser.EVENT, Nsp: "/", Data: []any{
+		"event", map[string]any{"at": at, "sum": checksum{1, 2}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := buffers[0].Bytes()
+	decoded, err := decode(t, opts, append([]byte{}, frame...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []any{"event", map[string]any{"at": at, "sum": checksum{1, 2}}}
+	if !reflect.DeepEqual(decoded.Data, expected) {
+		t.Errorf("expected %v, got %v", expected, decoded.Data)
+	}
+
+	// the values are left as is without the codecs
+	decoded, err = decode(t, nil, frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := decoded.Data.([]any)[1].(map[string]any)
+	if value["at"].(map[string]any)["$date"] != uint64(at.UnixMilli()) || !bytes.Equal(value["sum"].(*types.BytesBuffer).Bytes(), []byte{1, 2}) {
+		t.Errorf("unexpected data %v", decoded.Data)
+	}
+}
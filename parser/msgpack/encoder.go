@@ -0,0 +1,143 @@
+package msgpack
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/socket.io/parser"
+)
+
+// A socket.io Encoder instance
+type encoder struct {
+	opts *parser.ParserOptions
+}
+
+func NewEncoder() parser.Encoder {
+	return NewEncoderWithOptions(nil)
+}
+
+// Creates an encoder using the type codecs of the options.
+func NewEncoderWithOptions(opts *parser.ParserOptions) parser.Encoder {
+	if opts == nil {
+		opts = parser.DefaultParserOptions()
+	}
+	return &encoder{opts: opts}
+}
+
+// Encode a packet as a single MessagePack binary frame.
+func (e *encoder) Encode(packet *parser.Packet) ([]types.BufferInterface, error) {
+	msgpack_log.Debug("encoding packet %v", packet)
+	if err := parser.EncodePacketTypes(e.opts, packet); err != nil {
+		return nil, &parser.EncodeError{Packet: packet, Err: err}
+	}
+	buf := types.NewBytesBuffer(nil)
+	if err := e.encode(buf, packet); err != nil {
+		msgpack_log.Debug("encoding error %v", err)
+		return nil, &parser.EncodeError{Packet: packet, Err: err}
+	}
+	return []types.BufferInterface{buf}, nil
+}
+
+func (e *encoder) encode(w io.Writer, packet *parser.Packet) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+
+	size := 2
+	if packet.Data != nil {
+		size++
+		// MessagePack does not detect the cycles, which would overflow the stack
+		if err := parser.CheckCycles(packet.Data); err != nil {
+			return err
+		}
+	}
+	if packet.Id != nil {
+		size++
+	}
+	if err := enc.EncodeMapLen(size); err != nil {
+		return err
+	}
+	if err := enc.EncodeString("type"); err != nil {
+		return err
+	}
+	if err := enc.EncodeUint(packetType(packet.Type)); err != nil {
+		return err
+	}
+	if packet.Data != nil {
+		if err := enc.EncodeString("data"); err != nil {
+			return err
+		}
+		data, err := _encodeData(packet.Data)
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(data); err != nil {
+			return err
+		}
+	}
+	if err := enc.EncodeString("nsp"); err != nil {
+		return err
+	}
+	if err := enc.EncodeString(packet.Nsp); err != nil {
+		return err
+	}
+	if packet.Id != nil {
+		if err := enc.EncodeString("id"); err != nil {
+			return err
+		}
+		if err := enc.EncodeUint(*packet.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Converts the packet type to its wire representation, binary packets being regular packets with MessagePack.
+func packetType(t parser.PacketType) uint64 {
+	switch t {
+	case parser.BINARY_EVENT:
+		return EVENT
+	case parser.BINARY_ACK:
+		return ACK
+	}
+	return uint64(t - parser.CONNECT)
+}
+
+var (
+	readerType = reflect.TypeOf((*io.Reader)(nil)).Elem()
+	numberType = reflect.TypeOf(json.Number(""))
+)
+
+// Replaces every io.Reader with its content, so that it is encoded as a MessagePack bin (or str for text readers), the
+// structs holding readers being converted into maps.
+func _encodeData(data any) (any, error) {
+	return parser.ReplaceValues(data, needsEncoding, func(data any) any {
+		switch tdata := data.(type) {
+		case json.Number:
+			// would be encoded as a MessagePack str otherwise
+			return parser.ConvertNumber(tdata)
+		case *types.StringBuffer:
+			return tdata.String()
+		case *strings.Reader:
+			rdata, _ := types.NewStringBufferReader(tdata)
+			return rdata.String()
+		case io.Reader:
+			if c, ok := tdata.(io.Closer); ok {
+				defer c.Close()
+			}
+			rdata := types.NewBytesBuffer(nil)
+			rdata.ReadFrom(tdata)
+			return rdata.Bytes()
+		}
+		return data
+	})
+}
+
+// Whether the values of the type cannot be encoded as is, the byte slices being already encoded as MessagePack bin.
+func needsEncoding(t reflect.Type) bool {
+	return t == numberType || t.Implements(readerType)
+}
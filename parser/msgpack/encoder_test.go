@@ -0,0 +1,23 @@
+package msgpack
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zishang520/socket.io/parser"
+)
+
+type link struct {
+	Next *link `json:"next"`
+}
+
+func TestEncodeCycle(t *testing.T) {
+	l := &link{}
+	l.Next = l
+
+	_, err := NewEncoder().Encode(&parser.Packet{Type: parser.EVENT, Nsp: "/", Data: []any{"ev", l}})
+	var encodeErr *parser.EncodeError
+	if !errors.As(err, &encodeErr) || !errors.Is(err, parser.ErrCycle) {
+		t.Fatalf("expected an *EncodeError wrapping ErrCycle, got %v", err)
+	}
+}
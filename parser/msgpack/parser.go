@@ -0,0 +1,47 @@
+// Package msgpack implements a socket.io parser based on MessagePack, compatible with the
+// socket.io-msgpack-parser package (and the client-dist/socket.io.msgpack.min.js bundle).
+//
+// Every packet is encoded as a single binary frame, so binary payloads are carried natively, without any
+// placeholder nor attachment.
+package msgpack
+
+import (
+	"github.com/zishang520/engine.io/log"
+	"github.com/zishang520/socket.io/parser"
+)
+
+var msgpack_log = log.NewLog("socket.io-msgpack-parser")
+
+// Packet types, as transmitted on the wire.
+const (
+	CONNECT uint64 = iota
+	DISCONNECT
+	EVENT
+	ACK
+	CONNECT_ERROR
+)
+
+type msgpackParser struct {
+	opts *parser.ParserOptions
+}
+
+func (p *msgpackParser) Encoder() parser.Encoder {
+	return NewEncoderWithOptions(p.opts)
+}
+
+func (p *msgpackParser) Decoder() parser.Decoder {
+	return NewDecoderWithOptions(p.opts)
+}
+
+func NewParser() parser.Parser {
+	return NewParserWithOptions(nil)
+}
+
+// Creates a parser whose decoders enforce the depth and arguments limits of the options, the packets having no
+// attachments with MessagePack. The type codecs of the options are used, but not its JSON implementation.
+func NewParserWithOptions(opts *parser.ParserOptions) parser.Parser {
+	if opts == nil {
+		opts = parser.DefaultParserOptions()
+	}
+	return &msgpackParser{opts: opts}
+}
@@ -0,0 +1,53 @@
+package msgpack_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/socket.io/client"
+	"github.com/zishang520/socket.io/parser/msgpack"
+	"github.com/zishang520/socket.io/socket"
+)
+
+func TestParser(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverOpts := socket.DefaultServerOptions()
+	serverOpts.SetParser(msgpack.NewParser())
+	io := socket.NewServer(listener, serverOpts)
+	defer io.Close(nil)
+	io.On("connection", func(args ...any) {
+		s := args[0].(*socket.Socket)
+		s.On("echo", func(args ...any) {
+			args[len(args)-1].(func(...any))(args[:len(args)-1]...)
+		})
+	})
+
+	opts := client.DefaultOptions()
+	opts.SetForceNew(true)
+	opts.SetParser(msgpack.NewParser())
+	c, err := client.Io("http://"+listener.Addr().String(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+
+	acks := make(chan []any, 1)
+	c.Emit("echo", map[string]any{"n": 1, "b": []byte{9, 8}}, func(args ...any) {
+		acks <- args
+	})
+	select {
+	case args := <-acks:
+		data := args[0].(map[string]any)
+		if data["n"] != int64(1) || !bytes.Equal(data["b"].(*types.BytesBuffer).Bytes(), []byte{9, 8}) {
+			t.Errorf("unexpected echo %v", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout reached")
+	}
+}
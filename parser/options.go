@@ -0,0 +1,327 @@
+package parser
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// The errors wrapped by a *LimitError, to be checked with errors.Is().
+var (
+	ErrTooManyAttachments    = errors.New("too many attachments")
+	ErrAttachmentsTooLarge   = errors.New("attachments too large")
+	ErrMaxDepthExceeded      = errors.New("payload nested too deeply")
+	ErrTooManyArguments      = errors.New("too many arguments")
+	ErrReconstructionTimeout = errors.New("attachments not received in time")
+)
+
+// The error returned by a decoder when a packet exceeds one of the limits of its ParserOptions. The packet is
+// discarded, along with the attachments already received.
+type LimitError struct {
+	// One of the ErrTooManyAttachments, ErrAttachmentsTooLarge, ErrMaxDepthExceeded, ErrTooManyArguments or
+	// ErrReconstructionTimeout errors
+	Err error
+	// The limit which has been exceeded
+	Limit int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v (limit: %d)", e.Err, e.Limit)
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// How the numbers of a JSON payload are decoded.
+type NumberMode int
+
+const (
+	// As float64, like encoding/json does, the integers above 2^53 losing their precision.
+	NUMBER_MODE_FLOAT64 NumberMode = iota
+	// As json.Number, which keeps the literal sent by the peer.
+	NUMBER_MODE_JSON_NUMBER
+	// As int64 for the integers (including the ones written like 1.0 or 1e3), uint64 for the positive integers above
+	// math.MaxInt64, float64 otherwise.
+	NUMBER_MODE_INT64
+)
+
+// The limits of a decoder, and how the payloads are encoded and decoded. A limit set to zero means no limit, while an
+// unset limit takes its default value.
+type ParserOptions struct {
+	// the maximum number of binary attachments of a packet, 256 by default
+	maxAttachments *uint64
+
+	// the maximum number of bytes of the attachments of a packet, no limit by default
+	maxAttachmentBytes *int64
+
+	// the maximum nesting depth of the payload of a packet, 64 by default
+	maxDepth *int
+
+	// the maximum number of arguments of an event or an acknowledgement, excluding the event name, no limit by default
+	maxArguments *int
+
+	// the delay within which the attachments of a packet must be received, 30 seconds by default
+	reconstructionTimeout *time.Duration
+
+	// how the numbers of the payload are decoded, only used by the JSON decoder since MessagePack has integer types
+	numberMode *NumberMode
+
+	// the JSON implementation, encoding/json when nil, which must produce json.Number itself for
+	// NUMBER_MODE_JSON_NUMBER
+	json JSON
+
+	// the codecs of the types which are converted on encode and revived on decode
+	typeCodecs []*TypeCodec
+}
+
+func DefaultParserOptions() *ParserOptions {
+	p := &ParserOptions{}
+	return p
+}
+
+func (p *ParserOptions) SetMaxAttachments(maxAttachments uint64) {
+	p.maxAttachments = &maxAttachments
+}
+func (p *ParserOptions) GetRawMaxAttachments() *uint64 {
+	return p.maxAttachments
+}
+func (p *ParserOptions) MaxAttachments() uint64 {
+	if p.maxAttachments == nil {
+		return 256
+	}
+
+	return *p.maxAttachments
+}
+
+func (p *ParserOptions) SetMaxAttachmentBytes(maxAttachmentBytes int64) {
+	p.maxAttachmentBytes = &maxAttachmentBytes
+}
+func (p *ParserOptions) GetRawMaxAttachmentBytes() *int64 {
+	return p.maxAttachmentBytes
+}
+func (p *ParserOptions) MaxAttachmentBytes() int64 {
+	if p.maxAttachmentBytes == nil {
+		return 0
+	}
+
+	return *p.maxAttachmentBytes
+}
+
+func (p *ParserOptions) SetMaxDepth(maxDepth int) {
+	p.maxDepth = &maxDepth
+}
+func (p *ParserOptions) GetRawMaxDepth() *int {
+	return p.maxDepth
+}
+func (p *ParserOptions) MaxDepth() int {
+	if p.maxDepth == nil {
+		return 64
+	}
+
+	return *p.maxDepth
+}
+
+func (p *ParserOptions) SetMaxArguments(maxArguments int) {
+	p.maxArguments = &maxArguments
+}
+func (p *ParserOptions) GetRawMaxArguments() *int {
+	return p.maxArguments
+}
+func (p *ParserOptions) MaxArguments() int {
+	if p.maxArguments == nil {
+		return 0
+	}
+
+	return *p.maxArguments
+}
+
+func (p *ParserOptions) SetReconstructionTimeout(reconstructionTimeout time.Duration) {
+	p.reconstructionTimeout = &reconstructionTimeout
+}
+func (p *ParserOptions) GetRawReconstructionTimeout() *time.Duration {
+	return p.reconstructionTimeout
+}
+func (p *ParserOptions) ReconstructionTimeout() time.Duration {
+	if p.reconstructionTimeout == nil {
+		return time.Duration(30000 * time.Millisecond)
+	}
+
+	return *p.reconstructionTimeout
+}
+
+func (p *ParserOptions) SetNumberMode(numberMode NumberMode) {
+	p.numberMode = &numberMode
+}
+func (p *ParserOptions) GetRawNumberMode() *NumberMode {
+	return p.numberMode
+}
+func (p *ParserOptions) NumberMode() NumberMode {
+	if p.numberMode == nil {
+		return NUMBER_MODE_FLOAT64
+	}
+
+	return *p.numberMode
+}
+
+func (p *ParserOptions) SetJSON(json JSON) {
+	p.json = json
+}
+func (p *ParserOptions) GetRawJSON() JSON {
+	return p.json
+}
+func (p *ParserOptions) JSON() JSON {
+	if p.json == nil {
+		return stdJSON{useNumber: p.NumberMode() != NUMBER_MODE_FLOAT64}
+	}
+
+	return p.json
+}
+
+func (p *ParserOptions) SetTypeCodecs(typeCodecs []*TypeCodec) {
+	p.typeCodecs = typeCodecs
+}
+func (p *ParserOptions) GetRawTypeCodecs() []*TypeCodec {
+	return p.typeCodecs
+}
+func (p *ParserOptions) TypeCodecs() []*TypeCodec {
+	return p.typeCodecs
+}
+
+// Converts a json.Number into an int64, a uint64 or a float64, the first type which can hold it. An integer written
+// with a fraction or an exponent (like 1.0 or 1e3) is converted as well.
+func ConvertNumber(n json.Number) any {
+	if v, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return v
+	}
+	if v, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return v
+	}
+	v, _ := strconv.ParseFloat(string(n), 64)
+	return convertFloat(v)
+}
+
+// Converts an integral float64 into an int64 or a uint64, the first type which can hold it.
+func convertFloat(v float64) any {
+	if v == math.Trunc(v) {
+		// float64(math.MaxInt64) and float64(math.MaxUint64) are rounded up to 2^63 and 2^64
+		if v >= math.MinInt64 && v < math.MaxInt64 {
+			return int64(v)
+		}
+		if v > 0 && v < math.MaxUint64 {
+			return uint64(v)
+		}
+	}
+	return v
+}
+
+// Replaces every json.Number of the data with ConvertNumber(), and every integral float64 (decoded by a JSON
+// implementation which does not produce json.Number) with an int64 or a uint64.
+func ConvertNumbers(data any) any {
+	switch tdata := data.(type) {
+	case json.Number:
+		return ConvertNumber(tdata)
+	case float64:
+		return convertFloat(tdata)
+	case []any:
+		for i, v := range tdata {
+			tdata[i] = ConvertNumbers(v)
+		}
+	case map[string]any:
+		for k, v := range tdata {
+			tdata[k] = ConvertNumbers(v)
+		}
+	}
+	return data
+}
+
+// Checks the number of arguments of an event or an acknowledgement.
+func CheckArguments(opts *ParserOptions, t PacketType, payload any) error {
+	args, ok := payload.([]any)
+	if !ok {
+		return nil
+	}
+	count := len(args)
+	if t == EVENT || t == BINARY_EVENT {
+		// the event name
+		count--
+	}
+	if max := opts.MaxArguments(); max > 0 && count > max {
+		return &LimitError{Err: ErrTooManyArguments, Limit: int64(max)}
+	}
+	return nil
+}
+
+// Checks the nesting depth of decoded data.
+func CheckDepth(opts *ParserOptions, data any) error {
+	max := opts.MaxDepth()
+	if max <= 0 {
+		return nil
+	}
+	if depth(data, max+1) > max {
+		return &LimitError{Err: ErrMaxDepthExceeded, Limit: int64(max)}
+	}
+	return nil
+}
+
+// Returns the nesting depth of the data, stopping at `limit`.
+func depth(data any, limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	max := 0
+	switch tdata := data.(type) {
+	case []any:
+		for _, v := range tdata {
+			if d := depth(v, limit-1); d > max {
+				max = d
+			}
+		}
+	case map[string]any:
+		for _, v := range tdata {
+			if d := depth(v, limit-1); d > max {
+				max = d
+			}
+		}
+	default:
+		return 0
+	}
+	return max + 1
+}
+
+// Checks the nesting depth of a JSON document without decoding it, so that a deeply nested payload is rejected before
+// any allocation.
+func checkJSONDepth(opts *ParserOptions, data []byte) error {
+	max := opts.MaxDepth()
+	if max <= 0 {
+		return nil
+	}
+	depth, inString, escaped := 0, false, false
+	for _, c := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '[', '{':
+			if depth++; depth > max {
+				return &LimitError{Err: ErrMaxDepthExceeded, Limit: int64(max)}
+			}
+		case ']', '}':
+			depth--
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestConvertNumber(t *testing.T) {
+	for n, expected := range map[json.Number]any{
+		"1":                   int64(1),
+		"-1":                  int64(-1),
+		"9223372036854775807": int64(9223372036854775807),
+		"9223372036854775808": uint64(9223372036854775808),
+		"1.0":                 int64(1),
+		"1e3":                 int64(1000),
+		"-2.5e1":              int64(-25),
+		"1e19":                uint64(1e19),
+		"1e20":                float64(1e20),
+		"-1e19":               float64(-1e19),
+		"1.5":                 float64(1.5),
+		"-0.0":                int64(0),
+	} {
+		if v := ConvertNumber(n); v != expected {
+			t.Errorf("%s: expected %T(%v), got %T(%v)", n, expected, expected, v, v)
+		}
+	}
+}
+
+// encoding/json without UseNumber, like a JSON implementation which is not configured to produce json.Number.
+type floatJSON struct{}
+
+func (floatJSON) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (floatJSON) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func TestNumberModeCustomJSON(t *testing.T) {
+	for _, test := range []struct {
+		json     JSON
+		expected []any
+	}{
+		{floatJSON{}, []any{"event", int64(1), float64(1.5), int64(9007199254740992)}},
+		{stdJSON{useNumber: true}, []any{"event", int64(1), float64(1.5), int64(9007199254740993)}},
+	} {
+		opts := DefaultParserOptions()
+		opts.SetNumberMode(NUMBER_MODE_INT64)
+		opts.SetJSON(test.json)
+		decoder := NewDecoderWithOptions(opts)
+		var data any
+		decoder.On("decoded", func(args ...any) {
+			data = args[0].(*Packet).Data
+		})
+		if err := decoder.Add(`2["event",1,1.5,9007199254740993]`); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(data, test.expected) {
+			t.Errorf("%T: expected %#v, got %#v", test.json, test.expected, data)
+		}
+	}
+}
@@ -7,7 +7,8 @@ import (
 
 // A socket.io Encoder instance
 type Encoder interface {
-	Encode(*Packet) []types.BufferInterface
+	// Returns a *EncodeError when the data of the packet cannot be encoded
+	Encode(*Packet) ([]types.BufferInterface, error)
 }
 
 // A socket.io Decoder instance
@@ -27,16 +28,26 @@ type Parser interface {
 }
 
 type parser struct {
+	opts *ParserOptions
 }
 
 func (p *parser) Encoder() Encoder {
-	return NewEncoder()
+	return NewEncoderWithOptions(p.opts)
 }
 
 func (p *parser) Decoder() Decoder {
-	return NewDecoder()
+	return NewDecoderWithOptions(p.opts)
 }
 
 func NewParser() Parser {
-	return &parser{}
+	return NewParserWithOptions(nil)
+}
+
+// Creates a parser whose decoders enforce the limits of the options, its encoders and decoders using the JSON
+// implementation and the type codecs of the options.
+func NewParserWithOptions(opts *ParserOptions) Parser {
+	if opts == nil {
+		opts = DefaultParserOptions()
+	}
+	return &parser{opts: opts}
 }
@@ -0,0 +1,479 @@
+package parser
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+	// the fields of the structs, as encoded by json.Marshal
+	structFields sync.Map
+)
+
+// The error returned when the data holds a cycle, which json.Marshal cannot encode either.
+var ErrCycle = errors.New("encountered a cycle")
+
+// Like encoding/json, the cycles are only looked for once the data is nested this deeply, which is cheaper than
+// tracking every pointer.
+const startDetectingCyclesAfter = 1000
+
+// Returns a copy of data in which each value whose type satisfies `match` is replaced with replace(value), walking
+// through the pointers, structs, slices, arrays and maps like json.Marshal does. The values which do not hold any
+// matching value are kept as is, the others are converted into []any and map[string]any, the structs being keyed by
+// their JSON field names, so that they can hold the replacements. ErrCycle is returned if the data holds a cycle.
+func ReplaceValues(data any, match func(reflect.Type) bool, replace func(any) any) (any, error) {
+	w := &walker{match: match, types: map[reflect.Type]bool{}}
+	return w.replace(reflect.ValueOf(data), replace)
+}
+
+// Returns ErrCycle if the data holds a cycle, for the encoders which do not detect them, unlike json.Marshal.
+func CheckCycles(data any) error {
+	w := &walker{match: func(reflect.Type) bool { return false }, types: map[reflect.Type]bool{}, all: true}
+	_, err := w.has(reflect.ValueOf(data))
+	return err
+}
+
+// Walks through the data, remembering which types may hold a matching value.
+type walker struct {
+	match func(reflect.Type) bool
+	types map[reflect.Type]bool
+	// whether every pointer, struct, slice, array and map is walked through, to look for cycles
+	all bool
+
+	// the number of pointers, maps and slices of the current path, and the ones it holds once it is deep enough
+	depth int
+	seen  map[visit]struct{}
+}
+
+// A pointer, a map or a slice of the current path.
+type visit struct {
+	typ reflect.Type
+	ptr uintptr
+	len int
+}
+
+// Enters a pointer, a map or a slice, returning ErrCycle if it is already on the current path.
+func (w *walker) enter(v reflect.Value) error {
+	w.depth++
+	if w.depth <= startDetectingCyclesAfter {
+		return nil
+	}
+	if w.seen == nil {
+		w.seen = map[visit]struct{}{}
+	}
+	key := visitOf(v)
+	if _, ok := w.seen[key]; ok {
+		w.depth--
+		return ErrCycle
+	}
+	w.seen[key] = struct{}{}
+	return nil
+}
+
+// Leaves a value entered with enter().
+func (w *walker) leave(v reflect.Value) {
+	if w.depth > startDetectingCyclesAfter {
+		delete(w.seen, visitOf(v))
+	}
+	w.depth--
+}
+
+func visitOf(v reflect.Value) visit {
+	key := visit{typ: v.Type(), ptr: v.Pointer()}
+	if v.Kind() == reflect.Slice {
+		// a slice of a slice shares its pointer
+		key.len = v.Len()
+	}
+	return key
+}
+
+// Whether the json.Marshal encoding of the type is up to the type itself.
+func isOpaqueType(t reflect.Type) bool {
+	return t.Implements(marshalerType) || t.Implements(textMarshalerType)
+}
+
+// Whether the values of the type may hold a matching value.
+func (w *walker) mayHold(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Ptr, reflect.Struct, reflect.Slice, reflect.Array, reflect.Map:
+		if w.all {
+			return true
+		}
+	}
+	if w.match(t) {
+		return true
+	}
+	if may, ok := w.types[t]; ok {
+		return may
+	}
+	// recursive types
+	w.types[t] = false
+	may := false
+	if t.Kind() == reflect.Ptr {
+		// the methods of the pointers include the ones of their element
+		may = w.mayHold(t.Elem())
+	} else if !isOpaqueType(t) {
+		switch t.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Map:
+			may = w.mayHold(t.Elem())
+		case reflect.Struct:
+			for _, f := range cachedFields(t) {
+				if w.mayHold(f.typ) {
+					may = true
+					break
+				}
+			}
+		}
+	}
+	w.types[t] = may
+	return may
+}
+
+// Whether the value holds a matching value.
+func (w *walker) has(v reflect.Value) (bool, error) {
+	if !v.IsValid() || !v.CanInterface() {
+		return false, nil
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return false, nil
+		}
+	}
+	if !w.mayHold(v.Type()) {
+		return false, nil
+	}
+	if v.Kind() != reflect.Interface && w.match(v.Type()) {
+		return true, nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if err := w.enter(v); err != nil {
+			return false, err
+		}
+		defer w.leave(v)
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return w.has(v.Elem())
+	case reflect.Struct:
+		for _, f := range cachedFields(v.Type()) {
+			if fv, ok := fieldByIndex(v, f.index); ok && !(f.omitEmpty && isEmptyValue(fv)) {
+				if has, err := w.has(fv); has || err != nil {
+					return has, err
+				}
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if has, err := w.has(v.Index(i)); has || err != nil {
+				return has, err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if has, err := w.has(iter.Value()); has || err != nil {
+				return has, err
+			}
+		}
+	}
+	return false, nil
+}
+
+func (w *walker) replace(v reflect.Value, replace func(any) any) (any, error) {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil, nil
+	}
+	data := v.Interface()
+	if has, err := w.has(v); !has || err != nil {
+		return data, err
+	}
+	if v.Kind() != reflect.Interface && w.match(v.Type()) {
+		return replace(data), nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if err := w.enter(v); err != nil {
+			return nil, err
+		}
+		defer w.leave(v)
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return w.replace(v.Elem(), replace)
+	case reflect.Struct:
+		newData := map[string]any{}
+		for _, f := range cachedFields(v.Type()) {
+			fv, ok := fieldByIndex(v, f.index)
+			if !ok || (f.omitEmpty && isEmptyValue(fv)) {
+				continue
+			}
+			if f.quoted {
+				newData[f.name] = quoteValue(fv)
+				continue
+			}
+			value, err := w.replace(fv, replace)
+			if err != nil {
+				return nil, err
+			}
+			newData[f.name] = value
+		}
+		return newData, nil
+	case reflect.Slice, reflect.Array:
+		newData := make([]any, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			value, err := w.replace(v.Index(i), replace)
+			if err != nil {
+				return nil, err
+			}
+			newData = append(newData, value)
+		}
+		return newData, nil
+	case reflect.Map:
+		newData := map[string]any{}
+		iter := v.MapRange()
+		for iter.Next() {
+			value, err := w.replace(iter.Value(), replace)
+			if err != nil {
+				return nil, err
+			}
+			newData[mapKey(iter.Key())] = value
+		}
+		return newData, nil
+	}
+	return data, nil
+}
+
+// A struct field, as encoded by json.Marshal.
+type field struct {
+	name      string
+	tagged    bool
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+	quoted    bool
+}
+
+func cachedFields(t reflect.Type) []field {
+	if fields, ok := structFields.Load(t); ok {
+		return fields.([]field)
+	}
+	fields, _ := structFields.LoadOrStore(t, typeFields(t))
+	return fields.([]field)
+}
+
+// Returns the fields of a struct which are encoded by json.Marshal, following its rules for the embedded structs: the
+// fields of an embedded struct without a JSON name are promoted, the shallowest field winning over the deeper ones
+// with the same name, then the tagged one, the fields with the same name being dropped otherwise.
+func typeFields(t reflect.Type) []field {
+	var fields []field
+	current, next := []field{}, []field{{typ: t}}
+	count, nextCount := map[reflect.Type]int{}, map[reflect.Type]int{}
+	visited := map[reflect.Type]bool{}
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		count, nextCount = nextCount, map[reflect.Type]int{}
+
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+
+			for i := 0; i < f.typ.NumField(); i++ {
+				sf := f.typ.Field(i)
+				if sf.Anonymous {
+					ft := sf.Type
+					if ft.Kind() == reflect.Ptr {
+						ft = ft.Elem()
+					}
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct {
+					quoted := false
+					if hasOption(opts, "string") {
+						switch ft.Kind() {
+						case reflect.Bool,
+							reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+							reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+							reflect.Float32, reflect.Float64,
+							reflect.String:
+							quoted = true
+						}
+					}
+					tagged := name != ""
+					if name == "" {
+						name = sf.Name
+					}
+					fields = append(fields, field{
+						name:      name,
+						tagged:    tagged,
+						index:     index,
+						typ:       sf.Type,
+						omitEmpty: hasOption(opts, "omitempty"),
+						quoted:    quoted,
+					})
+					if count[f.typ] > 1 {
+						// the struct is embedded several times at the same depth, its fields annihilate each other
+						fields = append(fields, fields[len(fields)-1])
+					}
+					continue
+				}
+
+				nextCount[ft]++
+				if nextCount[ft] == 1 {
+					next = append(next, field{name: ft.Name(), index: index, typ: ft})
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		if fields[i].name != fields[j].name {
+			return fields[i].name < fields[j].name
+		}
+		if len(fields[i].index) != len(fields[j].index) {
+			return len(fields[i].index) < len(fields[j].index)
+		}
+		return fields[i].tagged && !fields[j].tagged
+	})
+
+	out := fields[:0]
+	for advance, i := 0, 0; i < len(fields); i += advance {
+		name := fields[i].name
+		for advance = 1; i+advance < len(fields); advance++ {
+			if fields[i+advance].name != name {
+				break
+			}
+		}
+		if advance == 1 {
+			out = append(out, fields[i])
+			continue
+		}
+		if dominant := fields[i : i+advance]; len(dominant[0].index) != len(dominant[1].index) || dominant[0].tagged != dominant[1].tagged {
+			out = append(out, dominant[0])
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		x, y := out[i].index, out[j].index
+		for k, xk := range x {
+			if k >= len(y) {
+				return false
+			}
+			if xk != y[k] {
+				return xk < y[k]
+			}
+		}
+		return len(x) < len(y)
+	})
+	return out
+}
+
+func hasOption(opts string, option string) bool {
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the field of a struct, false when it is promoted from a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// Returns the value of a field with the "string" option, encoded as JSON inside a string.
+func quoteValue(v reflect.Value) any {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	b, _ := json.Marshal(v.Interface())
+	return string(b)
+}
+
+// Returns the key of a map as encoded by json.Marshal.
+func mapKey(k reflect.Value) string {
+	if k.Kind() == reflect.String {
+		return k.String()
+	}
+	if tm, ok := k.Interface().(encoding.TextMarshaler); ok {
+		if k.Kind() == reflect.Ptr && k.IsNil() {
+			return ""
+		}
+		b, _ := tm.MarshalText()
+		return string(b)
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10)
+	}
+	return fmt.Sprint(k.Interface())
+}
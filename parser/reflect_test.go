@@ -0,0 +1,84 @@
+package parser
+
+import (
+	"errors"
+	"testing"
+)
+
+type node struct {
+	Data []byte `json:"data,omitempty"`
+	Next any    `json:"next"`
+}
+
+type link struct {
+	Next *link `json:"next"`
+}
+
+func TestHasBinaryCycle(t *testing.T) {
+	n := &node{}
+	n.Next = n
+
+	if _, err := HasBinary([]any{"ev", n}); !errors.Is(err, ErrCycle) {
+		t.Fatalf("expected ErrCycle, got %v", err)
+	}
+
+	l := &link{}
+	l.Next = l
+	if _, err := HasBinary([]any{"ev", l}); err != nil {
+		t.Fatalf("a type which cannot hold a binary value is not walked through, got %v", err)
+	}
+
+	m := map[string]any{}
+	m["self"] = m
+	if _, err := HasBinary([]any{"ev", m}); !errors.Is(err, ErrCycle) {
+		t.Fatalf("expected ErrCycle for a map, got %v", err)
+	}
+
+	s := []any{nil}
+	s[0] = s
+	if _, err := HasBinary([]any{"ev", s}); !errors.Is(err, ErrCycle) {
+		t.Fatalf("expected ErrCycle for a slice, got %v", err)
+	}
+}
+
+func TestHasBinaryDeepAcyclic(t *testing.T) {
+	var data any = []byte{1}
+	for i := 0; i < 2*startDetectingCyclesAfter; i++ {
+		data = &node{Next: data}
+	}
+	has, err := HasBinary([]any{"ev", data})
+	if err != nil || !has {
+		t.Fatalf("expected a binary value, got %v, %v", has, err)
+	}
+}
+
+func TestEncodeCycle(t *testing.T) {
+	n := &node{}
+	n.Next = n
+	withBinary := &node{Data: []byte{1}}
+	withBinary.Next = withBinary
+
+	for _, data := range []any{
+		[]any{"ev", n},
+		[]any{"ev", []byte{1}, n},
+		[]any{"ev", withBinary},
+	} {
+		packet := &Packet{Type: EVENT, Nsp: "/", Data: data}
+		_, err := NewEncoder().Encode(packet)
+		var encodeErr *EncodeError
+		if !errors.As(err, &encodeErr) || !errors.Is(err, ErrCycle) {
+			t.Fatalf("expected an *EncodeError wrapping ErrCycle, got %v", err)
+		}
+	}
+}
+
+func TestCheckCycles(t *testing.T) {
+	l := &link{}
+	if err := CheckCycles([]any{"ev", l, map[string][]int{"a": {1}}}); err != nil {
+		t.Fatalf("expected no cycle, got %v", err)
+	}
+	l.Next = l
+	if err := CheckCycles([]any{"ev", l}); !errors.Is(err, ErrCycle) {
+		t.Fatalf("expected ErrCycle, got %v", err)
+	}
+}
@@ -2,7 +2,7 @@ package socket
 
 import (
 	"sync"
-	"sync/atomic"
+	"time"
 
 	"github.com/zishang520/engine.io/events"
 	"github.com/zishang520/engine.io/types"
@@ -18,7 +18,7 @@ type adapter struct {
 	sids    *sync.Map
 	encoder parser.Encoder
 
-	_broadcast func(*parser.Packet, *BroadcastOptions)
+	_broadcast func(*parser.Packet, *BroadcastOptions) error
 }
 
 func (*adapter) New(nsp NamespaceInterface) Adapter {
@@ -33,6 +33,18 @@ func (*adapter) New(nsp NamespaceInterface) Adapter {
 	return a
 }
 
+// Creates the default in-memory adapter of a namespace, which the adapters synchronizing several Socket.IO servers
+// can embed to manage the local sockets.
+//
+// With the connection state recovery, the adapter stores the sessions and the packets broadcast through it, so that
+// a client reconnecting to the same server receives the packets it has missed.
+func NewAdapter(nsp NamespaceInterface) Adapter {
+	if nsp.Server().opts.ConnectionStateRecovery() != nil {
+		return (&sessionAwareAdapter{}).New(nsp)
+	}
+	return (&adapter{}).New(nsp)
+}
+
 func (a *adapter) Rooms() *sync.Map {
 	return a.rooms
 }
@@ -104,7 +116,7 @@ func (a *adapter) DelAll(id SocketId) {
 	}
 }
 
-func (a *adapter) SetBroadcast(broadcast func(*parser.Packet, *BroadcastOptions)) {
+func (a *adapter) SetBroadcast(broadcast func(*parser.Packet, *BroadcastOptions) error) {
 	a._broadcast = broadcast
 }
 
@@ -114,8 +126,8 @@ func (a *adapter) SetBroadcast(broadcast func(*parser.Packet, *BroadcastOptions)
 //   - `Flags` {*BroadcastFlags} flags for this packet
 //   - `Except` {*types.Set[Room]} sids that should be excluded
 //   - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
-func (a *adapter) Broadcast(packet *parser.Packet, opts *BroadcastOptions) {
-	a._broadcast(packet, opts)
+func (a *adapter) Broadcast(packet *parser.Packet, opts *BroadcastOptions) error {
+	return a._broadcast(packet, opts)
 }
 
 // Broadcasts a packet.
@@ -124,7 +136,7 @@ func (a *adapter) Broadcast(packet *parser.Packet, opts *BroadcastOptions) {
 //   - `Flags` {*BroadcastFlags} flags for this packet
 //   - `Except` {*types.Set[Room]} sids that should be excluded
 //   - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
-func (a *adapter) broadcast(packet *parser.Packet, opts *BroadcastOptions) {
+func (a *adapter) broadcast(packet *parser.Packet, opts *BroadcastOptions) error {
 	flags := &BroadcastFlags{}
 	if opts != nil && opts.Flags != nil {
 		flags = opts.Flags
@@ -136,13 +148,17 @@ func (a *adapter) broadcast(packet *parser.Packet, opts *BroadcastOptions) {
 	packetOpts.Compress = flags.Compress
 
 	packet.Nsp = a.nsp.Name()
-	encodedPackets := a.encoder.Encode(packet)
+	encodedPackets, err := a.encoder.Encode(packet)
+	if err != nil {
+		return err
+	}
 	a.apply(opts, func(socket *Socket) {
 		if notifyOutgoingListeners := socket.NotifyOutgoingListeners(); notifyOutgoingListeners != nil {
 			notifyOutgoingListeners(packet)
 		}
 		socket.Client().WriteToEngine(encodedPackets, packetOpts)
 	})
+	return nil
 }
 
 // Broadcasts a packet and expects multiple acknowledgements.
@@ -151,7 +167,7 @@ func (a *adapter) broadcast(packet *parser.Packet, opts *BroadcastOptions) {
 //   - `Flags` {*BroadcastFlags} flags for this packet
 //   - `Except` {*types.Set[Room]} sids that should be excluded
 //   - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
-func (a *adapter) BroadcastWithAck(packet *parser.Packet, opts *BroadcastOptions, clientCountCallback func(uint64), ack func(...any)) {
+func (a *adapter) BroadcastWithAck(packet *parser.Packet, opts *BroadcastOptions, clientsCallback func(*BroadcastClients), ack func(*BroadcastAck)) error {
 	flags := &BroadcastFlags{}
 	if opts != nil && opts.Flags != nil {
 		flags = opts.Flags
@@ -166,19 +182,36 @@ func (a *adapter) BroadcastWithAck(packet *parser.Packet, opts *BroadcastOptions
 	// we can use the same id for each packet, since the _ids counter is common (no duplicate)
 	id := a.nsp.Ids()
 	packet.Id = &id
-	encodedPackets := a.encoder.Encode(packet)
-	clientCount := uint64(0)
+	encodedPackets, err := a.encoder.Encode(packet)
+	if err != nil {
+		return err
+	}
+	var sids []SocketId
+	var mu sync.Mutex
 	a.apply(opts, func(socket *Socket) {
-		// track the total number of acknowledgements that are expected
-		atomic.AddUint64(&clientCount, 1)
+		// track the acknowledgements that are expected
+		mu.Lock()
+		sids = append(sids, socket.Id())
+		mu.Unlock()
 		// call the ack callback for each client response
-		socket.Acks().Store(*packet.Id, ack)
+		// the acknowledgements of a broadcast are collected, without running any listener of the socket, so they do not
+		// wait for the packets received before them to be done
+		sid := socket.Id()
+		socket.storeAck(*packet.Id, func(args ...any) {
+			ack(&BroadcastAck{Sid: sid, Args: args})
+		}, func(err error) {
+			ack(&BroadcastAck{Sid: sid, Err: err})
+		}, true)
 		if notifyOutgoingListeners := socket.NotifyOutgoingListeners(); notifyOutgoingListeners != nil {
 			notifyOutgoingListeners(packet)
 		}
 		socket.Client().WriteToEngine(encodedPackets, packetOpts)
 	})
-	clientCountCallback(atomic.LoadUint64(&clientCount))
+	mu.Lock()
+	clients := &BroadcastClients{Count: uint64(len(sids)), Sids: sids}
+	mu.Unlock()
+	clientsCallback(clients)
+	return nil
 }
 
 // Gets a list of sockets by sid.
@@ -274,6 +307,202 @@ func (a *adapter) computeExceptSids(exceptRooms *types.Set[Room]) *types.Set[Soc
 
 // Send a packet to the other Socket.IO servers in the cluster
 func (a *adapter) ServerSideEmit(ev string, args ...any) error {
+	if len(args) > 0 {
+		if ack, ok := args[len(args)-1].(func(error, []any)); ok {
+			// there is no other server to wait for
+			ack(nil, []any{})
+			return nil
+		}
+	}
 	utils.Log().Warning(`this adapter does not support the ServerSideEmit() functionality`)
 	return nil
 }
+
+// Save the client session in order to restore it upon reconnection.
+func (a *adapter) PersistSession(session *SessionToPersist) {
+}
+
+// Restore the session and find the packets that were missed by the client.
+func (a *adapter) RestoreSession(pid PrivateSessionId, offset string) (*Session, error) {
+	return nil, nil
+}
+
+type sessionWithTimestamp struct {
+	*SessionToPersist
+
+	disconnectedAt int64
+}
+
+type persistedPacket struct {
+	id        string
+	emittedAt int64
+	data      []any
+	opts      *BroadcastOptions
+}
+
+type sessionAwareAdapter struct {
+	*adapter
+
+	maxDisconnectionDuration int64
+	sessions                 *sync.Map
+	packets                  []*persistedPacket
+	timer                    *utils.Timer
+
+	mu_packets sync.RWMutex
+}
+
+func (*sessionAwareAdapter) New(nsp NamespaceInterface) Adapter {
+	s := &sessionAwareAdapter{}
+	s.adapter = (&adapter{}).New(nsp).(*adapter)
+	s.adapter._broadcast = s.broadcast
+	s.maxDisconnectionDuration = int64(nsp.Server().opts.ConnectionStateRecovery().MaxDisconnectionDuration() / time.Millisecond)
+	s.sessions = &sync.Map{}
+	s.packets = []*persistedPacket{}
+	s.timer = utils.SetInterval(func() {
+		threshold := time.Now().UnixMilli() - s.maxDisconnectionDuration
+		s.sessions.Range(func(sessionId any, session any) bool {
+			if session.(*sessionWithTimestamp).disconnectedAt < threshold {
+				s.sessions.Delete(sessionId)
+			}
+			return true
+		})
+		s.mu_packets.Lock()
+		defer s.mu_packets.Unlock()
+		for i := len(s.packets) - 1; i >= 0; i-- {
+			if s.packets[i].emittedAt < threshold {
+				s.packets = append([]*persistedPacket{}, s.packets[i+1:]...)
+				break
+			}
+		}
+	}, 60*1000*time.Millisecond)
+
+	return s
+}
+
+func (s *sessionAwareAdapter) Close() {
+	utils.ClearInterval(s.timer)
+	s.adapter.Close()
+}
+
+// Save the client session in order to restore it upon reconnection.
+func (s *sessionAwareAdapter) PersistSession(session *SessionToPersist) {
+	s.sessions.Store(session.Pid, &sessionWithTimestamp{
+		SessionToPersist: session,
+		disconnectedAt:   time.Now().UnixMilli(),
+	})
+}
+
+// Restore the session and find the packets that were missed by the client.
+func (s *sessionAwareAdapter) RestoreSession(pid PrivateSessionId, offset string) (*Session, error) {
+	_session, ok := s.sessions.Load(pid)
+	if !ok {
+		// the session may have expired
+		return nil, nil
+	}
+	session := _session.(*sessionWithTimestamp)
+	if session.disconnectedAt+s.maxDisconnectionDuration < time.Now().UnixMilli() {
+		// the session has expired
+		s.sessions.Delete(pid)
+		return nil, nil
+	}
+
+	s.mu_packets.RLock()
+	defer s.mu_packets.RUnlock()
+
+	index := -1
+	for i, packet := range s.packets {
+		if packet.id == offset {
+			index = i
+			break
+		}
+	}
+	if index == -1 {
+		// the offset may be too old
+		return nil, nil
+	}
+	missedPackets := [][]any{}
+	for _, packet := range s.packets[index+1:] {
+		if shouldIncludePacket(session.Rooms, packet.opts) {
+			missedPackets = append(missedPackets, packet.data)
+		}
+	}
+	// the session is consumed, it cannot be restored twice
+	s.sessions.Delete(pid)
+	return &Session{
+		SessionToPersist: session.SessionToPersist,
+		MissedPackets:    missedPackets,
+	}, nil
+}
+
+func (s *sessionAwareAdapter) broadcast(packet *parser.Packet, opts *BroadcastOptions) error {
+	persisted := s.persistPacket(packet, opts, "")
+	if err := s.adapter.broadcast(packet, opts); err != nil {
+		if persisted != nil {
+			// the packet would fail again upon reconnection
+			s.removePacket(persisted)
+		}
+		return err
+	}
+	return nil
+}
+
+// Stores an event packet, so that it can be transmitted to the sockets which reconnect. Unless it is given (by the
+// server of the cluster which has broadcast the packet), the offset of the packet is generated and appended to its
+// data. Returns nil if the packet is not stored.
+func (s *sessionAwareAdapter) persistPacket(packet *parser.Packet, opts *BroadcastOptions, offset string) *persistedPacket {
+	isEventPacket := packet.Type == parser.EVENT
+	// packets with acknowledgement are not stored because the acknowledgement function cannot be serialized and
+	// restored on another server upon reconnection
+	withoutAcknowledgement := packet.Id == nil
+	notVolatile := opts == nil || opts.Flags == nil || !opts.Flags.Volatile
+
+	data, ok := packet.Data.([]any)
+	if !ok || !isEventPacket || !withoutAcknowledgement || !notVolatile {
+		return nil
+	}
+	if offset == "" {
+		offset, _ = utils.Base64Id().GenerateId()
+		// the offset is stored at the end of the data array, so the client knows where it stands
+		data = append(data, offset)
+		packet.Data = data
+	}
+	persisted := &persistedPacket{
+		id:        offset,
+		emittedAt: time.Now().UnixMilli(),
+		data:      data,
+		opts:      opts,
+	}
+	s.mu_packets.Lock()
+	s.packets = append(s.packets, persisted)
+	s.mu_packets.Unlock()
+	return persisted
+}
+
+func (s *sessionAwareAdapter) removePacket(persisted *persistedPacket) {
+	s.mu_packets.Lock()
+	defer s.mu_packets.Unlock()
+
+	for i, p := range s.packets {
+		if p == persisted {
+			s.packets = append(s.packets[:i:i], s.packets[i+1:]...)
+			break
+		}
+	}
+}
+
+func shouldIncludePacket(sessionRooms *types.Set[Room], opts *BroadcastOptions) bool {
+	if opts == nil {
+		return true
+	}
+	included := opts.Rooms == nil || opts.Rooms.Len() == 0
+	notExcluded := true
+	for _, room := range sessionRooms.Keys() {
+		if !included && opts.Rooms.Has(room) {
+			included = true
+		}
+		if opts.Except != nil && opts.Except.Has(room) {
+			notExcluded = false
+		}
+	}
+	return included && notExcluded
+}
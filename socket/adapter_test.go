@@ -0,0 +1,19 @@
+package socket
+
+import (
+	"testing"
+)
+
+func TestNewAdapter(t *testing.T) {
+	if _, ok := NewAdapter(NewServer(nil, nil).Sockets()).(*adapter); !ok {
+		t.Fatal("expected the in-memory adapter")
+	}
+
+	opts := DefaultServerOptions()
+	opts.SetConnectionStateRecovery(DefaultConnectionStateRecovery())
+	a := NewAdapter(NewServer(nil, opts).Sockets())
+	defer a.Close()
+	if _, ok := a.(*sessionAwareAdapter); !ok {
+		t.Fatal("expected the session aware adapter with the connection state recovery")
+	}
+}
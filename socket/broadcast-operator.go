@@ -1,10 +1,11 @@
 package socket
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/zishang520/engine.io/types"
@@ -115,67 +116,257 @@ func (b *BroadcastOperator) Emit(ev string, args ...any) error {
 	ack, withAck := data[data_len-1].(func(error, []any))
 
 	if !withAck {
-		b.adapter.Broadcast(packet, &BroadcastOptions{
+		return b.adapter.Broadcast(packet, &BroadcastOptions{
 			Rooms:  b.rooms,
 			Except: b.exceptRooms,
 			Flags:  b.flags,
 		})
-
-		return nil
 	}
 
 	packet.Data = data[:data_len-1]
 
-	timedOut := false
-	responses := []any{}
-	var responsesMu sync.RWMutex
 	var timeout time.Duration
-
 	if time := b.flags.Timeout; time != nil {
 		timeout = *time
 	}
+	_, err := b.broadcastWithAck(packet, &timeout, func(err error, responses []any, _ *BroadcastResponses) {
+		ack(err, responses)
+	})
+	return err
+}
+
+// Emits an event to all connected clients and waits for their acknowledgements, until every client has answered,
+// the context is cancelled or the timeout set with Timeout() is reached.
+//
+// <pre><code>
+//
+//	responses, err := io.Timeout(5 * time.Second).EmitWithAck(ctx, "some-event")
+//	if err != nil {
+//	  // some clients did not acknowledge the event in the given delay
+//	}
+//
+// </pre></code>
+func (b *BroadcastOperator) EmitWithAck(ctx context.Context, ev string, args ...any) ([]any, error) {
+	if SOCKET_RESERVED_EVENTS.Has(ev) {
+		return nil, errors.New(fmt.Sprintf(`"%s" is a reserved event name`, ev))
+	}
 
-	timer := utils.SetTimeOut(func() {
-		timedOut = true
-		responsesMu.RLock()
-		defer responsesMu.RUnlock()
+	packet := &parser.Packet{
+		Type: parser.EVENT,
+		Data: append([]any{ev}, args...),
+	}
 
-		ack(errors.New("operation has timed out"), responses)
-	}, timeout)
+	type result struct {
+		err       error
+		responses []any
+	}
+	done := make(chan *result, 1)
+	stop, err := b.broadcastWithAck(packet, b.flags.Timeout, func(err error, responses []any, _ *BroadcastResponses) {
+		done <- &result{err, responses}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case r := <-done:
+		return r.responses, r.err
+	case <-ctx.Done():
+		stop()
+		return nil, ctx.Err()
+	}
+}
+
+// Emits an event to all connected clients and waits for their acknowledgements, like EmitWithAck(), but returns the
+// acknowledgement of each socket along with the sockets which have not answered.
+//
+// <pre><code>
+//
+//	responses, err := io.Timeout(5 * time.Second).EmitWithResponses(ctx, "are-you-still-there")
+//	for sid, args := range responses.Responses {
+//	  // the socket has answered
+//	}
+//	for _, sid := range responses.TimedOut {
+//	  // the socket has not answered in the given delay
+//	}
+//
+// </pre></code>
+//
+// The responses received so far are returned along with the error when the timeout is reached.
+func (b *BroadcastOperator) EmitWithResponses(ctx context.Context, ev string, args ...any) (*BroadcastResponses, error) {
+	if SOCKET_RESERVED_EVENTS.Has(ev) {
+		return nil, errors.New(fmt.Sprintf(`"%s" is a reserved event name`, ev))
+	}
 
+	packet := &parser.Packet{
+		Type: parser.EVENT,
+		Data: append([]any{ev}, args...),
+	}
+
+	type result struct {
+		err       error
+		responses *BroadcastResponses
+	}
+	done := make(chan *result, 1)
+	stop, err := b.broadcastWithAck(packet, b.flags.Timeout, func(err error, _ []any, responses *BroadcastResponses) {
+		done <- &result{err, responses}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case r := <-done:
+		return r.responses, r.err
+	case <-ctx.Done():
+		stop()
+		return nil, ctx.Err()
+	}
+}
+
+// Broadcasts the packet and calls `ack` once, with the responses of the clients (both all together and by socket), or
+// with an error if the timeout is reached first (no timeout when nil). The returned function stops waiting for the
+// responses. An error is returned, and `ack` is not called, if the packet cannot be encoded.
+func (b *BroadcastOperator) broadcastWithAck(packet *parser.Packet, timeout *time.Duration, ack func(error, []any, *BroadcastResponses)) (func(), error) {
+	var mu sync.Mutex
+	finished := false
+	responses := []any{}
+	result := &BroadcastResponses{
+		Responses:    map[SocketId][]any{},
+		TimedOut:     []SocketId{},
+		Disconnected: []SocketId{},
+		ServerIds:    map[SocketId]ServerId{},
+	}
+	answered := types.NewSet[SocketId]()
 	expectedServerCount := int64(-1)
 	actualServerCount := int64(0)
 	expectedClientCount := uint64(0)
+	actualClientCount := uint64(0)
+	var timer *utils.Timer
+	// the id of the acknowledgement, which is the same for each socket
+	var ackId *uint64
+
+	// removes the ack callbacks of the local sockets which have not answered, so that they do not stay in the sockets
+	// until they disconnect
+	deleteAcks := func(id *uint64, sids []SocketId) {
+		nsp := b.adapter.Nsp()
+		if id == nil || nsp == nil {
+			return
+		}
+		for _, sid := range sids {
+			if socket, ok := nsp.Sockets().Load(sid); ok {
+				socket.(*Socket).deleteAck(*id)
+			}
+		}
+	}
+
+	// stops waiting, returns false if it was already done. The responses are not modified afterwards.
+	finish := func() bool {
+		mu.Lock()
+		if finished {
+			mu.Unlock()
+			return false
+		}
+		finished = true
+		utils.ClearTimeout(timer)
+		for sid := range result.ServerIds {
+			if !answered.Has(sid) {
+				result.TimedOut = append(result.TimedOut, sid)
+			}
+		}
+		sort.Slice(result.TimedOut, func(i, j int) bool {
+			return result.TimedOut[i] < result.TimedOut[j]
+		})
+		id, pending := ackId, result.TimedOut
+		mu.Unlock()
+
+		deleteAcks(id, pending)
+		return true
+	}
 
 	checkCompleteness := func() {
-		responsesMu.RLock()
-		defer responsesMu.RUnlock()
+		mu.Lock()
+		complete := !finished && expectedServerCount == actualServerCount && actualClientCount == expectedClientCount
+		mu.Unlock()
 
-		if !timedOut && expectedServerCount == atomic.LoadInt64(&actualServerCount) && uint64(len(responses)) == atomic.LoadUint64(&expectedClientCount) {
-			utils.ClearTimeout(timer)
-			ack(nil, responses)
+		if complete && finish() {
+			ack(nil, responses, result)
 		}
 	}
 
-	b.adapter.BroadcastWithAck(packet, &BroadcastOptions{
+	if timeout != nil {
+		mu.Lock()
+		timer = utils.SetTimeOut(func() {
+			nsp := b.adapter.Nsp()
+			if nsp != nil {
+				defer nsp.Server()._recover()
+			}
+
+			if finish() {
+				if nsp != nil {
+					nsp.Server().observeAckTimeout(nsp.Name())
+				}
+				ack(errors.New("operation has timed out"), responses, result)
+			}
+		}, *timeout)
+		mu.Unlock()
+	}
+
+	if err := b.adapter.BroadcastWithAck(packet, &BroadcastOptions{
 		Rooms:  b.rooms,
 		Except: b.exceptRooms,
 		Flags:  b.flags,
-	}, func(clientCount uint64) {
-		// each Socket.IO server in the cluster sends the number of clients that were notified
-		atomic.AddUint64(&expectedClientCount, clientCount)
-		atomic.AddInt64(&actualServerCount, 1)
+	}, func(clients *BroadcastClients) {
+		// each Socket.IO server in the cluster sends the clients that were notified
+		mu.Lock()
+		ackId = packet.Id
+		late := finished
+		if !finished {
+			expectedClientCount += clients.Count
+			actualServerCount++
+			for _, sid := range clients.Sids {
+				result.ServerIds[sid] = clients.ServerId
+			}
+		}
+		mu.Unlock()
+		if late {
+			deleteAcks(packet.Id, clients.Sids)
+			return
+		}
 		checkCompleteness()
-	}, func(clientResponse ...any) {
-		// each client sends an acknowledgement
-		responsesMu.Lock()
-		responses = append(responses, clientResponse...)
-		responsesMu.Unlock()
+	}, func(clientAck *BroadcastAck) {
+		// each client sends an acknowledgement, unless it has disconnected in the meantime
+		mu.Lock()
+		if !finished {
+			actualClientCount++
+			if clientAck.Err != nil {
+				if clientAck.Sid != "" {
+					answered.Add(clientAck.Sid)
+					result.Disconnected = append(result.Disconnected, clientAck.Sid)
+				}
+			} else {
+				responses = append(responses, clientAck.Args...)
+				if clientAck.Sid != "" {
+					answered.Add(clientAck.Sid)
+					result.Responses[clientAck.Sid] = clientAck.Args
+				}
+			}
+		}
+		mu.Unlock()
 		checkCompleteness()
-	})
-	expectedServerCount = b.adapter.ServerCount()
+	}); err != nil {
+		finish()
+		return nil, err
+	}
+	serverCount := b.adapter.ServerCount()
+	mu.Lock()
+	expectedServerCount = serverCount
+	mu.Unlock()
 	checkCompleteness()
-	return nil
+
+	return func() {
+		finish()
+	}, nil
 }
 
 // Gets a list of clients.
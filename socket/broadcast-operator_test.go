@@ -0,0 +1,67 @@
+package socket
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/socket.io/parser"
+)
+
+// An adapter which stores the ack callbacks of the broadcasts in a socket whose client never answers.
+type silentAdapter struct {
+	Adapter
+
+	socket *Socket
+}
+
+func (a *silentAdapter) BroadcastWithAck(packet *parser.Packet, _ *BroadcastOptions, clientsCallback func(*BroadcastClients), ack func(*BroadcastAck)) error {
+	id := a.Nsp().Ids()
+	packet.Id = &id
+	sid := a.socket.Id()
+	a.socket.storeAck(id, func(args ...any) {
+		ack(&BroadcastAck{Sid: sid, Args: args})
+	}, func(err error) {
+		ack(&BroadcastAck{Sid: sid, Err: err})
+	}, true)
+	clientsCallback(&BroadcastClients{Count: 1, Sids: []SocketId{sid}})
+	return nil
+}
+
+func (a *silentAdapter) ServerCount() int64 {
+	return 1
+}
+
+func TestBroadcastWithAckCleanup(t *testing.T) {
+	nsp := NewServer(nil, nil).Sockets().(*Namespace)
+	s := &Socket{id: "silent", acks: &sync.Map{}, ackMetas: &sync.Map{}, flags: &BroadcastFlags{}}
+	nsp.sockets.Store(s.id, s)
+	adapter := &silentAdapter{Adapter: nsp.Adapter(), socket: s}
+
+	pending := func() (count int) {
+		s.acks.Range(func(any, any) bool {
+			count++
+			return true
+		})
+		return count
+	}
+
+	operator := NewBroadcastOperator(adapter, types.NewSet[Room](), types.NewSet[Room](), &BroadcastFlags{})
+	if _, err := operator.Timeout(10*time.Millisecond).EmitWithAck(context.Background(), "ev"); err == nil {
+		t.Fatal("expected the timeout error")
+	}
+	if count := pending(); count != 0 {
+		t.Fatalf("expected no ack left after the timeout, got %d", count)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := operator.EmitWithAck(ctx, "ev"); err == nil {
+		t.Fatal("expected the context error")
+	}
+	if count := pending(); count != 0 {
+		t.Fatalf("expected no ack left after the cancellation, got %d", count)
+	}
+}
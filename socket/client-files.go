@@ -0,0 +1,159 @@
+package socket
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	clientdist "github.com/zishang520/socket.io/client-dist"
+)
+
+// The encodings of the client files, by order of preference.
+var clientFileEncodings = []string{"br", "gzip", "deflate"}
+
+// A file of the client bundle. Its compressed variants are computed once, upon the first request which accepts them,
+// with the default levels of the encodings: the best ones take up to half a second for the larger files, which would be
+// spent on the path of the request, for a tenth fewer bytes.
+type clientFile struct {
+	name     string
+	content  []byte
+	variants map[string][]byte
+
+	mu sync.Mutex
+}
+
+var (
+	clientFiles      map[string]*clientFile
+	clientFiles_once sync.Once
+)
+
+// Returns the embedded client file, or nil if there is none with that name.
+func lookupClientFile(name string) *clientFile {
+	clientFiles_once.Do(func() {
+		clientFiles = map[string]*clientFile{}
+		err := fs.WalkDir(clientdist.FS, ".", func(name string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			content, err := fs.ReadFile(clientdist.FS, name)
+			if err != nil {
+				return err
+			}
+			clientFiles[name] = &clientFile{name: name, content: content, variants: map[string][]byte{}}
+			return nil
+		})
+		if err != nil {
+			server_log.Debug("failed to read the client files: %v", err)
+		}
+	})
+	return clientFiles[name]
+}
+
+// Returns the content of the file compressed with the encoding, or nil if it can not be compressed.
+func (f *clientFile) encoded(encoding string) []byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if variant, ok := f.variants[encoding]; ok {
+		return variant
+	}
+
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	var err error
+	switch encoding {
+	case "br":
+		writer = brotli.NewWriterLevel(&buffer, brotli.DefaultCompression)
+	case "gzip":
+		writer, err = gzip.NewWriterLevel(&buffer, gzip.DefaultCompression)
+	case "deflate":
+		writer, err = flate.NewWriter(&buffer, flate.DefaultCompression)
+	default:
+		return nil
+	}
+	if err == nil {
+		if _, err = writer.Write(f.content); err == nil {
+			err = writer.Close()
+		}
+	}
+	if err != nil {
+		server_log.Debug("failed to compress %s with %s: %v", f.name, encoding, err)
+		f.variants[encoding] = nil
+		return nil
+	}
+	f.variants[encoding] = buffer.Bytes()
+	return f.variants[encoding]
+}
+
+// Returns the encoding of clientFileEncodings with the highest quality value in the Accept-Encoding header (the first
+// one of the list on a tie), or "" when none of them is acceptable. A quality value of 0 means "not acceptable", and
+// "*" stands for the encodings which are not listed.
+func acceptedEncoding(header string) string {
+	qualities := map[string]float64{}
+	for _, token := range strings.Split(header, ",") {
+		params := strings.Split(token, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.ToLower(strings.TrimSpace(name)) != "q" {
+				continue
+			}
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil || q < 0 || q > 1 {
+				// an invalid quality value makes the encoding unacceptable
+				q = 0
+			}
+			quality = q
+		}
+		qualities[coding] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, encoding := range clientFileEncodings {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality = qualities["*"]
+		}
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// Writes the file, compressed with the best encoding accepted by the request. The conditional requests are supported,
+// the ETag of each variant being suffixed with its encoding, and so are the range requests of the uncompressed file.
+func (f *clientFile) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	content := f.content
+	etag := clientVersion
+	if encoding := acceptedEncoding(r.Header.Get("Accept-Encoding")); encoding != "" {
+		if variant := f.encoded(encoding); variant != nil {
+			content = variant
+			etag += "-" + encoding
+			w.Header().Set("Content-Encoding", encoding)
+			// http.ServeContent() leaves the length of an encoded content to the caller, so the whole variant is sent
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			r = r.Clone(r.Context())
+			r.Header.Del("Range")
+		}
+	}
+	// Per the standard, ETags must be quoted:
+	// https://tools.ietf.org/html/rfc7232#section-2.3
+	w.Header().Set("ETag", `"`+etag+`"`)
+
+	http.ServeContent(w, r, f.name, time.Time{}, bytes.NewReader(content))
+}
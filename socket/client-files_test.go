@@ -0,0 +1,73 @@
+package socket
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	for header, expected := range map[string]string{
+		"":                              "",
+		"identity":                      "",
+		"gzip":                          "gzip",
+		"gzip, deflate, br":             "br",
+		"gzip;q=0":                      "",
+		"gzip;q=0, deflate":             "deflate",
+		"br;q=0.5, gzip;q=0.8":          "gzip",
+		"br;q=0.8, gzip;q=0.8":          "br",
+		"GZIP ; Q=1":                    "gzip",
+		"x-gzip":                        "",
+		"*":                             "br",
+		"*;q=0.5, br;q=0, gzip;q=0.4":   "deflate",
+		"gzip;q=invalid, deflate;q=0.1": "deflate",
+	} {
+		if encoding := acceptedEncoding(header); encoding != expected {
+			t.Errorf("%q: expected %q, got %q", header, expected, encoding)
+		}
+	}
+}
+
+func TestClientFileEncoded(t *testing.T) {
+	file := lookupClientFile("socket.io.min.js")
+	if file == nil {
+		t.Fatal("socket.io.min.js is not embedded")
+	}
+	for encoding, reader := range map[string]func(io.Reader) (io.Reader, error){
+		"br": func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		},
+		"gzip": func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		"deflate": func(r io.Reader) (io.Reader, error) {
+			return flate.NewReader(r), nil
+		},
+	} {
+		variant := file.encoded(encoding)
+		if len(variant) == 0 || len(variant) >= len(file.content) {
+			t.Fatalf("%s: unexpected variant of %d bytes for %d bytes", encoding, len(variant), len(file.content))
+		}
+		r, err := reader(bytes.NewReader(variant))
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(content, file.content) {
+			t.Errorf("%s: the variant does not decode into the file", encoding)
+		}
+		if again := file.encoded(encoding); &again[0] != &variant[0] {
+			t.Errorf("%s: the variant is compressed again", encoding)
+		}
+	}
+	if variant := file.encoded("compress"); variant != nil {
+		t.Errorf("compress: expected no variant, got %d bytes", len(variant))
+	}
+}
@@ -1,11 +1,15 @@
 package socket
 
 import (
+	"errors"
+	"io"
 	"net/url"
 	"sync"
 
 	"github.com/zishang520/engine.io/engine"
 	"github.com/zishang520/engine.io/log"
+	"github.com/zishang520/engine.io/packet"
+	"github.com/zishang520/engine.io/transports"
 	"github.com/zishang520/engine.io/types"
 	"github.com/zishang520/engine.io/utils"
 	"github.com/zishang520/socket.io/parser"
@@ -13,6 +17,19 @@ import (
 
 var client_log = log.NewLog("socket.io:client")
 
+// The encoded parts of a packet, waiting for the transport to be writable.
+type queuedPacket struct {
+	buffers []types.BufferInterface
+	options *packet.Options
+	size    int64
+}
+
+// A part of a packet handed to the engine, until it is flushed to the transport.
+type pendingBuffer struct {
+	size int64
+	last bool
+}
+
 type Client struct {
 	conn              engine.Socket
 	id                string
@@ -23,6 +40,20 @@ type Client struct {
 	nsps              *sync.Map
 	connectTimeout    *utils.Timer
 	mu_connectTimeout sync.Mutex
+
+	// the outgoing packets, which are written in order by a single goroutine at a time
+	queue      []*queuedPacket
+	queueSize  int64
+	flushing   bool
+	transport  transports.Transport
+	overflowed bool
+	mu_queue   sync.Mutex
+
+	// the packets handed to the engine but not flushed to the transport yet
+	pending        map[io.Reader]*pendingBuffer
+	pendingSize    int64
+	pendingPackets int64
+	mu_pending     sync.Mutex
 }
 
 func (c *Client) Conn() engine.Socket {
@@ -34,6 +65,7 @@ func NewClient(server *Server, conn engine.Socket) *Client {
 	c := &Client{}
 	c.sockets = &sync.Map{}
 	c.nsps = &sync.Map{}
+	c.pending = map[io.Reader]*pendingBuffer{}
 	c.server = server
 	c.conn = conn
 	c.encoder = server.Encoder()
@@ -51,9 +83,17 @@ func (c *Client) Request() *types.HttpContext {
 // Sets up event listeners.
 func (c *Client) setup() {
 	c.decoder.On("decoded", c.ondecoded)
+	c.decoder.On("error", c.ondecodeerror)
 	c.conn.On("data", c.ondata)
 	c.conn.On("error", c.onerror)
 	c.conn.On("close", c.onclose)
+	c.conn.On("flush", c.onflush)
+	c.conn.On("upgrade", c.onupgrade)
+
+	c.mu_queue.Lock()
+	c.transport = c.conn.Transport()
+	c.transport.On("drain", c.ondrain)
+	c.mu_queue.Unlock()
 
 	c.mu_connectTimeout.Lock()
 	defer c.mu_connectTimeout.Unlock()
@@ -75,6 +115,17 @@ func (c *Client) setup() {
 
 // Connects a client to a namespace.
 func (c *Client) connect(name string, auth any) {
+	if c.server.shuttingDown() {
+		client_log.Debug("the server is shutting down, refusing the connection to namespace %s", name)
+		c._packet(&parser.Packet{
+			Type: parser.CONNECT_ERROR,
+			Nsp:  name,
+			Data: map[string]string{
+				"message": "Server shutting down",
+			},
+		}, nil)
+		return
+	}
 	if _, ok := c.server._nsps.Load(name); ok {
 		client_log.Debug("connecting to namespace %s", name)
 		c.doConnect(name, auth)
@@ -139,11 +190,11 @@ func (c *Client) close() {
 	}
 }
 
-// Writes a packet to the transport.
-func (c *Client) _packet(packet *parser.Packet, opts *WriteOptions) {
+// Writes a packet to the transport, returns an error if it cannot be encoded.
+func (c *Client) _packet(packet *parser.Packet, opts *WriteOptions) error {
 	if c.conn.ReadyState() != "open" {
 		client_log.Debug("ignoring packet write %v", packet)
-		return
+		return nil
 	}
 
 	if opts == nil {
@@ -152,7 +203,12 @@ func (c *Client) _packet(packet *parser.Packet, opts *WriteOptions) {
 
 	// packet // previous versions of the adapter incorrectly used socket.packet() instead of writeToEngine()
 
-	c.WriteToEngine(c.encoder.Encode(packet), opts)
+	encodedPackets, err := c.encoder.Encode(packet)
+	if err != nil {
+		return err
+	}
+	c.WriteToEngine(encodedPackets, opts)
+	return nil
 }
 
 func (c *Client) WriteToEngine(encodedPackets []types.BufferInterface, opts *WriteOptions) {
@@ -161,27 +217,238 @@ func (c *Client) WriteToEngine(encodedPackets []types.BufferInterface, opts *Wri
 		return
 	}
 
+	queued := &queuedPacket{
+		buffers: make([]types.BufferInterface, 0, len(encodedPackets)),
+		options: &opts.Options,
+	}
+	// the buffers are shared by all the recipients of a broadcast
 	for _, encodedPacket := range encodedPackets {
 		switch data := encodedPacket.(type) {
 		case *types.StringBuffer:
-			c.conn.Write(types.NewStringBuffer(data.Bytes()), &opts.Options, nil)
+			queued.buffers = append(queued.buffers, types.NewStringBuffer(data.Bytes()))
 		case *types.BytesBuffer:
-			c.conn.Write(types.NewBytesBuffer(data.Bytes()), &opts.Options, nil)
+			queued.buffers = append(queued.buffers, types.NewBytesBuffer(data.Bytes()))
+		default:
+			continue
+		}
+		queued.size += int64(encodedPacket.Len())
+	}
+	if len(queued.buffers) == 0 {
+		return
+	}
+
+	c.mu_queue.Lock()
+	if c.overflowed {
+		c.mu_queue.Unlock()
+		client_log.Debug("packet is discarded since the client is being disconnected")
+		return
+	}
+	if !c.reserve(queued.size) {
+		overflowed := c.server.opts.BufferPolicy() == BUFFER_POLICY_DISCONNECT
+		c.overflowed = overflowed
+		c.mu_queue.Unlock()
+		if overflowed {
+			client_log.Debug("the buffer of client %s is full, closing the connection", c.id)
+			c.conn.Close(true)
+		} else {
+			client_log.Debug("packet is discarded since the buffer of client %s is full", c.id)
+		}
+		return
+	}
+	if !c.limited() || c.server.opts.BufferPolicy() != BUFFER_POLICY_DROP_OLDEST {
+		if len(c.queue) == 0 && !c.flushing {
+			// the engine buffers the packets itself, as long as none of them has to be discarded afterwards
+			c.track(queued)
+			c.mu_queue.Unlock()
+			c.write(queued)
+			return
+		}
+	}
+	c.queue = append(c.queue, queued)
+	c.queueSize += queued.size
+	if c.flushing {
+		// the packet is written by the goroutine which is already flushing the queue
+		c.mu_queue.Unlock()
+		return
+	}
+	c.flushing = true
+	c.mu_queue.Unlock()
+
+	c.writeQueue()
+}
+
+// Returns the number of bytes which are buffered for this client, until they are written to the transport, the packets
+// already handed to the engine included.
+func (c *Client) BufferedAmount() int64 {
+	c.mu_queue.Lock()
+	queueSize := c.queueSize
+	c.mu_queue.Unlock()
+
+	c.mu_pending.Lock()
+	defer c.mu_pending.Unlock()
+
+	return queueSize + c.pendingSize
+}
+
+// Checks whether a packet of the given size fits in the buffer, dropping the oldest packets when allowed. Must be
+// called with the queue locked.
+func (c *Client) reserve(size int64) bool {
+	if !c.limited() {
+		return true
+	}
+	maxBytes := c.server.opts.MaxBufferedBytes()
+	maxPackets := c.server.opts.MaxBufferedPackets()
+
+	c.mu_pending.Lock()
+	pendingSize, pendingPackets := c.pendingSize, c.pendingPackets
+	c.mu_pending.Unlock()
+
+	fits := func() bool {
+		return (maxBytes <= 0 || pendingSize+c.queueSize+size <= maxBytes) &&
+			(maxPackets <= 0 || pendingPackets+int64(len(c.queue))+1 <= maxPackets)
+	}
+	if c.server.opts.BufferPolicy() == BUFFER_POLICY_DROP_OLDEST {
+		for !fits() && len(c.queue) > 0 {
+			client_log.Debug("the oldest packet is discarded since the buffer of client %s is full", c.id)
+			c.queueSize -= c.queue[0].size
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+		}
+	}
+	return fits()
+}
+
+// Whether the size of the buffer is limited.
+func (c *Client) limited() bool {
+	return c.server.opts.MaxBufferedBytes() > 0 || c.server.opts.MaxBufferedPackets() > 0
+}
+
+// Writes the queued packets to the engine while the transport is writable. Only one goroutine at a time writes
+// the queue, so that the packets are kept in order.
+func (c *Client) writeQueue() {
+	for {
+		c.mu_queue.Lock()
+		if len(c.queue) == 0 || c.overflowed || !c.conn.Transport().Writable() {
+			c.flushing = false
+			c.mu_queue.Unlock()
+			return
+		}
+		queue := c.queue
+		c.queue = nil
+		c.queueSize = 0
+
+		for _, queued := range queue {
+			c.track(queued)
+		}
+		c.mu_queue.Unlock()
+
+		for _, queued := range queue {
+			c.write(queued)
+		}
+	}
+}
+
+// Counts the packet as buffered, until the engine flushes it to the transport.
+func (c *Client) track(queued *queuedPacket) {
+	c.mu_pending.Lock()
+	defer c.mu_pending.Unlock()
+
+	for i, buffer := range queued.buffers {
+		size := int64(buffer.Len())
+		c.pending[buffer] = &pendingBuffer{size: size, last: i == len(queued.buffers)-1}
+		c.pendingSize += size
+	}
+	c.pendingPackets++
+}
+
+// Hands the packet to the engine.
+func (c *Client) write(queued *queuedPacket) {
+	for _, buffer := range queued.buffers {
+		c.conn.Write(buffer, queued.options, nil)
+	}
+}
+
+// Called when the transport is writable again.
+func (c *Client) ondrain(...any) {
+	c.mu_queue.Lock()
+	if c.flushing || len(c.queue) == 0 {
+		c.mu_queue.Unlock()
+		return
+	}
+	c.flushing = true
+	c.mu_queue.Unlock()
+
+	c.writeQueue()
+}
+
+// Called when the engine flushes its buffer to the transport.
+func (c *Client) onflush(args ...any) {
+	packets, _ := args[0].([]*packet.Packet)
+
+	c.mu_pending.Lock()
+	defer c.mu_pending.Unlock()
+
+	for _, p := range packets {
+		if pending, ok := c.pending[p.Data]; ok {
+			delete(c.pending, p.Data)
+			c.pendingSize -= pending.size
+			if pending.last {
+				c.pendingPackets--
+			}
 		}
 	}
 }
 
+// Called when the connection is upgraded to a new transport.
+func (c *Client) onupgrade(args ...any) {
+	c.mu_queue.Lock()
+	if c.transport != nil {
+		c.transport.RemoveListener("drain", c.ondrain)
+	}
+	c.transport, _ = args[0].(transports.Transport)
+	if c.transport != nil {
+		c.transport.On("drain", c.ondrain)
+	}
+	c.mu_queue.Unlock()
+
+	c.ondrain()
+}
+
 // Called with incoming transport data.
 func (c *Client) ondata(args ...any) {
 	// error is needed for protocol violations (GH-1880)
 	if err := c.decoder.Add(args[0]); err != nil {
+		c.ondecodeerror(err)
+	}
+}
+
+// Called when a packet is invalid or exceeds the limits of the parser (possibly after a delay, for the attachments
+// which are not received in time).
+func (c *Client) ondecodeerror(args ...any) {
+	err, _ := args[0].(error)
+	var limitErr *parser.LimitError
+	if errors.As(err, &limitErr) {
+		client_log.Debug("packet exceeds the limits of the parser: %v", err)
+		empty := true
+		c.sockets.Range(func(any, any) bool {
+			empty = false
+			return false
+		})
+		if empty {
+			// the error is reported by the sockets otherwise
+			c.server._onerror(nil, err)
+		}
+	} else {
 		client_log.Debug("invalid packet format")
-		c.onerror(err)
 	}
+	c.onerror(err)
 }
 
 // Called when parser fully decodes a packet.
 func (c *Client) ondecoded(args ...any) {
+	// the packets of the sockets are handled by their own queue, this only covers the connection to a namespace
+	defer c.server._recover()
+
 	packet, _ := args[0].(*parser.Packet)
 	var namespace string
 	var authPayload any
@@ -198,7 +465,7 @@ func (c *Client) ondecoded(args ...any) {
 	if !ok && packet.Type == parser.CONNECT {
 		c.connect(namespace, authPayload)
 	} else if ok && packet.Type != parser.CONNECT && packet.Type != parser.CONNECT_ERROR {
-		go socket.(*Socket)._onpacket(packet)
+		socket.(*Socket)._onpacket(packet)
 	} else {
 		client_log.Debug("invalid state (packet type: %s)", packet.Type.String())
 		c.close()
@@ -217,6 +484,7 @@ func (c *Client) onerror(args ...any) {
 // Called upon transport close.
 func (c *Client) onclose(args ...any) {
 	client_log.Debug("client close with reason %v", args[0])
+	c.server.clients.Delete(c.id)
 	// ignore a potential subsequent `close` event
 	c.destroy()
 	// `nsps` and `sockets` are cleaned up seamlessly
@@ -233,7 +501,23 @@ func (c *Client) destroy() {
 	c.conn.RemoveListener("data", c.ondata)
 	c.conn.RemoveListener("error", c.onerror)
 	c.conn.RemoveListener("close", c.onclose)
+	c.conn.RemoveListener("flush", c.onflush)
+	c.conn.RemoveListener("upgrade", c.onupgrade)
+	c.mu_queue.Lock()
+	if c.transport != nil {
+		c.transport.RemoveListener("drain", c.ondrain)
+		c.transport = nil
+	}
+	c.queue = nil
+	c.queueSize = 0
+	c.mu_queue.Unlock()
+	c.mu_pending.Lock()
+	c.pending = map[io.Reader]*pendingBuffer{}
+	c.pendingSize = 0
+	c.pendingPackets = 0
+	c.mu_pending.Unlock()
 	c.decoder.RemoveListener("decoded", c.ondecoded)
+	c.decoder.RemoveListener("error", c.ondecodeerror)
 	c.mu_connectTimeout.Lock()
 	defer c.mu_connectTimeout.Unlock()
 	if c.connectTimeout != nil {
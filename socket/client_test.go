@@ -0,0 +1,150 @@
+package socket_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/zishang520/socket.io/socket"
+)
+
+// Connects a raw WebSocket client, which stops reading its connection once connected to the main namespace until
+// `release` is closed, and returns the messages read afterwards.
+func connectStalledClient(t *testing.T, opts *socket.ServerOptions) (*socket.Socket, chan string, chan struct{}) {
+	t.Helper()
+
+	io, url := newTestServer(t, opts)
+	sockets := make(chan *socket.Socket, 1)
+	io.On("connection", func(args ...any) {
+		sockets <- args[0].(*socket.Socket)
+	})
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http")+"/socket.io/?EIO=4&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	// the OPEN packet of Engine.IO, then the CONNECT packet of Socket.IO
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("40")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	s := receive(t, sockets)
+
+	release := make(chan struct{})
+	messages := make(chan string, 100)
+	go func() {
+		<-release
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				close(messages)
+				return
+			}
+			messages <- string(message)
+		}
+	}()
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+	return s, messages, release
+}
+
+// Waits until the transport of the socket is busy writing a packet.
+func waitBusy(t *testing.T, s *socket.Socket) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); s.Conn().Transport().Writable(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the transport is still writable")
+		}
+	}
+	// the write is blocked by the stalled client
+	time.Sleep(50 * time.Millisecond)
+}
+
+// Large enough to fill the buffers of the connection.
+func payload() string {
+	return strings.Repeat("x", 16<<20)
+}
+
+// Returns the EVENT packets read by the client until the connection is idle, the payload being replaced by "payload".
+func readEvents(messages chan string) []string {
+	events := []string{}
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return events
+			}
+			if strings.HasPrefix(message, "42") {
+				if len(message) > 1<<10 {
+					message = "payload"
+				}
+				events = append(events, message)
+			}
+		case <-time.After(500 * time.Millisecond):
+			return events
+		}
+	}
+}
+
+func TestBufferedAmount(t *testing.T) {
+	s, messages, release := connectStalledClient(t, nil)
+
+	// the transport is busy writing the payload, so the next packet waits in the buffer of the engine, and is counted
+	// without a limit
+	go s.Emit("data", payload())
+	waitBusy(t, s)
+	s.Emit("data", "next")
+	if s.BufferedAmount() == 0 {
+		t.Fatal("expected the packet to be counted as buffered")
+	}
+	close(release)
+	if events := readEvents(messages); len(events) != 2 {
+		t.Fatalf("expected 2 events, got %v", events)
+	}
+	if amount := s.BufferedAmount(); amount != 0 {
+		t.Fatalf("expected an empty buffer once the packets are flushed, got %d", amount)
+	}
+}
+
+func TestBufferPolicy(t *testing.T) {
+	for policy, expected := range map[socket.BufferPolicy]string{
+		socket.BUFFER_POLICY_DROP_NEWEST: `[payload 42["data",1] 42["data",2]]`,
+		socket.BUFFER_POLICY_DROP_OLDEST: `[payload 42["data",3] 42["data",4]]`,
+		// the packets handed to the engine are flushed before the connection is closed
+		socket.BUFFER_POLICY_DISCONNECT: `[payload 42["data",1] 42["data",2]]`,
+	} {
+		opts := socket.DefaultServerOptions()
+		opts.SetMaxBufferedPackets(2)
+		opts.SetBufferPolicy(policy)
+		s, messages, release := connectStalledClient(t, opts)
+
+		// the transport is busy writing the payload, so the next packets are buffered, two of them fitting
+		go s.Emit("data", payload())
+		waitBusy(t, s)
+		for i := 1; i <= 4; i++ {
+			s.Emit("data", i)
+		}
+		close(release)
+		if events := fmt.Sprint(readEvents(messages)); events != expected {
+			t.Fatalf("%v: expected %s, got %s", policy, expected, events)
+		}
+		if policy == socket.BUFFER_POLICY_DISCONNECT && s.Connected() {
+			t.Fatal("expected the socket to be disconnected")
+		}
+	}
+}
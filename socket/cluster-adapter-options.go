@@ -0,0 +1,80 @@
+package socket
+
+import (
+	"time"
+)
+
+type ClusterAdapterOptions struct {
+	// the prefix of the channels used to communicate with the other servers
+	key *string
+
+	// the number of ms between two heartbeats
+	heartbeatInterval *time.Duration
+
+	// the number of ms without heartbeat before we consider a node down
+	heartbeatTimeout *time.Duration
+
+	// after this timeout the adapter will stop waiting from responses to request
+	requestsTimeout *time.Duration
+}
+
+func DefaultClusterAdapterOptions() *ClusterAdapterOptions {
+	c := &ClusterAdapterOptions{}
+	return c
+}
+
+func (c *ClusterAdapterOptions) SetKey(key string) {
+	c.key = &key
+}
+func (c *ClusterAdapterOptions) GetRawKey() *string {
+	return c.key
+}
+func (c *ClusterAdapterOptions) Key() string {
+	if c.key == nil {
+		return "socket.io"
+	}
+
+	return *c.key
+}
+
+func (c *ClusterAdapterOptions) SetHeartbeatInterval(heartbeatInterval time.Duration) {
+	c.heartbeatInterval = &heartbeatInterval
+}
+func (c *ClusterAdapterOptions) GetRawHeartbeatInterval() *time.Duration {
+	return c.heartbeatInterval
+}
+func (c *ClusterAdapterOptions) HeartbeatInterval() time.Duration {
+	if c.heartbeatInterval == nil {
+		return time.Duration(5000 * time.Millisecond)
+	}
+
+	return *c.heartbeatInterval
+}
+
+func (c *ClusterAdapterOptions) SetHeartbeatTimeout(heartbeatTimeout time.Duration) {
+	c.heartbeatTimeout = &heartbeatTimeout
+}
+func (c *ClusterAdapterOptions) GetRawHeartbeatTimeout() *time.Duration {
+	return c.heartbeatTimeout
+}
+func (c *ClusterAdapterOptions) HeartbeatTimeout() time.Duration {
+	if c.heartbeatTimeout == nil {
+		return time.Duration(10000 * time.Millisecond)
+	}
+
+	return *c.heartbeatTimeout
+}
+
+func (c *ClusterAdapterOptions) SetRequestsTimeout(requestsTimeout time.Duration) {
+	c.requestsTimeout = &requestsTimeout
+}
+func (c *ClusterAdapterOptions) GetRawRequestsTimeout() *time.Duration {
+	return c.requestsTimeout
+}
+func (c *ClusterAdapterOptions) RequestsTimeout() time.Duration {
+	if c.requestsTimeout == nil {
+		return time.Duration(5000 * time.Millisecond)
+	}
+
+	return *c.requestsTimeout
+}
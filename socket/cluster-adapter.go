@@ -0,0 +1,939 @@
+package socket
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"github.com/zishang520/engine.io/log"
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/engine.io/utils"
+	"github.com/zishang520/socket.io/parser"
+)
+
+var cluster_adapter_log = log.NewLog("socket.io-adapter:cluster")
+
+type ClusterMessageType int
+
+const (
+	INITIAL_HEARTBEAT ClusterMessageType = iota + 1
+	HEARTBEAT
+	BROADCAST
+	SOCKETS_JOIN
+	SOCKETS_LEAVE
+	DISCONNECT_SOCKETS
+	FETCH_SOCKETS
+	FETCH_SOCKETS_RESPONSE
+	SERVER_SIDE_EMIT
+	SERVER_SIDE_EMIT_RESPONSE
+	BROADCAST_CLIENT_COUNT
+	BROADCAST_ACK
+	ADAPTER_CLOSE
+)
+
+type clusterBroadcastFlags struct {
+	Volatile bool   `json:"volatile,omitempty"`
+	Compress bool   `json:"compress,omitempty"`
+	Timeout  *int64 `json:"timeout,omitempty"`
+}
+
+type clusterBroadcastOptions struct {
+	Rooms  []Room                 `json:"rooms,omitempty"`
+	Except []Room                 `json:"except,omitempty"`
+	Flags  *clusterBroadcastFlags `json:"flags,omitempty"`
+}
+
+type clusterPacket struct {
+	Type parser.PacketType `json:"type"`
+	Nsp  string            `json:"nsp"`
+	Data any               `json:"data,omitempty"`
+	Id   *uint64           `json:"id,omitempty"`
+}
+
+type clusterHandshake struct {
+	Headers map[string][]string `json:"headers"`
+	Time    string              `json:"time"`
+	Address string              `json:"address"`
+	Xdomain bool                `json:"xdomain"`
+	Secure  bool                `json:"secure"`
+	Issued  int64               `json:"issued"`
+	Url     string              `json:"url"`
+	Query   map[string][]string `json:"query"`
+	Auth    any                 `json:"auth"`
+}
+
+// The serialized form of a socket hosted on another server, as returned by FetchSockets().
+type clusterSocket struct {
+	SocketId        SocketId          `json:"id"`
+	SocketHandshake *clusterHandshake `json:"handshake"`
+	SocketRooms     []Room            `json:"rooms"`
+	SocketData      any               `json:"data"`
+}
+
+func (c *clusterSocket) Id() SocketId {
+	return c.SocketId
+}
+
+func (c *clusterSocket) Handshake() *Handshake {
+	if c.SocketHandshake == nil {
+		return nil
+	}
+	return &Handshake{
+		Headers: utils.NewParameterBag(c.SocketHandshake.Headers),
+		Time:    c.SocketHandshake.Time,
+		Address: c.SocketHandshake.Address,
+		Xdomain: c.SocketHandshake.Xdomain,
+		Secure:  c.SocketHandshake.Secure,
+		Issued:  c.SocketHandshake.Issued,
+		Url:     c.SocketHandshake.Url,
+		Query:   utils.NewParameterBag(c.SocketHandshake.Query),
+		Auth:    decodeClusterData(c.SocketHandshake.Auth),
+	}
+}
+
+func (c *clusterSocket) Rooms() *types.Set[Room] {
+	return types.NewSet(c.SocketRooms...)
+}
+
+func (c *clusterSocket) Data() any {
+	return decodeClusterData(c.SocketData)
+}
+
+// The messages exchanged between the servers, either published to all servers or sent as a response to a given server.
+type clusterMessage struct {
+	Uid         ServerId                 `json:"uid"`
+	Nsp         string                   `json:"nsp"`
+	Type        ClusterMessageType       `json:"type"`
+	RequestId   string                   `json:"requestId,omitempty"`
+	Packet      *clusterPacket           `json:"packet,omitempty"`
+	Opts        *clusterBroadcastOptions `json:"opts,omitempty"`
+	Rooms       []Room                   `json:"rooms,omitempty"`
+	Close       bool                     `json:"close,omitempty"`
+	Args        []any                    `json:"args,omitempty"`
+	Sockets     []*clusterSocket         `json:"sockets,omitempty"`
+	ClientCount uint64                   `json:"clientCount,omitempty"`
+	Sids        []SocketId               `json:"sids,omitempty"`
+	Sid         SocketId                 `json:"sid,omitempty"`
+	// the reason of the disconnection of a client which has not answered the broadcast
+	DisconnectReason string `json:"disconnectReason,omitempty"`
+	// the offset appended to the data of a broadcast packet, with the connection state recovery
+	Offset string `json:"offset,omitempty"`
+}
+
+type clusterAckRequest struct {
+	clientsCallback func(*BroadcastClients)
+	ack             func(*BroadcastAck)
+}
+
+// A request sent to all the other servers, which is resolved once each of them has responded.
+type clusterRequest struct {
+	missingUids *types.Set[ServerId]
+	expected    int
+	responses   []any
+	timeout     *utils.Timer
+	resolve     func(error, []any)
+
+	mu sync.Mutex
+}
+
+// An adapter synchronizing several Socket.IO servers over a ClusterTransport.
+//
+// Each server publishes its operations on a channel shared by the servers of the namespace, and receives the
+// responses to its requests on a channel of its own. The servers of the cluster are discovered with heartbeats.
+//
+// With the connection state recovery, each server stores the packets broadcast by all the servers, so that a client
+// reconnecting to the same server (with sticky sessions) receives the packets it has missed.
+type ClusterAdapter struct {
+	*adapter
+
+	// the sessions and the packets, when the connection state recovery is enabled
+	sessions *sessionAwareAdapter
+
+	transport ClusterTransport
+	opts      *ClusterAdapterOptions
+
+	uid             ServerId
+	channel         string
+	responseChannel string
+	unsubscribes    []func()
+	nodes           *sync.Map
+	requests        *sync.Map
+	ackRequests     *sync.Map
+	heartbeatTimer  *utils.Timer
+	cleanupTimer    *utils.Timer
+	closed          bool
+
+	mu_heartbeatTimer sync.Mutex
+}
+
+// Returns a cluster adapter, to be used with Server.SetAdapter() or ServerOptions.SetAdapter().
+func NewClusterAdapter(transport ClusterTransport, opts *ClusterAdapterOptions) Adapter {
+	if opts == nil {
+		opts = DefaultClusterAdapterOptions()
+	}
+	return &ClusterAdapter{
+		transport: transport,
+		opts:      opts,
+	}
+}
+
+func (c *ClusterAdapter) New(nsp NamespaceInterface) Adapter {
+	a := &ClusterAdapter{}
+	if nsp.Server().opts.ConnectionStateRecovery() != nil {
+		a.sessions = (&sessionAwareAdapter{}).New(nsp).(*sessionAwareAdapter)
+		a.adapter = a.sessions.adapter
+	} else {
+		a.adapter = (&adapter{}).New(nsp).(*adapter)
+	}
+	a.adapter._broadcast = a.broadcast
+	a.transport = c.transport
+	a.opts = c.opts
+	uid, _ := utils.Base64Id().GenerateId()
+	a.uid = ServerId(uid)
+	a.channel = a.opts.Key() + "#" + nsp.Name() + "#"
+	a.responseChannel = a.channel + string(a.uid) + "#"
+	a.nodes = &sync.Map{}
+	a.requests = &sync.Map{}
+	a.ackRequests = &sync.Map{}
+
+	for _, channel := range []string{a.channel, a.responseChannel} {
+		if unsubscribe, err := a.transport.Subscribe(channel, a.onmessage); err != nil {
+			cluster_adapter_log.Error("failed to subscribe to %s: %v", channel, err)
+		} else {
+			a.unsubscribes = append(a.unsubscribes, unsubscribe)
+		}
+	}
+
+	a.cleanupTimer = utils.SetInterval(func() {
+		threshold := time.Now().UnixMilli() - a.opts.HeartbeatTimeout().Milliseconds()
+		a.nodes.Range(func(uid any, lastSeen any) bool {
+			if lastSeen.(int64) < threshold {
+				cluster_adapter_log.Debug("node %s seems down", uid)
+				a.removeNode(uid.(ServerId))
+			}
+			return true
+		})
+	}, 1000*time.Millisecond)
+
+	return a
+}
+
+// The unique ID of this server.
+func (c *ClusterAdapter) Uid() ServerId {
+	return c.uid
+}
+
+func (c *ClusterAdapter) Init() {
+	c.publish(&clusterMessage{Type: INITIAL_HEARTBEAT})
+}
+
+func (c *ClusterAdapter) Close() {
+	c.publish(&clusterMessage{Type: ADAPTER_CLOSE})
+
+	c.mu_heartbeatTimer.Lock()
+	c.closed = true
+	utils.ClearTimeout(c.heartbeatTimer)
+	c.mu_heartbeatTimer.Unlock()
+
+	utils.ClearInterval(c.cleanupTimer)
+	for _, unsubscribe := range c.unsubscribes {
+		unsubscribe()
+	}
+	if c.sessions != nil {
+		c.sessions.Close()
+	} else {
+		c.adapter.Close()
+	}
+}
+
+// Save the client session in order to restore it upon reconnection.
+func (c *ClusterAdapter) PersistSession(session *SessionToPersist) {
+	if c.sessions != nil {
+		c.sessions.PersistSession(session)
+	}
+}
+
+// Restore the session and find the packets that were missed by the client.
+func (c *ClusterAdapter) RestoreSession(pid PrivateSessionId, offset string) (*Session, error) {
+	if c.sessions != nil {
+		return c.sessions.RestoreSession(pid, offset)
+	}
+	return nil, nil
+}
+
+// Returns the number of Socket.IO servers in the cluster
+func (c *ClusterAdapter) ServerCount() int64 {
+	count := int64(1)
+	c.nodes.Range(func(any, any) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// Broadcasts a packet.
+//
+// Options:
+//   - `Flags` {*BroadcastFlags} flags for this packet
+//   - `Except` {*types.Set[Room]} sids that should be excluded
+//   - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
+func (c *ClusterAdapter) broadcast(packet *parser.Packet, opts *BroadcastOptions) error {
+	packet.Nsp = c.nsp.Name()
+	data, err := encodeClusterData(packet.Data)
+	if err != nil {
+		return &parser.EncodeError{Packet: packet, Err: err}
+	}
+	packet.Data = data
+
+	var persisted *persistedPacket
+	if c.sessions != nil {
+		persisted = c.sessions.persistPacket(packet, opts, "")
+	}
+	// the message is built before the packet is encoded, since the binary packets are modified by the encoder
+	message := &clusterMessage{
+		Type:   BROADCAST,
+		Packet: encodeClusterPacket(packet),
+		Opts:   encodeClusterOptions(opts),
+	}
+	if persisted != nil {
+		message.Offset = persisted.id
+	}
+	// the packet is encoded locally first, so that a packet which cannot be encoded is not sent to the other servers
+	if err := c.adapter.broadcast(packet, opts); err != nil {
+		if persisted != nil {
+			c.sessions.removePacket(persisted)
+		}
+		return err
+	}
+	if !isLocalBroadcast(opts) {
+		if err := c.publish(message); err != nil {
+			cluster_adapter_log.Debug("error while broadcasting message: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// Broadcasts a packet and expects multiple acknowledgements.
+//
+// Options:
+//   - `Flags` {*BroadcastFlags} flags for this packet
+//   - `Except` {*types.Set[Room]} sids that should be excluded
+//   - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
+func (c *ClusterAdapter) BroadcastWithAck(packet *parser.Packet, opts *BroadcastOptions, clientsCallback func(*BroadcastClients), ack func(*BroadcastAck)) error {
+	packet.Nsp = c.nsp.Name()
+	data, err := encodeClusterData(packet.Data)
+	if err != nil {
+		return &parser.EncodeError{Packet: packet, Err: err}
+	}
+	packet.Data = data
+
+	// copied before the packet is encoded, since the binary packets are modified by the encoder
+	clusterPacket := encodeClusterPacket(packet)
+	// the packet is encoded locally first, so that a packet which cannot be encoded is not sent to the other servers
+	if err := c.adapter.BroadcastWithAck(packet, opts, func(clients *BroadcastClients) {
+		clients.ServerId = c.uid
+		clientsCallback(clients)
+	}, ack); err != nil {
+		return err
+	}
+	if !isLocalBroadcast(opts) {
+		requestId, _ := utils.Base64Id().GenerateId()
+		c.ackRequests.Store(requestId, &clusterAckRequest{
+			clientsCallback: clientsCallback,
+			ack:             ack,
+		})
+		c.publish(&clusterMessage{
+			Type:      BROADCAST,
+			RequestId: requestId,
+			Packet:    clusterPacket,
+			Opts:      encodeClusterOptions(opts),
+		})
+		// we have no way to know at this level whether the server has received an acknowledgement from each client, so we
+		// will simply clean up the ackRequests map after the given delay
+		utils.SetTimeOut(func() {
+			c.ackRequests.Delete(requestId)
+		}, c.timeout(opts))
+	}
+	return nil
+}
+
+// Returns the matching socket instances, including the ones connected to the other servers of the cluster.
+func (c *ClusterAdapter) FetchSockets(opts *BroadcastOptions) []any {
+	localSockets := c.adapter.FetchSockets(opts)
+	if isLocalBroadcast(opts) || c.ServerCount() <= 1 {
+		return localSockets
+	}
+
+	requestId, _ := utils.Base64Id().GenerateId()
+	result := make(chan []any, 1)
+	c.addRequest(requestId, localSockets, c.timeout(opts), func(err error, sockets []any) {
+		if err != nil {
+			cluster_adapter_log.Error("%v", err)
+		}
+		result <- sockets
+	})
+	c.publish(&clusterMessage{
+		Type:      FETCH_SOCKETS,
+		RequestId: requestId,
+		Opts:      encodeClusterOptions(opts),
+	})
+	return <-result
+}
+
+// Makes the matching socket instances join the specified rooms
+func (c *ClusterAdapter) AddSockets(opts *BroadcastOptions, rooms []Room) {
+	if !isLocalBroadcast(opts) {
+		c.publish(&clusterMessage{
+			Type:  SOCKETS_JOIN,
+			Opts:  encodeClusterOptions(opts),
+			Rooms: rooms,
+		})
+	}
+	c.adapter.AddSockets(opts, rooms)
+}
+
+// Makes the matching socket instances leave the specified rooms
+func (c *ClusterAdapter) DelSockets(opts *BroadcastOptions, rooms []Room) {
+	if !isLocalBroadcast(opts) {
+		c.publish(&clusterMessage{
+			Type:  SOCKETS_LEAVE,
+			Opts:  encodeClusterOptions(opts),
+			Rooms: rooms,
+		})
+	}
+	c.adapter.DelSockets(opts, rooms)
+}
+
+// Makes the matching socket instances disconnect
+func (c *ClusterAdapter) DisconnectSockets(opts *BroadcastOptions, status bool) {
+	if !isLocalBroadcast(opts) {
+		c.publish(&clusterMessage{
+			Type:  DISCONNECT_SOCKETS,
+			Opts:  encodeClusterOptions(opts),
+			Close: status,
+		})
+	}
+	c.adapter.DisconnectSockets(opts, status)
+}
+
+// Send a packet to the other Socket.IO servers in the cluster
+//
+// When the last argument is a func(error, []any), it is called with the responses of the other servers, or with an
+// error if some of them did not respond in time.
+func (c *ClusterAdapter) ServerSideEmit(ev string, args ...any) error {
+	data := append([]any{ev}, args...)
+	ack, withAck := data[len(data)-1].(func(error, []any))
+	if withAck {
+		data = data[:len(data)-1]
+	}
+	encoded, err := encodeClusterData(data)
+	if err != nil {
+		return err
+	}
+	if !withAck {
+		return c.publish(&clusterMessage{
+			Type: SERVER_SIDE_EMIT,
+			Args: encoded.([]any),
+		})
+	}
+
+	if c.ServerCount() <= 1 {
+		ack(nil, []any{})
+		return nil
+	}
+	requestId, _ := utils.Base64Id().GenerateId()
+	c.addRequest(requestId, []any{}, c.opts.RequestsTimeout(), ack)
+	return c.publish(&clusterMessage{
+		Type:      SERVER_SIDE_EMIT,
+		RequestId: requestId,
+		Args:      encoded.([]any),
+	})
+}
+
+// Called with a message published by another server.
+func (c *ClusterAdapter) onmessage(data []byte) {
+	message := &clusterMessage{}
+	if err := decodeClusterMessage(data, message); err != nil {
+		cluster_adapter_log.Debug("ignore malformed message: %v", err)
+		return
+	}
+	if message.Uid == c.uid {
+		cluster_adapter_log.Debug("ignore message from self")
+		return
+	}
+	// we track the UID of each sender, in order to know how many servers there are in the cluster
+	c.nodes.Store(message.Uid, time.Now().UnixMilli())
+
+	cluster_adapter_log.Debug("new event of type %d from %s", message.Type, message.Uid)
+
+	switch message.Type {
+	case INITIAL_HEARTBEAT:
+		c.publish(&clusterMessage{Type: HEARTBEAT})
+
+	case HEARTBEAT:
+		// nothing to do
+
+	case ADAPTER_CLOSE:
+		c.removeNode(message.Uid)
+
+	case BROADCAST:
+		packet := decodeClusterPacket(message.Packet)
+		if packet == nil {
+			return
+		}
+		if message.RequestId == "" {
+			opts := decodeClusterOptions(message.Opts)
+			if c.sessions != nil {
+				c.sessions.persistPacket(packet, opts, message.Offset)
+			} else if data, ok := packet.Data.([]any); ok && message.Offset != "" && len(data) > 0 {
+				// the offset is only useful to the servers with the connection state recovery
+				packet.Data = data[:len(data)-1]
+			}
+			c.adapter.broadcast(packet, opts)
+			return
+		}
+		c.adapter.BroadcastWithAck(packet, decodeClusterOptions(message.Opts), func(clients *BroadcastClients) {
+			cluster_adapter_log.Debug("waiting for %d client acknowledgements", clients.Count)
+			c.publishResponse(message.Uid, &clusterMessage{
+				Type:        BROADCAST_CLIENT_COUNT,
+				RequestId:   message.RequestId,
+				ClientCount: clients.Count,
+				Sids:        clients.Sids,
+			})
+		}, func(clientAck *BroadcastAck) {
+			cluster_adapter_log.Debug("received acknowledgement with value %v", clientAck.Args)
+			response := &clusterMessage{
+				Type:      BROADCAST_ACK,
+				RequestId: message.RequestId,
+				Sid:       clientAck.Sid,
+			}
+			if clientAck.Err != nil {
+				response.DisconnectReason = disconnectReason(clientAck.Err)
+			} else if args, err := encodeClusterData(clientAck.Args); err == nil {
+				response.Args = args.([]any)
+			}
+			c.publishResponse(message.Uid, response)
+		})
+
+	case SOCKETS_JOIN:
+		c.adapter.AddSockets(decodeClusterOptions(message.Opts), message.Rooms)
+
+	case SOCKETS_LEAVE:
+		c.adapter.DelSockets(decodeClusterOptions(message.Opts), message.Rooms)
+
+	case DISCONNECT_SOCKETS:
+		c.adapter.DisconnectSockets(decodeClusterOptions(message.Opts), message.Close)
+
+	case FETCH_SOCKETS:
+		localSockets := c.adapter.FetchSockets(decodeClusterOptions(message.Opts))
+		sockets := make([]*clusterSocket, 0, len(localSockets))
+		for _, socket := range localSockets {
+			if details, ok := socket.(SocketDetails); ok {
+				sockets = append(sockets, encodeClusterSocket(details))
+			}
+		}
+		c.publishResponse(message.Uid, &clusterMessage{
+			Type:      FETCH_SOCKETS_RESPONSE,
+			RequestId: message.RequestId,
+			Sockets:   sockets,
+		})
+
+	case SERVER_SIDE_EMIT:
+		args, _ := decodeClusterData(message.Args).([]any)
+		if len(args) == 0 {
+			return
+		}
+		ev, _ := args[0].(string)
+		args = args[1:]
+		if message.RequestId != "" {
+			var once sync.Once
+			args = append(args, func(args ...any) {
+				once.Do(func() {
+					response := &clusterMessage{
+						Type:      SERVER_SIDE_EMIT_RESPONSE,
+						RequestId: message.RequestId,
+					}
+					if args, err := encodeClusterData(args); err == nil {
+						response.Args = args.([]any)
+					} else {
+						cluster_adapter_log.Debug("cannot encode the response: %v", err)
+					}
+					c.publishResponse(message.Uid, response)
+				})
+			})
+		}
+		// the listeners may wait for the responses of other requests, which are received by this very handler
+		go c.nsp.EmitUntyped(ev, args...)
+
+	case BROADCAST_CLIENT_COUNT:
+		if request, ok := c.ackRequests.Load(message.RequestId); ok {
+			request.(*clusterAckRequest).clientsCallback(&BroadcastClients{
+				ServerId: message.Uid,
+				Count:    message.ClientCount,
+				Sids:     message.Sids,
+			})
+		}
+
+	case BROADCAST_ACK:
+		if request, ok := c.ackRequests.Load(message.RequestId); ok {
+			clientAck := &BroadcastAck{Sid: message.Sid}
+			if message.DisconnectReason != "" {
+				clientAck.Err = &DisconnectedError{Reason: message.DisconnectReason}
+			} else {
+				clientAck.Args, _ = decodeClusterData(message.Args).([]any)
+			}
+			request.(*clusterAckRequest).ack(clientAck)
+		}
+
+	case FETCH_SOCKETS_RESPONSE:
+		sockets := make([]any, 0, len(message.Sockets))
+		for _, socket := range message.Sockets {
+			if socket != nil {
+				sockets = append(sockets, socket)
+			}
+		}
+		c.onresponse(message.RequestId, message.Uid, sockets)
+
+	case SERVER_SIDE_EMIT_RESPONSE:
+		args, _ := decodeClusterData(message.Args).([]any)
+		// one response per server: the single argument of the acknowledgement, or all of them
+		var response any
+		switch len(args) {
+		case 0:
+		case 1:
+			response = args[0]
+		default:
+			response = args
+		}
+		c.onresponse(message.RequestId, message.Uid, []any{response})
+
+	default:
+		cluster_adapter_log.Debug("ignoring unknown message type: %d", message.Type)
+	}
+}
+
+// Registers a request which waits for a response from each known server.
+func (c *ClusterAdapter) addRequest(requestId string, responses []any, timeout time.Duration, resolve func(error, []any)) {
+	request := &clusterRequest{
+		missingUids: types.NewSet[ServerId](),
+		responses:   responses,
+		resolve:     resolve,
+	}
+	c.nodes.Range(func(uid any, _ any) bool {
+		request.missingUids.Add(uid.(ServerId))
+		return true
+	})
+	request.expected = request.missingUids.Len()
+	c.requests.Store(requestId, request)
+
+	request.mu.Lock()
+	request.timeout = utils.SetTimeOut(func() {
+		if _, ok := c.requests.LoadAndDelete(requestId); ok {
+			request.mu.Lock()
+			err := errors.New(fmt.Sprintf("timeout reached: only %d responses received out of %d", request.expected-request.missingUids.Len(), request.expected))
+			responses := request.responses
+			request.mu.Unlock()
+
+			request.resolve(err, responses)
+		}
+	}, timeout)
+	complete := request.missingUids.Len() == 0
+	request.mu.Unlock()
+
+	if complete {
+		c.completeRequest(requestId, request)
+	}
+}
+
+// Called with the response of a server to a request.
+func (c *ClusterAdapter) onresponse(requestId string, uid ServerId, responses []any) {
+	_request, ok := c.requests.Load(requestId)
+	if !ok {
+		return
+	}
+	request := _request.(*clusterRequest)
+
+	request.mu.Lock()
+	request.responses = append(request.responses, responses...)
+	request.missingUids.Delete(uid)
+	complete := request.missingUids.Len() == 0
+	request.mu.Unlock()
+
+	if complete {
+		c.completeRequest(requestId, request)
+	}
+}
+
+func (c *ClusterAdapter) completeRequest(requestId string, request *clusterRequest) {
+	if _, ok := c.requests.LoadAndDelete(requestId); !ok {
+		return
+	}
+
+	request.mu.Lock()
+	utils.ClearTimeout(request.timeout)
+	responses := request.responses
+	request.mu.Unlock()
+
+	request.resolve(nil, responses)
+}
+
+// Forgets a server which is down or closed, so that the pending requests do not wait for its response.
+func (c *ClusterAdapter) removeNode(uid ServerId) {
+	c.requests.Range(func(requestId any, _request any) bool {
+		request := _request.(*clusterRequest)
+		request.mu.Lock()
+		request.missingUids.Delete(uid)
+		complete := request.missingUids.Len() == 0
+		request.mu.Unlock()
+		if complete {
+			c.completeRequest(requestId.(string), request)
+		}
+		return true
+	})
+	c.nodes.Delete(uid)
+}
+
+// Sends a heartbeat if no message was published during the heartbeat interval.
+func (c *ClusterAdapter) scheduleHeartbeat() {
+	c.mu_heartbeatTimer.Lock()
+	defer c.mu_heartbeatTimer.Unlock()
+
+	if c.closed {
+		return
+	}
+	utils.ClearTimeout(c.heartbeatTimer)
+	c.heartbeatTimer = utils.SetTimeOut(func() {
+		c.publish(&clusterMessage{Type: HEARTBEAT})
+	}, c.opts.HeartbeatInterval())
+}
+
+func (c *ClusterAdapter) publish(message *clusterMessage) error {
+	c.scheduleHeartbeat()
+
+	message.Uid = c.uid
+	message.Nsp = c.nsp.Name()
+	data, err := encodeClusterMessage(message)
+	if err != nil {
+		return err
+	}
+	return c.transport.Publish(c.channel, data)
+}
+
+func (c *ClusterAdapter) publishResponse(requesterUid ServerId, response *clusterMessage) {
+	response.Uid = c.uid
+	response.Nsp = c.nsp.Name()
+	data, err := encodeClusterMessage(response)
+	if err == nil {
+		err = c.transport.Publish(c.channel+string(requesterUid)+"#", data)
+	}
+	if err != nil {
+		cluster_adapter_log.Debug("error while publishing response: %v", err)
+	}
+}
+
+func (c *ClusterAdapter) timeout(opts *BroadcastOptions) time.Duration {
+	if opts != nil && opts.Flags != nil && opts.Flags.Timeout != nil {
+		return *opts.Flags.Timeout
+	}
+	return c.opts.RequestsTimeout()
+}
+
+func isLocalBroadcast(opts *BroadcastOptions) bool {
+	return opts != nil && opts.Flags != nil && opts.Flags.Local
+}
+
+func encodeClusterMessage(message *clusterMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(message); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeClusterMessage(data []byte, message *clusterMessage) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(message)
+}
+
+func encodeClusterPacket(packet *parser.Packet) *clusterPacket {
+	return &clusterPacket{
+		Type: packet.Type,
+		Nsp:  packet.Nsp,
+		Data: packet.Data,
+		Id:   packet.Id,
+	}
+}
+
+func decodeClusterPacket(packet *clusterPacket) *parser.Packet {
+	if packet == nil || !packet.Type.Valid() {
+		return nil
+	}
+	return &parser.Packet{
+		Type: packet.Type,
+		Nsp:  packet.Nsp,
+		Data: decodeClusterData(packet.Data),
+		Id:   packet.Id,
+	}
+}
+
+func encodeClusterOptions(opts *BroadcastOptions) *clusterBroadcastOptions {
+	o := &clusterBroadcastOptions{}
+	if opts == nil {
+		return o
+	}
+	if opts.Rooms != nil {
+		o.Rooms = opts.Rooms.Keys()
+	}
+	if opts.Except != nil {
+		o.Except = opts.Except.Keys()
+	}
+	if opts.Flags != nil {
+		o.Flags = &clusterBroadcastFlags{
+			Volatile: opts.Flags.Volatile,
+			Compress: opts.Flags.Compress,
+		}
+		if opts.Flags.Timeout != nil {
+			timeout := opts.Flags.Timeout.Milliseconds()
+			o.Flags.Timeout = &timeout
+		}
+	}
+	return o
+}
+
+func decodeClusterOptions(o *clusterBroadcastOptions) *BroadcastOptions {
+	opts := &BroadcastOptions{
+		Rooms:  types.NewSet[Room](),
+		Except: types.NewSet[Room](),
+		Flags:  &BroadcastFlags{},
+	}
+	if o == nil {
+		return opts
+	}
+	opts.Rooms.Add(o.Rooms...)
+	opts.Except.Add(o.Except...)
+	if o.Flags != nil {
+		opts.Flags.Volatile = o.Flags.Volatile
+		opts.Flags.Compress = o.Flags.Compress
+		if o.Flags.Timeout != nil {
+			timeout := time.Duration(*o.Flags.Timeout) * time.Millisecond
+			opts.Flags.Timeout = &timeout
+		}
+	}
+	return opts
+}
+
+func encodeClusterSocket(socket SocketDetails) *clusterSocket {
+	s := &clusterSocket{
+		SocketId:    socket.Id(),
+		SocketRooms: socket.Rooms().Keys(),
+	}
+	s.SocketData, _ = encodeClusterData(socket.Data())
+	if handshake := socket.Handshake(); handshake != nil {
+		s.SocketHandshake = &clusterHandshake{
+			Time:    handshake.Time,
+			Address: handshake.Address,
+			Xdomain: handshake.Xdomain,
+			Secure:  handshake.Secure,
+			Issued:  handshake.Issued,
+			Url:     handshake.Url,
+		}
+		s.SocketHandshake.Auth, _ = encodeClusterData(handshake.Auth)
+		if handshake.Headers != nil {
+			s.SocketHandshake.Headers = handshake.Headers.All()
+		}
+		if handshake.Query != nil {
+			s.SocketHandshake.Query = handshake.Query.All()
+		}
+	}
+	return s
+}
+
+// Reads the binary attachments, so that the data can be both serialized and encoded by the local encoder. parser.ErrCycle is
+// returned if the data holds a cycle, which MessagePack does not detect.
+func encodeClusterData(data any) (any, error) {
+	if err := parser.CheckCycles(data); err != nil {
+		return nil, err
+	}
+	return _encodeClusterData(data), nil
+}
+
+func _encodeClusterData(data any) any {
+	switch tdata := data.(type) {
+	case nil:
+		return nil
+	case json.Number:
+		// would be encoded as a MessagePack str otherwise
+		return parser.ConvertNumber(tdata)
+	case *types.StringBuffer:
+		return tdata.String()
+	case *strings.Reader:
+		rdata, _ := types.NewStringBufferReader(tdata)
+		return rdata.String()
+	case []byte:
+		return tdata
+	case io.Reader:
+		if c, ok := tdata.(io.Closer); ok {
+			defer c.Close()
+		}
+		rdata := types.NewBytesBuffer(nil)
+		rdata.ReadFrom(tdata)
+		return rdata.Bytes()
+	case []any:
+		newData := make([]any, 0, len(tdata))
+		for _, v := range tdata {
+			newData = append(newData, _encodeClusterData(v))
+		}
+		return newData
+	case map[string]any:
+		newData := map[string]any{}
+		for k, v := range tdata {
+			newData[k] = _encodeClusterData(v)
+		}
+		return newData
+	}
+	return data
+}
+
+// Normalizes the numbers decoded by the MessagePack decoder.
+func decodeClusterData(data any) any {
+	switch tdata := data.(type) {
+	case int8:
+		return int64(tdata)
+	case int16:
+		return int64(tdata)
+	case int32:
+		return int64(tdata)
+	case uint8:
+		return uint64(tdata)
+	case uint16:
+		return uint64(tdata)
+	case uint32:
+		return uint64(tdata)
+	case float32:
+		return float64(tdata)
+	case []any:
+		newData := make([]any, 0, len(tdata))
+		for _, v := range tdata {
+			newData = append(newData, decodeClusterData(v))
+		}
+		return newData
+	case map[string]any:
+		newData := map[string]any{}
+		for k, v := range tdata {
+			newData[k] = decodeClusterData(v)
+		}
+		return newData
+	}
+	return data
+}
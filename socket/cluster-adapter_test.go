@@ -0,0 +1,380 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/socket.io/parser"
+)
+
+func newClusterServer(transport ClusterTransport, adapterOpts *ClusterAdapterOptions, recovery bool) *Server {
+	opts := DefaultServerOptions()
+	opts.SetAdapter(NewClusterAdapter(transport, adapterOpts))
+	if recovery {
+		opts.SetConnectionStateRecovery(DefaultConnectionStateRecovery())
+	}
+	return NewServer(nil, opts)
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); !condition(); {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout reached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+type recordingEncoder struct {
+	parser.Encoder
+
+	packets chan []any
+}
+
+func (r *recordingEncoder) Encode(packet *parser.Packet) ([]types.BufferInterface, error) {
+	r.packets <- packet.Data.([]any)
+	return r.Encoder.Encode(packet)
+}
+
+func TestClusterAdapterRecovery(t *testing.T) {
+	transport := NewChannelTransport()
+	io1 := newClusterServer(transport, nil, true)
+	io2 := newClusterServer(transport, nil, true)
+	a1 := io1.Sockets().Adapter().(*ClusterAdapter)
+	a2 := io2.Sockets().Adapter().(*ClusterAdapter)
+	defer a1.Close()
+	defer a2.Close()
+
+	packets := func(a *ClusterAdapter) []*persistedPacket {
+		a.sessions.mu_packets.RLock()
+		defer a.sessions.mu_packets.RUnlock()
+		return append([]*persistedPacket{}, a.sessions.packets...)
+	}
+
+	a1.PersistSession(&SessionToPersist{Sid: "sid", Pid: "pid", Rooms: types.NewSet[Room]()})
+	io1.Sockets().Emit("first")
+	waitFor(t, func() bool { return len(packets(a1)) == 1 && len(packets(a2)) == 1 })
+	io2.Sockets().Emit("second", 1)
+	waitFor(t, func() bool { return len(packets(a1)) == 2 && len(packets(a2)) == 2 })
+
+	for i := range packets(a1) {
+		if packets(a1)[i].id != packets(a2)[i].id {
+			t.Fatalf("the servers stored different offsets: %s, %s", packets(a1)[i].id, packets(a2)[i].id)
+		}
+	}
+
+	session, err := a1.RestoreSession("pid", packets(a1)[0].id)
+	if err != nil || session == nil {
+		t.Fatalf("expected the session to be restored, got %v, %v", session, err)
+	}
+	if len(session.MissedPackets) != 1 {
+		t.Fatalf("expected 1 missed packet, got %v", session.MissedPackets)
+	}
+	missed := session.MissedPackets[0]
+	if len(missed) != 3 || missed[0] != "second" || missed[2] != packets(a1)[1].id {
+		t.Fatalf("unexpected missed packet: %v", missed)
+	}
+}
+
+func TestClusterAdapterWithoutRecovery(t *testing.T) {
+	transport := NewChannelTransport()
+	io1 := newClusterServer(transport, nil, true)
+	io2 := newClusterServer(transport, nil, false)
+	a1 := io1.Sockets().Adapter().(*ClusterAdapter)
+	a2 := io2.Sockets().Adapter().(*ClusterAdapter)
+	defer a1.Close()
+	defer a2.Close()
+
+	received := make(chan []any, 1)
+	a2.adapter.encoder = &recordingEncoder{Encoder: a2.adapter.encoder, packets: received}
+	io1.Sockets().Emit("event", 1)
+
+	select {
+	case data := <-received:
+		if len(data) != 2 || data[0] != "event" {
+			t.Fatalf("expected the offset to be removed, got %v", data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the packet was not received")
+	}
+	if session, _ := a2.RestoreSession("pid", ""); session != nil {
+		t.Fatalf("expected no session, got %v", session)
+	}
+}
+
+// A transport which can stop publishing the messages of a server, as if it had crashed.
+type droppingTransport struct {
+	ClusterTransport
+
+	dropped atomic.Value
+}
+
+func (d *droppingTransport) Publish(channel string, message []byte) error {
+	if dropped, _ := d.dropped.Load().(bool); dropped {
+		return nil
+	}
+	return d.ClusterTransport.Publish(channel, message)
+}
+
+// Records the messages published by a server.
+type recordingTransport struct {
+	ClusterTransport
+
+	published chan *clusterMessage
+}
+
+func (r *recordingTransport) Publish(channel string, message []byte) error {
+	decoded := &clusterMessage{}
+	if err := decodeClusterMessage(message, decoded); err == nil {
+		r.published <- decoded
+	}
+	return r.ClusterTransport.Publish(channel, message)
+}
+
+func newCluster(t *testing.T, transports []ClusterTransport, opts *ClusterAdapterOptions) []*Server {
+	t.Helper()
+
+	servers := []*Server{}
+	for _, transport := range transports {
+		servers = append(servers, newClusterServer(transport, opts, false))
+	}
+	t.Cleanup(func() {
+		for _, server := range servers {
+			server.Sockets().Adapter().Close()
+		}
+	})
+	waitFor(t, func() bool {
+		for _, server := range servers {
+			if server.Sockets().Adapter().ServerCount() != int64(len(servers)) {
+				return false
+			}
+		}
+		return true
+	})
+	return servers
+}
+
+func TestClusterAdapterServerSideEmit(t *testing.T) {
+	transport := NewChannelTransport()
+	servers := newCluster(t, []ClusterTransport{transport, transport, transport}, nil)
+
+	events := make(chan []any, 2)
+	for _, server := range servers[1:] {
+		server.On("hello", func(args ...any) {
+			events <- args
+		})
+		server.On("count", func(args ...any) {
+			args[len(args)-1].(func(...any))(1)
+		})
+	}
+	if err := servers[0].ServerSideEmit("hello", "world"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case args := <-events:
+			if len(args) != 1 || args[0] != "world" {
+				t.Fatalf("unexpected event %v", args)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("the event was not received")
+		}
+	}
+
+	result := make(chan []any, 1)
+	servers[0].ServerSideEmitWithAck("count", func(err error, responses []any) {
+		if err != nil {
+			t.Error(err)
+		}
+		result <- responses
+	})
+	select {
+	case responses := <-result:
+		if len(responses) != 2 {
+			t.Fatalf("expected 2 responses, got %v", responses)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the request was not resolved")
+	}
+}
+
+func TestClusterAdapterServerSideEmitWithAckNamespace(t *testing.T) {
+	transport := NewChannelTransport()
+	servers := newCluster(t, []ClusterTransport{transport, transport, transport}, nil)
+	for _, server := range servers {
+		server.Of("/custom", nil)
+	}
+	for _, server := range servers {
+		waitFor(t, func() bool {
+			return server.Of("/custom", nil).Adapter().ServerCount() == 3
+		})
+	}
+
+	servers[0].Of("/custom", nil).On("double", func(...any) {
+		t.Error("the event was received by the emitting server")
+	})
+	for _, server := range servers[1:] {
+		server.Of("/custom", nil).On("double", func(args ...any) {
+			args[len(args)-1].(func(...any))(args[0].(int64) * 2)
+		})
+		// the other namespaces do not receive the event
+		server.On("double", func(...any) {
+			t.Error("the event was received by the main namespace")
+		})
+	}
+
+	result := make(chan []any, 1)
+	err := servers[0].Of("/custom", nil).ServerSideEmitWithAck("double", 21, func(err error, responses []any) {
+		if err != nil {
+			t.Error(err)
+		}
+		result <- responses
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case responses := <-result:
+		if len(responses) != 2 || responses[0] != int64(42) || responses[1] != int64(42) {
+			t.Fatalf("expected two responses of 42, got %v", responses)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the request was not resolved")
+	}
+}
+
+func TestClusterAdapterRequestTimeout(t *testing.T) {
+	opts := DefaultClusterAdapterOptions()
+	opts.SetRequestsTimeout(200 * time.Millisecond)
+	transport := NewChannelTransport()
+	servers := newCluster(t, []ClusterTransport{transport, transport, transport}, opts)
+
+	servers[1].On("count", func(args ...any) {
+		args[len(args)-1].(func(...any))(1)
+	})
+	// the third server does not answer
+	servers[2].On("count", func(...any) {})
+
+	type result struct {
+		err       error
+		responses []any
+	}
+	results := make(chan result, 1)
+	start := time.Now()
+	servers[0].ServerSideEmitWithAck("count", func(err error, responses []any) {
+		results <- result{err, responses}
+	})
+	select {
+	case r := <-results:
+		if r.err == nil || !strings.Contains(r.err.Error(), "only 1 responses received out of 2") {
+			t.Fatalf("expected a timeout error, got %v", r.err)
+		}
+		if len(r.responses) != 1 {
+			t.Fatalf("expected the response of the second server, got %v", r.responses)
+		}
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Fatalf("the request was resolved before the timeout, after %v", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the request was not resolved")
+	}
+
+	fetched := make(chan []any, 1)
+	go func() {
+		fetched <- servers[0].Sockets().Adapter().FetchSockets(&BroadcastOptions{
+			Rooms:  types.NewSet[Room](),
+			Except: types.NewSet[Room](),
+		})
+	}()
+	select {
+	case sockets := <-fetched:
+		if len(sockets) != 0 {
+			t.Fatalf("expected no socket, got %v", sockets)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("FetchSockets() did not return")
+	}
+}
+
+func TestClusterAdapterNodeExpiry(t *testing.T) {
+	opts := DefaultClusterAdapterOptions()
+	opts.SetHeartbeatInterval(100 * time.Millisecond)
+	opts.SetHeartbeatTimeout(300 * time.Millisecond)
+	opts.SetRequestsTimeout(10 * time.Second)
+	transport := NewChannelTransport()
+	crashing := &droppingTransport{ClusterTransport: transport}
+	servers := newCluster(t, []ClusterTransport{transport, transport, crashing}, opts)
+
+	servers[1].On("count", func(args ...any) {
+		args[len(args)-1].(func(...any))(1)
+	})
+	// the heartbeats keep the nodes alive
+	time.Sleep(500 * time.Millisecond)
+	if count := servers[0].Sockets().Adapter().ServerCount(); count != 3 {
+		t.Fatalf("expected 3 servers, got %d", count)
+	}
+
+	crashing.dropped.Store(true)
+	results := make(chan error, 1)
+	servers[0].ServerSideEmitWithAck("count", func(err error, responses []any) {
+		if len(responses) != 1 {
+			t.Errorf("expected the response of the second server, got %v", responses)
+		}
+		results <- err
+	})
+
+	// the pending request is completed once the silent node is removed
+	select {
+	case err := <-results:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("the request was not completed")
+	}
+	// each server removes the node on its own schedule
+	waitFor(t, func() bool {
+		return servers[0].Sockets().Adapter().ServerCount() == 2 && servers[1].Sockets().Adapter().ServerCount() == 2
+	})
+}
+
+func TestClusterAdapterEncodeError(t *testing.T) {
+	transport := NewChannelTransport()
+	recording := &recordingTransport{ClusterTransport: transport, published: make(chan *clusterMessage, 100)}
+	servers := newCluster(t, []ClusterTransport{recording, transport}, nil)
+
+	var encodeErr *parser.EncodeError
+	if err := servers[0].Sockets().Emit("ev", make(chan int)); !errors.As(err, &encodeErr) {
+		t.Fatalf("expected an *EncodeError, got %v", err)
+	}
+	if _, err := servers[0].To("room").EmitWithAck(context.Background(), "ev", math.Inf(1)); !errors.As(err, &encodeErr) {
+		t.Fatalf("expected an *EncodeError, got %v", err)
+	}
+	if err := servers[0].Sockets().Emit("ev", "ok"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the packets which cannot be encoded are not sent to the other servers
+	for {
+		select {
+		case message := <-recording.published:
+			if message.Type != BROADCAST {
+				continue
+			}
+			if !reflect.DeepEqual(message.Packet.Data, []any{"ev", "ok"}) {
+				t.Fatalf("unexpected broadcast %v", message.Packet.Data)
+			}
+			return
+		case <-time.After(2 * time.Second):
+			t.Fatal("the broadcast was not published")
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package socket
+
+import (
+	"sync"
+
+	"github.com/zishang520/engine.io/types"
+)
+
+type channelSubscription struct {
+	handler func([]byte)
+	queue   [][]byte
+	signal  chan struct{}
+	closed  bool
+
+	mu sync.Mutex
+}
+
+func (s *channelSubscription) push(message []byte) {
+	s.mu.Lock()
+	s.queue = append(s.queue, message)
+	s.mu.Unlock()
+
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (s *channelSubscription) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Delivers the messages in order, without blocking the publisher.
+func (s *channelSubscription) run() {
+	for range s.signal {
+		for {
+			s.mu.Lock()
+			if s.closed {
+				s.mu.Unlock()
+				return
+			}
+			if len(s.queue) == 0 {
+				s.mu.Unlock()
+				break
+			}
+			message := s.queue[0]
+			s.queue = s.queue[1:]
+			s.mu.Unlock()
+
+			s.handler(message)
+		}
+	}
+}
+
+// A ClusterTransport which delivers the messages through Go channels, so that several Socket.IO servers of the same
+// process can form a cluster (for example in tests).
+//
+// <pre><code>
+//
+//	transport := socket.NewChannelTransport()
+//
+//	opts := socket.DefaultServerOptions()
+//	opts.SetAdapter(socket.NewClusterAdapter(transport, nil))
+//	io1 := socket.NewServer(nil, opts)
+//	io2 := socket.NewServer(nil, opts)
+//
+// </pre></code>
+type ChannelTransport struct {
+	subscriptions map[string]*types.Set[*channelSubscription]
+
+	mu sync.RWMutex
+}
+
+func NewChannelTransport() *ChannelTransport {
+	c := &ChannelTransport{}
+	c.subscriptions = map[string]*types.Set[*channelSubscription]{}
+
+	return c
+}
+
+// Sends a message to every subscriber of the channel.
+func (c *ChannelTransport) Publish(channel string, message []byte) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if subscriptions, ok := c.subscriptions[channel]; ok {
+		for _, subscription := range subscriptions.Keys() {
+			subscription.push(message)
+		}
+	}
+	return nil
+}
+
+// Calls the handler for each message published on the channel, until the returned function is called.
+func (c *ChannelTransport) Subscribe(channel string, handler func([]byte)) (func(), error) {
+	subscription := &channelSubscription{
+		handler: handler,
+		signal:  make(chan struct{}, 1),
+	}
+	go subscription.run()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.subscriptions[channel]; !ok {
+		c.subscriptions[channel] = types.NewSet[*channelSubscription]()
+	}
+	c.subscriptions[channel].Add(subscription)
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if subscriptions, ok := c.subscriptions[channel]; ok {
+			subscriptions.Delete(subscription)
+			if subscriptions.Len() == 0 {
+				delete(c.subscriptions, channel)
+			}
+		}
+		subscription.close()
+	}, nil
+}
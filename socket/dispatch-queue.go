@@ -0,0 +1,150 @@
+package socket
+
+import (
+	"sync"
+)
+
+// Runs the packets received by a socket in the order they were sent, with at most `concurrency()` of them handled at
+// the same time (no limit when it is lower than 1). A task calls `done` once it is handled, possibly from another
+// goroutine.
+//
+// The goroutines are only started when the queue is busy, instead of one per packet.
+type dispatchQueue struct {
+	tasks       []*dispatchTask
+	running     int
+	paused      bool
+	concurrency func() int
+
+	mu sync.Mutex
+}
+
+type dispatchTask struct {
+	run func(done func())
+	// started without waiting for a free worker
+	inline bool
+}
+
+func newDispatchQueue(concurrency func() int) *dispatchQueue {
+	return &dispatchQueue{
+		concurrency: concurrency,
+		paused:      true,
+	}
+}
+
+// Adds a task, which is started once the previous ones have been started (and the queue is resumed).
+func (q *dispatchQueue) push(task func(done func())) {
+	q.mu.Lock()
+	q.tasks = append(q.tasks, &dispatchTask{run: task})
+	workers := q.reserveWorkers(1)
+	q.mu.Unlock()
+
+	if workers > 0 {
+		go q.work()
+	}
+}
+
+// Adds a task, which is started once the previous ones have been started (and the queue is resumed), without waiting
+// for them to be done. Meant for the short tasks which the running ones may wait for, like the acknowledgements.
+func (q *dispatchQueue) pushInline(task func()) {
+	q.mu.Lock()
+	q.tasks = append(q.tasks, &dispatchTask{run: func(func()) { task() }, inline: true})
+	inline := q.takeInline()
+	q.mu.Unlock()
+
+	startInline(inline)
+}
+
+// Starts running the tasks, after the socket is connected.
+func (q *dispatchQueue) resume() {
+	q.mu.Lock()
+	q.paused = false
+	inline := q.takeInline()
+	workers := q.reserveWorkers(len(q.tasks))
+	q.mu.Unlock()
+
+	startInline(inline)
+	for ; workers > 0; workers-- {
+		go q.work()
+	}
+}
+
+// Discards the tasks which are not started yet.
+func (q *dispatchQueue) clear() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.tasks = nil
+}
+
+// Whether a task is running or waiting to be started.
+func (q *dispatchQueue) busy() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.running > 0 || len(q.tasks) > 0
+}
+
+// Returns how many of the wanted workers can be started, which are then counted as running. Must be called with the
+// queue locked.
+func (q *dispatchQueue) reserveWorkers(wanted int) int {
+	if q.paused {
+		return 0
+	}
+	if concurrency := q.concurrency(); concurrency > 0 && q.running+wanted > concurrency {
+		wanted = concurrency - q.running
+	}
+	if wanted < 0 {
+		return 0
+	}
+	q.running += wanted
+	return wanted
+}
+
+// Removes the inline tasks at the head of the queue, whose previous tasks have all been started. Must be called with
+// the queue locked.
+func (q *dispatchQueue) takeInline() (inline []*dispatchTask) {
+	if q.paused {
+		return nil
+	}
+	for len(q.tasks) > 0 && q.tasks[0].inline {
+		inline = append(inline, q.tasks[0])
+		q.tasks[0] = nil
+		q.tasks = q.tasks[1:]
+	}
+	return inline
+}
+
+func startInline(inline []*dispatchTask) {
+	for _, task := range inline {
+		go task.run(nil)
+	}
+}
+
+func (q *dispatchQueue) work() {
+	for {
+		q.mu.Lock()
+		inline := q.takeInline()
+		if q.paused || len(q.tasks) == 0 {
+			q.running--
+			q.mu.Unlock()
+			startInline(inline)
+			return
+		}
+		task := q.tasks[0]
+		q.tasks[0] = nil
+		q.tasks = q.tasks[1:]
+		// the inline tasks following the task are not delayed until it is done
+		inline = append(inline, q.takeInline()...)
+		q.mu.Unlock()
+
+		startInline(inline)
+		done := make(chan struct{})
+		var once sync.Once
+		task.run(func() {
+			once.Do(func() {
+				close(done)
+			})
+		})
+		<-done
+	}
+}
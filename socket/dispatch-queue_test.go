@@ -0,0 +1,143 @@
+package socket
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatchQueueOrder(t *testing.T) {
+	q := newDispatchQueue(func() int { return 1 })
+
+	var mu sync.Mutex
+	order := []int{}
+	finished := make(chan struct{})
+	for i := 0; i < 100; i++ {
+		i := i
+		q.push(func(done func()) {
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			// the tasks may complete from another goroutine
+			go func() {
+				done()
+				if i == 99 {
+					close(finished)
+				}
+			}()
+		})
+	}
+	q.resume()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the tasks were not all run")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for i, v := range order {
+		if i != v {
+			t.Fatalf("the tasks were not run in order: %v", order)
+		}
+	}
+}
+
+func TestDispatchQueueUnlimited(t *testing.T) {
+	q := newDispatchQueue(func() int { return 0 })
+	q.resume()
+
+	// without limit, a blocked task does not delay the next ones
+	unblock := make(chan struct{})
+	q.push(func(done func()) {
+		<-unblock
+		done()
+	})
+	ran := make(chan struct{})
+	q.push(func(done func()) {
+		close(ran)
+		done()
+	})
+
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second task was blocked by the first one")
+	}
+	close(unblock)
+}
+
+func TestDispatchQueuePaused(t *testing.T) {
+	q := newDispatchQueue(func() int { return 1 })
+
+	ran := make(chan struct{}, 1)
+	q.push(func(done func()) {
+		ran <- struct{}{}
+		done()
+	})
+	select {
+	case <-ran:
+		t.Fatal("a task was run before the queue was resumed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if !q.busy() {
+		t.Fatal("the queue holds a task")
+	}
+
+	q.resume()
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the task was not run once the queue was resumed")
+	}
+}
+
+func TestDispatchQueueInline(t *testing.T) {
+	q := newDispatchQueue(func() int { return 1 })
+	q.resume()
+
+	// the running task waits for an inline task pushed after it
+	acked := make(chan struct{})
+	finished := make(chan struct{})
+	q.push(func(done func()) {
+		<-acked
+		done()
+		close(finished)
+	})
+	q.pushInline(func() {
+		close(acked)
+	})
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the inline task waited for the running task")
+	}
+
+	// an inline task is not started before the previous tasks
+	unblock := make(chan struct{})
+	q.push(func(done func()) {
+		<-unblock
+		done()
+	})
+	started := make(chan int, 2)
+	q.push(func(done func()) {
+		started <- 1
+		done()
+	})
+	q.pushInline(func() {
+		started <- 2
+	})
+	select {
+	case <-started:
+		t.Fatal("a task was started before the previous ones")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(unblock)
+	for i := 0; i < 2; i++ {
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("the tasks were not run")
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package socket_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zishang520/socket.io/client"
+	"github.com/zishang520/socket.io/socket"
+)
+
+// Starts a server listening on a random port, closed at the end of the test, and returns its address.
+func newTestServer(t *testing.T, opts *socket.ServerOptions) (*socket.Server, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	io := socket.NewServer(listener, opts)
+	t.Cleanup(func() {
+		io.Close(nil)
+	})
+	return io, "http://" + listener.Addr().String()
+}
+
+// Connects a new client to the given url, and waits for the connection.
+func connect(t *testing.T, url string, opts *client.Options) *client.Socket {
+	t.Helper()
+
+	if opts == nil {
+		opts = client.DefaultOptions()
+	}
+	opts.SetForceNew(true)
+	c, err := client.Io(url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		c.Disconnect()
+	})
+	connected := make(chan struct{}, 1)
+	c.Once("connect", func(...any) {
+		connected <- struct{}{}
+	})
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the client did not connect")
+	}
+	return c
+}
+
+// Waits for a value sent to the channel.
+func receive[T any](t *testing.T, values chan T) T {
+	t.Helper()
+
+	select {
+	case value := <-values:
+		return value
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout reached")
+	}
+	var zero T
+	return zero
+}
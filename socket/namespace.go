@@ -1,8 +1,10 @@
 package socket
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/url"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -46,6 +48,9 @@ type Namespace struct {
 	_fns    []func(*Socket, func(*ExtendedError))
 	_ids    uint64
 
+	// how many packets of a socket can be handled at the same time
+	concurrency int32
+
 	_fns_mu sync.RWMutex
 }
 
@@ -69,6 +74,24 @@ func (n *Namespace) Ids() uint64 {
 	return atomic.AddUint64(&n._ids, 1)
 }
 
+// Sets how many packets of a socket can be handled at the same time. With a concurrency of 1 (the default), the events
+// and the acknowledgements are handled one after the other, in the order they were sent by the client. With a
+// concurrency lower than 1, there is no limit.
+//
+// Note: the acknowledgements awaited with EmitWithAck() and the acknowledgements of a broadcast are started once the
+// packets received before them have been started, without waiting for them to be done, so that a listener can wait
+// for them. A listener which waits for the callback acknowledgement of an event of the same socket, or for any
+// acknowledgement while other packets of the socket are waiting for a free slot, blocks the socket until it times out.
+func (n *Namespace) SetConcurrency(concurrency int) NamespaceInterface {
+	atomic.StoreInt32(&n.concurrency, int32(concurrency))
+	return n
+}
+
+// Returns how many packets of a socket can be handled at the same time.
+func (n *Namespace) Concurrency() int {
+	return int(atomic.LoadInt32(&n.concurrency))
+}
+
 func (n *Namespace) EventEmitter() *StrictEventEmitter {
 	return n.StrictEventEmitter
 }
@@ -80,6 +103,7 @@ func NewNamespace(server *Server, name string) *Namespace {
 	n.sockets = &sync.Map{}
 	n._fns = []func(*Socket, func(*ExtendedError)){}
 	atomic.StoreUint64(&n._ids, 0)
+	atomic.StoreInt32(&n.concurrency, 1)
 	n.server = server
 	n.name = name
 	n._initAdapter()
@@ -92,6 +116,7 @@ func NewNamespace(server *Server, name string) *Namespace {
 // in addition to the constructor.
 func (n *Namespace) _initAdapter() {
 	n.adapter = n.server.Adapter().New(n)
+	n.adapter.Init()
 }
 
 // Sets up namespace middleware.
@@ -111,14 +136,31 @@ func (n *Namespace) run(socket *Socket, fn func(err *ExtendedError)) {
 	if length := len(fns); length > 0 {
 		var run func(i int)
 		run = func(i int) {
+			called := int32(0)
+			// a panicking middleware rejects the socket, unless it has already called next(). The panics of the next
+			// middlewares are recovered here too when next() is called from another goroutine.
+			defer func() {
+				if r := recover(); r != nil {
+					err := newPanicError(r)
+					namespace_log.Debug("middleware panicked: %v", err)
+					n.server._onerror(socket, err)
+					if atomic.CompareAndSwapInt32(&called, 0, 1) {
+						go fn(NewExtendedError(panicMessage, nil))
+					}
+				}
+			}()
 			fns[i](socket, func(err *ExtendedError) {
+				if !atomic.CompareAndSwapInt32(&called, 0, 1) {
+					namespace_log.Debug("next called twice by the middleware %d - ignoring", i)
+					return
+				}
 				// upon error, short-circuit
 				if err != nil {
 					go fn(err)
 					return
 				}
 				// if no middleware left, summon callback
-				if i >= length {
+				if i+1 >= length {
 					go fn(nil)
 					return
 				}
@@ -148,9 +190,13 @@ func (n *Namespace) Except(room ...Room) *BroadcastOperator {
 }
 
 // Adds a new client.
-func (n *Namespace) Add(client *Client, query any, fn func(*Socket)) *Socket {
+func (n *Namespace) Add(client *Client, auth any, fn func(*Socket)) *Socket {
 	namespace_log.Debug("adding socket to nsp %s", n.name)
-	socket := NewSocket(n, client, query)
+	socket := n._createSocket(client, auth)
+	if recovery := n.server.opts.ConnectionStateRecovery(); recovery != nil && recovery.SkipMiddlewares() && socket.Recovered() && "open" == client.conn.ReadyState() {
+		n._connect(socket, fn)
+		return socket
+	}
 	n.run(socket, func(err *ExtendedError) {
 		if "open" != client.conn.ReadyState() {
 			namespace_log.Debug("next called after client was closed - ignoring socket")
@@ -159,6 +205,7 @@ func (n *Namespace) Add(client *Client, query any, fn func(*Socket)) *Socket {
 		}
 		if err != nil {
 			namespace_log.Debug("middleware error, sending CONNECT_ERROR packet to the client")
+			n.server.observeMiddlewareError(n.name, err)
 			socket._cleanup()
 			if client.conn.Protocol() == 3 {
 				if e := err.Data(); e != nil {
@@ -175,23 +222,61 @@ func (n *Namespace) Add(client *Client, query any, fn func(*Socket)) *Socket {
 				return
 			}
 		}
-		// track socket
-		n.sockets.Store(socket.Id(), socket)
-		// it's paramount that the internal `onconnect` logic
-		// fires before user-set events to prevent state order
-		// violations (such as a disconnection before the connection
-		// logic is complete)
-		socket._onconnect()
-		if fn != nil {
-			fn(socket)
-		}
-		// fire user-set events
-		n.EmitReserved("connect", socket)
-		n.EmitReserved("connection", socket)
+		n._connect(socket, fn)
 	})
 	return socket
 }
 
+// Connects the socket, then handles its packets.
+func (n *Namespace) _connect(socket *Socket, fn func(*Socket)) {
+	// the packets received in the meantime are handled once the "connection" listeners have registered theirs
+	defer socket.queue.resume()
+	defer socket._recover(nil)
+
+	n._doConnect(socket, fn)
+}
+
+func (n *Namespace) _createSocket(client *Client, auth any) *Socket {
+	if n.server.opts.ConnectionStateRecovery() != nil {
+		var pid, offset any
+		switch _auth := auth.(type) {
+		case map[string]any:
+			pid, offset = _auth["pid"], _auth["offset"]
+		case url.Values:
+			pid, offset = _auth.Get("pid"), _auth.Get("offset")
+		}
+		_pid, pid_ok := pid.(string)
+		_offset, offset_ok := offset.(string)
+		if pid_ok && offset_ok && _pid != "" && _offset != "" {
+			session, err := n.adapter.RestoreSession(PrivateSessionId(_pid), _offset)
+			if err != nil {
+				namespace_log.Debug("error while restoring session: %v", err)
+			} else if session != nil {
+				namespace_log.Debug("connection state recovered for sid %s", session.Sid)
+				return NewSocket(n, client, auth, session)
+			}
+		}
+	}
+	return NewSocket(n, client, auth, nil)
+}
+
+func (n *Namespace) _doConnect(socket *Socket, fn func(*Socket)) {
+	// track socket
+	n.sockets.Store(socket.Id(), socket)
+	// the client must know the socket before the CONNECT packet is sent, in order to route the packets which follow
+	if fn != nil {
+		fn(socket)
+	}
+	// it's paramount that the internal `onconnect` logic
+	// fires before user-set events to prevent state order
+	// violations (such as a disconnection before the connection
+	// logic is complete)
+	socket._onconnect()
+	// fire user-set events
+	n.EmitReserved("connect", socket)
+	n.EmitReserved("connection", socket)
+}
+
 // Removes a client. Called by each `Socket`.
 func (n *Namespace) _remove(socket *Socket) {
 	if _, ok := n.sockets.LoadAndDelete(socket.Id()); !ok {
@@ -204,6 +289,11 @@ func (n *Namespace) Emit(ev string, args ...any) error {
 	return NewBroadcastOperator(n.adapter, nil, nil, nil).Emit(ev, args...)
 }
 
+// Emits to all clients and waits for their acknowledgements, see BroadcastOperator.EmitWithAck().
+func (n *Namespace) EmitWithAck(ctx context.Context, ev string, args ...any) ([]any, error) {
+	return NewBroadcastOperator(n.adapter, nil, nil, nil).EmitWithAck(ctx, ev, args...)
+}
+
 // Sends a `message` event to all clients.
 func (n *Namespace) Send(args ...any) NamespaceInterface {
 	n.Emit("message", args...)
@@ -222,9 +312,37 @@ func (n *Namespace) ServerSideEmit(ev string, args ...any) error {
 		return errors.New(fmt.Sprintf(`"%s" is a reserved event name`, ev))
 	}
 
-	n.adapter.ServerSideEmit(ev, args...)
+	return n.adapter.ServerSideEmit(ev, args...)
+}
+
+// Sends a message and expect an acknowledgement from the other Socket.IO servers of the cluster.
+//
+// The last argument must be a func(error, []any), called with one response per server (the current one excluded),
+// or with an error if some servers did not acknowledge the event in the given delay.
+//
+//	io.Of("/chat", nil).ServerSideEmitWithAck("hello", "world", func(err error, responses []any) {
+//		if err != nil {
+//			// some servers did not acknowledge the event in the given delay
+//		}
+//	})
+//
+//	// on the other servers
+//	io.Of("/chat", nil).On("hello", func(args ...any) {
+//		callback := args[len(args)-1].(func(...any))
+//		callback("hi")
+//	})
+func (n *Namespace) ServerSideEmitWithAck(ev string, args ...any) error {
+	if NAMESPACE_RESERVED_EVENTS.Has(ev) {
+		return errors.New(fmt.Sprintf(`"%s" is a reserved event name`, ev))
+	}
+	if len(args) == 0 {
+		return errors.New("the last argument must be an acknowledgement callback")
+	}
+	if _, ok := args[len(args)-1].(func(error, []any)); !ok {
+		return errors.New("the last argument must be an acknowledgement callback")
+	}
 
-	return nil
+	return n.adapter.ServerSideEmit(ev, args...)
 }
 
 // Called when a packet is received from another Socket.IO server
@@ -0,0 +1,87 @@
+package socket
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNamespaceMiddlewares(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+
+	calls := []int{}
+	for i := 0; i < 3; i++ {
+		i := i
+		nsp.Use(func(_ *Socket, next func(*ExtendedError)) {
+			calls = append(calls, i)
+			next(nil)
+		})
+	}
+
+	done := make(chan *ExtendedError, 1)
+	nsp.(*Namespace).run(&Socket{}, func(err *ExtendedError) {
+		done <- err
+	})
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the callback was not called after the last middleware")
+	}
+	if len(calls) != 3 {
+		t.Fatalf("expected 3 middlewares to run, got %v", calls)
+	}
+}
+
+func TestNamespaceMiddlewarePanic(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+
+	// the next middleware runs in the goroutine of the first one
+	nsp.Use(func(_ *Socket, next func(*ExtendedError)) {
+		go next(nil)
+	})
+	nsp.Use(func(*Socket, func(*ExtendedError)) {
+		panic("middleware failure")
+	})
+
+	done := make(chan *ExtendedError, 1)
+	nsp.(*Namespace).run(&Socket{}, func(err *ExtendedError) {
+		done <- err
+	})
+	select {
+	case err := <-done:
+		if err == nil || err.Error() != panicMessage {
+			t.Fatalf("expected the socket to be rejected, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the callback was not called")
+	}
+}
+
+func TestNamespaceServerSideEmitWithAck(t *testing.T) {
+	nsp := NewServer(nil, nil).Of("/", nil)
+
+	if err := nsp.ServerSideEmitWithAck("count"); err == nil {
+		t.Error("expected an error without acknowledgement callback")
+	}
+	if err := nsp.ServerSideEmitWithAck("count", 1); err == nil {
+		t.Error("expected an error when the last argument is not an acknowledgement callback")
+	}
+	if err := nsp.ServerSideEmitWithAck("connection", func(error, []any) {}); err == nil {
+		t.Error("expected an error for a reserved event name")
+	}
+
+	// there is no other server with the in-memory adapter
+	called := false
+	err := nsp.ServerSideEmitWithAck("count", func(err error, responses []any) {
+		called = true
+		if err != nil || len(responses) != 0 {
+			t.Errorf("expected no error and no response, got %v, %v", err, responses)
+		}
+	})
+	if err != nil || !called {
+		t.Fatalf("expected the callback to be called at once, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package socket
+
+import (
+	"time"
+
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/engine.io/utils"
+	"github.com/zishang520/socket.io/parser"
+)
+
+// Hooks into the internals of a server which are not exposed by its events, to collect metrics for example. Any of
+// the functions can be nil. See Server.Observe().
+type Observer struct {
+	// Called with each packet encoded by the server, and the total size of its encoded parts.
+	Encoded func(packet *parser.Packet, size int)
+
+	// Called when a middleware of the namespace rejects a socket.
+	MiddlewareError func(nsp string, err *ExtendedError)
+
+	// Called when a socket acknowledges a packet, with the time elapsed since the packet was sent.
+	Acknowledged func(nsp string, latency time.Duration)
+
+	// Called when a packet is not acknowledged in the given delay.
+	AckTimedOut func(nsp string)
+}
+
+// Wraps the encoder of the server, in order to report the size of the encoded packets, and the encoding errors to the
+// ErrorHandler.
+type observedEncoder struct {
+	parser.Encoder
+
+	server *Server
+}
+
+func (e *observedEncoder) Encode(packet *parser.Packet) ([]types.BufferInterface, error) {
+	encodedPackets, err := e.Encoder.Encode(packet)
+	if err != nil {
+		// the payload would be silently lost otherwise, the error is also returned to the emitter when there is one
+		server_log.Debug("failed to encode packet %v: %v", packet, err)
+		if !e.server._onerror(nil, err) {
+			utils.Log().Error("%v", err)
+		}
+		return nil, err
+	}
+	if observers := e.server.observers(); len(observers) > 0 {
+		size := 0
+		for _, encodedPacket := range encodedPackets {
+			size += encodedPacket.Len()
+		}
+		for _, o := range observers {
+			if o.Encoded != nil {
+				o.Encoded(packet, size)
+			}
+		}
+	}
+	return encodedPackets, nil
+}
+
+// Registers an observer of the internals of the server.
+func (s *Server) Observe(observer *Observer) *Server {
+	s._observers_mu.Lock()
+	defer s._observers_mu.Unlock()
+
+	// copy on write, since the observers are read for each packet
+	s._observers = append(append([]*Observer{}, s._observers...), observer)
+	return s
+}
+
+func (s *Server) observers() []*Observer {
+	s._observers_mu.RLock()
+	defer s._observers_mu.RUnlock()
+
+	return s._observers
+}
+
+func (s *Server) observeMiddlewareError(nsp string, err *ExtendedError) {
+	for _, o := range s.observers() {
+		if o.MiddlewareError != nil {
+			o.MiddlewareError(nsp, err)
+		}
+	}
+}
+
+func (s *Server) observeAck(nsp string, latency time.Duration) {
+	for _, o := range s.observers() {
+		if o.Acknowledged != nil {
+			o.Acknowledged(nsp, latency)
+		}
+	}
+}
+
+func (s *Server) observeAckTimeout(nsp string) {
+	for _, o := range s.observers() {
+		if o.AckTimedOut != nil {
+			o.AckTimedOut(nsp)
+		}
+	}
+}
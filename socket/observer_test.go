@@ -0,0 +1,82 @@
+package socket_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/zishang520/socket.io/parser"
+	"github.com/zishang520/socket.io/socket"
+)
+
+func TestEncodeError(t *testing.T) {
+	opts := socket.DefaultServerOptions()
+	reported := make(chan error, 10)
+	opts.SetErrorHandler(func(_ *socket.Socket, err error) {
+		reported <- err
+	})
+	io, url := newTestServer(t, opts)
+	sockets := make(chan *socket.Socket, 1)
+	io.On("connection", func(args ...any) {
+		s := args[0].(*socket.Socket)
+		s.Join("room")
+		sockets <- s
+	})
+
+	c := connect(t, url, nil)
+	s := receive(t, sockets)
+	received := make(chan []any, 10)
+	c.OnAny(func(args ...any) {
+		received <- args
+	})
+
+	emitters := map[string]func(any) error{
+		"Socket.Emit": func(v any) error {
+			return s.Emit("ev", v)
+		},
+		"Socket.Emit with an ack": func(v any) error {
+			return s.Emit("ev", v, func(...any) {
+				t.Error("the callback of a packet which was not encoded was called")
+			})
+		},
+		"Socket.EmitWithAck": func(v any) error {
+			_, err := s.EmitWithAck(context.Background(), "ev", v)
+			return err
+		},
+		"Namespace.Emit": func(v any) error {
+			return io.Sockets().Emit("ev", v)
+		},
+		"BroadcastOperator.Emit": func(v any) error {
+			return io.To("room").Emit("ev", v)
+		},
+		"BroadcastOperator.EmitWithAck": func(v any) error {
+			_, err := io.To("room").EmitWithAck(context.Background(), "ev", v)
+			return err
+		},
+	}
+	for name, emit := range emitters {
+		for _, v := range []any{make(chan int), math.NaN(), map[string]any{"f": func() {}}} {
+			err := emit(v)
+			var encodeErr *parser.EncodeError
+			if !errors.As(err, &encodeErr) {
+				t.Fatalf("%s(%T): expected an *EncodeError, got %v", name, v, err)
+			}
+			if reportedErr := receive(t, reported); !errors.As(reportedErr, &encodeErr) {
+				t.Errorf("%s(%T): expected the *EncodeError to be reported, got %v", name, v, reportedErr)
+			}
+		}
+	}
+
+	// the packets which cannot be encoded are not sent, not even without their data
+	if err := s.Emit("ev", "ok"); err != nil {
+		t.Fatal(err)
+	}
+	if args := receive(t, received); !reflect.DeepEqual(args, []any{"ev", "ok"}) {
+		t.Errorf("expected only the last event, got %v", args)
+	}
+	if len(reported) > 0 {
+		t.Errorf("unexpected error %v", <-reported)
+	}
+}
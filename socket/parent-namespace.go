@@ -27,19 +27,25 @@ func NewParentNamespace(server *Server) *ParentNamespace {
 }
 
 func (p *ParentNamespace) _initAdapter() {
-	broadcast := func(packet *parser.Packet, opts *BroadcastOptions) {
+	broadcast := func(packet *parser.Packet, opts *BroadcastOptions) (err error) {
 		for _, nsp := range p.children.Keys() {
-			nsp.adapter.Broadcast(packet, opts)
+			// the packet is still sent to the other children
+			if e := nsp.adapter.Broadcast(packet, opts); e != nil && err == nil {
+				err = e
+			}
 		}
+		return err
 	}
 	p.adapter.SetBroadcast(broadcast)
 }
 
-func (p *ParentNamespace) Emit(ev string, args ...any) error {
+func (p *ParentNamespace) Emit(ev string, args ...any) (err error) {
 	for _, nsp := range p.children.Keys() {
-		nsp.Emit(ev, args...)
+		if e := nsp.Emit(ev, args...); e != nil && err == nil {
+			err = e
+		}
 	}
-	return nil
+	return err
 }
 
 func (p *ParentNamespace) CreateChild(name string) *Namespace {
@@ -48,6 +54,7 @@ func (p *ParentNamespace) CreateChild(name string) *Namespace {
 	namespace._fns_mu.RLock()
 	namespace._fns = append([]func(*Socket, func(*ExtendedError)){}, p._fns...)
 	namespace._fns_mu.RUnlock()
+	namespace.SetConcurrency(p.Concurrency())
 
 	namespace.AddListener("connect", p.Listeners("connect")...)
 	namespace.AddListener("connection", p.Listeners("connection")...)
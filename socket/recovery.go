@@ -0,0 +1,78 @@
+package socket
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/zishang520/engine.io/utils"
+)
+
+// The message sent to the client instead of the value of a panic, which may reveal the internals of the server.
+const panicMessage = "internal server error"
+
+// The error reported when a listener, a middleware or an acknowledgement callback panics.
+type PanicError struct {
+	// The value passed to panic()
+	Value any
+	// The stack trace of the goroutine which panicked
+	Stack []byte
+}
+
+func newPanicError(value any) *PanicError {
+	return &PanicError{Value: value, Stack: debug.Stack()}
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// Returns the value of the panic if it is an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Reports an error to the ErrorHandler of the server, returns false if there is none.
+func (s *Server) _onerror(socket *Socket, err error) bool {
+	if handler := s.opts.ErrorHandler(); handler != nil {
+		handler(socket, err)
+		return true
+	}
+	return false
+}
+
+// Recovers from a panic which is not related to a connected socket, and reports it to the ErrorHandler. Must be
+// deferred.
+func (s *Server) _recover() {
+	if r := recover(); r != nil {
+		err := newPanicError(r)
+		server_log.Debug("recovered from %v", err)
+		if !s._onerror(nil, err) {
+			utils.Log().Error("%v\n%s", err, err.Stack)
+		}
+	}
+}
+
+// Recovers from a panic of the code handling a packet of the socket, and reports it as an error of the socket. Must be
+// deferred.
+func (s *Socket) _recover(onpanic func(*PanicError)) {
+	if r := recover(); r != nil {
+		err := newPanicError(r)
+		socket_log.Debug("recovered from %v", err)
+		s._onerror(err)
+		if onpanic != nil {
+			onpanic(err)
+		}
+	}
+}
+
+// The payload of the acknowledgement of an event which could not be handled, see ServerOptions.SetAckOnError().
+func ackError(err error) map[string]any {
+	switch e := err.(type) {
+	case *PanicError:
+		return map[string]any{"message": panicMessage}
+	case *ExtendedError:
+		return map[string]any{"message": e.Error(), "data": e.Data()}
+	}
+	return map[string]any{"message": err.Error()}
+}
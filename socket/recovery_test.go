@@ -0,0 +1,77 @@
+package socket_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zishang520/socket.io/client"
+	"github.com/zishang520/socket.io/socket"
+)
+
+func TestListenerPanic(t *testing.T) {
+	opts := socket.DefaultServerOptions()
+	opts.SetAckOnError(true)
+	io, url := newTestServer(t, opts)
+	errs := make(chan error, 1)
+	io.On("connection", func(args ...any) {
+		s := args[0].(*socket.Socket)
+		s.On("error", func(args ...any) {
+			errs <- args[0].(error)
+		})
+		s.On("boom", func(...any) {
+			panic("listener failure")
+		})
+		s.On("ping", func(args ...any) {
+			args[len(args)-1].(func(...any))("pong")
+		})
+	})
+	c := connect(t, url, nil)
+
+	acks := make(chan []any, 2)
+	c.Emit("boom", func(args ...any) {
+		acks <- args
+	})
+	if args := receive(t, acks); len(args) != 1 || fmt.Sprint(args[0]) != "map[message:internal server error]" {
+		t.Fatalf("unexpected acknowledgement %v", args)
+	}
+	var panicErr *socket.PanicError
+	if err := receive(t, errs); !errors.As(err, &panicErr) {
+		t.Fatalf("expected a *socket.PanicError, got %v", err)
+	}
+
+	// the socket still handles the next events
+	c.Emit("ping", func(args ...any) {
+		acks <- args
+	})
+	if args := receive(t, acks); len(args) != 1 || args[0] != "pong" {
+		t.Fatalf("unexpected acknowledgement %v", args)
+	}
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	io, url := newTestServer(t, nil)
+	// the next middleware runs in the goroutine of the first one
+	io.Use(func(_ *socket.Socket, next func(*socket.ExtendedError)) {
+		go next(nil)
+	})
+	io.Use(func(*socket.Socket, func(*socket.ExtendedError)) {
+		panic("middleware failure")
+	})
+
+	opts := client.DefaultOptions()
+	opts.SetForceNew(true)
+	c, err := client.Io(url, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Disconnect()
+	errs := make(chan any, 1)
+	c.On("connect_error", func(args ...any) {
+		errs <- args[0]
+	})
+	if err := receive(t, errs); !strings.Contains(fmt.Sprint(err), "internal server error") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
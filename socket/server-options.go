@@ -26,6 +26,96 @@ type ServerOptionsInterface interface {
 	SetConnectTimeout(connectTimeout time.Duration)
 	GetRawConnectTimeout() *time.Duration
 	ConnectTimeout() time.Duration
+
+	SetConnectionStateRecovery(connectionStateRecovery *ConnectionStateRecovery)
+	GetRawConnectionStateRecovery() *ConnectionStateRecovery
+	ConnectionStateRecovery() *ConnectionStateRecovery
+
+	SetMaxBufferedBytes(maxBufferedBytes int64)
+	GetRawMaxBufferedBytes() *int64
+	MaxBufferedBytes() int64
+
+	SetMaxBufferedPackets(maxBufferedPackets int64)
+	GetRawMaxBufferedPackets() *int64
+	MaxBufferedPackets() int64
+
+	SetBufferPolicy(bufferPolicy BufferPolicy)
+	GetRawBufferPolicy() *BufferPolicy
+	BufferPolicy() BufferPolicy
+
+	SetErrorHandler(errorHandler ErrorHandler)
+	GetRawErrorHandler() ErrorHandler
+	ErrorHandler() ErrorHandler
+
+	SetAckOnError(ackOnError bool)
+	GetRawAckOnError() *bool
+	AckOnError() bool
+
+	SetReconnectOnShutdown(reconnectOnShutdown bool)
+	GetRawReconnectOnShutdown() *bool
+	ReconnectOnShutdown() bool
+
+	SetReconnectJitter(reconnectJitter time.Duration)
+	GetRawReconnectJitter() *time.Duration
+	ReconnectJitter() time.Duration
+}
+
+// Handles the errors of the sockets, including the panics of the listeners, the middlewares and the acknowledgement
+// callbacks (as a *PanicError), and the packets which cannot be encoded (as a *parser.EncodeError, logged when there is
+// no handler). The socket is nil when the error is not related to a connected socket.
+type ErrorHandler func(*Socket, error)
+
+// What to do with a packet which does not fit in the outgoing buffer of a client.
+type BufferPolicy int
+
+const (
+	// Disconnects the client, which does not read its packets fast enough.
+	BUFFER_POLICY_DISCONNECT BufferPolicy = iota
+	// Discards the new packet.
+	BUFFER_POLICY_DROP_NEWEST
+	// Discards the oldest packets which are not handed to the transport yet, or the new packet if that is not enough.
+	BUFFER_POLICY_DROP_OLDEST
+)
+
+type ConnectionStateRecovery struct {
+	// The backup duration of the sessions and the packets
+	maxDisconnectionDuration *time.Duration
+
+	// Whether to skip middlewares upon successful connection state recovery
+	skipMiddlewares *bool
+}
+
+func DefaultConnectionStateRecovery() *ConnectionStateRecovery {
+	c := &ConnectionStateRecovery{}
+	return c
+}
+
+func (c *ConnectionStateRecovery) SetMaxDisconnectionDuration(maxDisconnectionDuration time.Duration) {
+	c.maxDisconnectionDuration = &maxDisconnectionDuration
+}
+func (c *ConnectionStateRecovery) GetRawMaxDisconnectionDuration() *time.Duration {
+	return c.maxDisconnectionDuration
+}
+func (c *ConnectionStateRecovery) MaxDisconnectionDuration() time.Duration {
+	if c.maxDisconnectionDuration == nil {
+		return time.Duration(2 * 60 * 1000 * time.Millisecond)
+	}
+
+	return *c.maxDisconnectionDuration
+}
+
+func (c *ConnectionStateRecovery) SetSkipMiddlewares(skipMiddlewares bool) {
+	c.skipMiddlewares = &skipMiddlewares
+}
+func (c *ConnectionStateRecovery) GetRawSkipMiddlewares() *bool {
+	return c.skipMiddlewares
+}
+func (c *ConnectionStateRecovery) SkipMiddlewares() bool {
+	if c.skipMiddlewares == nil {
+		return true
+	}
+
+	return *c.skipMiddlewares
 }
 
 type ServerOptions struct {
@@ -43,6 +133,35 @@ type ServerOptions struct {
 
 	// how many ms before a client without namespace is closed
 	connectTimeout *time.Duration
+
+	// Whether to enable the recovery of connection state when a client temporarily disconnects.
+	//
+	// The connection state includes the missed packets, the rooms the socket was in and the `data` attribute.
+	connectionStateRecovery *ConnectionStateRecovery
+
+	// The maximum number of bytes buffered for a client, until they are written to the transport (0 for no limit).
+	maxBufferedBytes *int64
+
+	// The maximum number of packets buffered for a client, until they are written to the transport (0 for no limit).
+	maxBufferedPackets *int64
+
+	// What to do when the buffer of a client is full.
+	bufferPolicy *BufferPolicy
+
+	// the handler of the errors of the sockets
+	errorHandler ErrorHandler
+
+	// Whether to answer the acknowledgement of an event which could not be handled, because of a middleware error or a
+	// panic, with an error payload.
+	ackOnError *bool
+
+	// Whether Shutdown() closes the connections without disconnecting the sockets, so that the clients reconnect (to
+	// another server behind the load balancer).
+	reconnectOnShutdown *bool
+
+	// The maximum random delay before a client is asked to reconnect upon Shutdown(), so that the clients do not all
+	// reconnect at the same time.
+	reconnectJitter *time.Duration
 }
 
 func DefaultServerOptions() *ServerOptions {
@@ -123,6 +242,38 @@ func (s *ServerOptions) Assign(data ServerOptionsInterface) (ServerOptionsInterf
 		s.SetConnectTimeout(data.ConnectTimeout())
 	}
 
+	if s.GetRawConnectionStateRecovery() == nil {
+		s.SetConnectionStateRecovery(data.ConnectionStateRecovery())
+	}
+
+	if s.GetRawMaxBufferedBytes() == nil {
+		s.SetMaxBufferedBytes(data.MaxBufferedBytes())
+	}
+
+	if s.GetRawMaxBufferedPackets() == nil {
+		s.SetMaxBufferedPackets(data.MaxBufferedPackets())
+	}
+
+	if s.GetRawBufferPolicy() == nil {
+		s.SetBufferPolicy(data.BufferPolicy())
+	}
+
+	if s.GetRawErrorHandler() == nil {
+		s.SetErrorHandler(data.ErrorHandler())
+	}
+
+	if s.GetRawAckOnError() == nil {
+		s.SetAckOnError(data.AckOnError())
+	}
+
+	if s.GetRawReconnectOnShutdown() == nil {
+		s.SetReconnectOnShutdown(data.ReconnectOnShutdown())
+	}
+
+	if s.GetRawReconnectJitter() == nil {
+		s.SetReconnectJitter(data.ReconnectJitter())
+	}
+
 	return s, nil
 }
 
@@ -187,3 +338,107 @@ func (s *ServerOptions) ConnectTimeout() time.Duration {
 
 	return *s.connectTimeout
 }
+
+func (s *ServerOptions) SetConnectionStateRecovery(connectionStateRecovery *ConnectionStateRecovery) {
+	s.connectionStateRecovery = connectionStateRecovery
+}
+func (s *ServerOptions) GetRawConnectionStateRecovery() *ConnectionStateRecovery {
+	return s.connectionStateRecovery
+}
+func (s *ServerOptions) ConnectionStateRecovery() *ConnectionStateRecovery {
+	return s.connectionStateRecovery
+}
+
+func (s *ServerOptions) SetMaxBufferedBytes(maxBufferedBytes int64) {
+	s.maxBufferedBytes = &maxBufferedBytes
+}
+func (s *ServerOptions) GetRawMaxBufferedBytes() *int64 {
+	return s.maxBufferedBytes
+}
+func (s *ServerOptions) MaxBufferedBytes() int64 {
+	if s.maxBufferedBytes == nil {
+		return 0
+	}
+
+	return *s.maxBufferedBytes
+}
+
+func (s *ServerOptions) SetMaxBufferedPackets(maxBufferedPackets int64) {
+	s.maxBufferedPackets = &maxBufferedPackets
+}
+func (s *ServerOptions) GetRawMaxBufferedPackets() *int64 {
+	return s.maxBufferedPackets
+}
+func (s *ServerOptions) MaxBufferedPackets() int64 {
+	if s.maxBufferedPackets == nil {
+		return 0
+	}
+
+	return *s.maxBufferedPackets
+}
+
+func (s *ServerOptions) SetBufferPolicy(bufferPolicy BufferPolicy) {
+	s.bufferPolicy = &bufferPolicy
+}
+func (s *ServerOptions) GetRawBufferPolicy() *BufferPolicy {
+	return s.bufferPolicy
+}
+func (s *ServerOptions) BufferPolicy() BufferPolicy {
+	if s.bufferPolicy == nil {
+		return BUFFER_POLICY_DISCONNECT
+	}
+
+	return *s.bufferPolicy
+}
+
+func (s *ServerOptions) SetErrorHandler(errorHandler ErrorHandler) {
+	s.errorHandler = errorHandler
+}
+func (s *ServerOptions) GetRawErrorHandler() ErrorHandler {
+	return s.errorHandler
+}
+func (s *ServerOptions) ErrorHandler() ErrorHandler {
+	return s.errorHandler
+}
+
+func (s *ServerOptions) SetAckOnError(ackOnError bool) {
+	s.ackOnError = &ackOnError
+}
+func (s *ServerOptions) GetRawAckOnError() *bool {
+	return s.ackOnError
+}
+func (s *ServerOptions) AckOnError() bool {
+	if s.ackOnError == nil {
+		return false
+	}
+
+	return *s.ackOnError
+}
+
+func (s *ServerOptions) SetReconnectOnShutdown(reconnectOnShutdown bool) {
+	s.reconnectOnShutdown = &reconnectOnShutdown
+}
+func (s *ServerOptions) GetRawReconnectOnShutdown() *bool {
+	return s.reconnectOnShutdown
+}
+func (s *ServerOptions) ReconnectOnShutdown() bool {
+	if s.reconnectOnShutdown == nil {
+		return false
+	}
+
+	return *s.reconnectOnShutdown
+}
+
+func (s *ServerOptions) SetReconnectJitter(reconnectJitter time.Duration) {
+	s.reconnectJitter = &reconnectJitter
+}
+func (s *ServerOptions) GetRawReconnectJitter() *time.Duration {
+	return s.reconnectJitter
+}
+func (s *ServerOptions) ReconnectJitter() time.Duration {
+	if s.reconnectJitter == nil {
+		return 0
+	}
+
+	return *s.reconnectJitter
+}
@@ -1,33 +1,29 @@
 package socket
 
 import (
-	"compress/flate"
-	"compress/gzip"
+	"context"
 	"errors"
 	"fmt"
-	"io"
+	"net"
 	"net/http"
-	"os"
 	"path"
-	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/andybalholm/brotli"
 	"github.com/zishang520/engine.io/engine"
 	"github.com/zishang520/engine.io/log"
 	"github.com/zishang520/engine.io/types"
-	"github.com/zishang520/engine.io/utils"
 	"github.com/zishang520/socket.io/parser"
 )
 
 const clientVersion = "4.5.1"
 
 var (
-	dotMapRegex = regexp.MustCompile(`\.map`)
-	server_log  = log.NewLog("socket.io:server")
+	dotMapRegex     = regexp.MustCompile(`\.map`)
+	clientFileRegex = regexp.MustCompile(`^socket\.io(\.msgpack|\.esm)?(\.min)?\.js(\.map)?$`)
+	server_log      = log.NewLog("socket.io:server")
 )
 
 type ParentNspNameMatchFn *func(string, any, func(error, bool))
@@ -42,6 +38,8 @@ type Server struct {
 	encoder parser.Encoder
 
 	_nsps *sync.Map
+	// the clients, by id
+	clients *sync.Map
 
 	parentNsps      *sync.Map
 	_adapter        Adapter
@@ -53,6 +51,14 @@ type Server struct {
 
 	_connectTimeout time.Duration
 	httpServer      *types.HttpServer
+	// the server created to serve a net.Listener
+	listenerServer *http.Server
+
+	_observers    []*Observer
+	_observers_mu sync.RWMutex
+
+	// whether Shutdown() has been called
+	_shuttingDown int32
 }
 
 func (s *Server) Sockets() NamespaceInterface {
@@ -67,16 +73,23 @@ func (s *Server) Encoder() parser.Encoder {
 	return s.encoder
 }
 
+// Returns the namespaces of the server, by name.
+func (s *Server) Nsps() *sync.Map {
+	return s._nsps
+}
+
 func NewServer(srv any, opts *ServerOptions) *Server {
 	s := &Server{}
 	// @private
 	s._nsps = &sync.Map{}
+	s.clients = &sync.Map{}
 	s.parentNsps = &sync.Map{}
 
 	if opts == nil {
 		opts = DefaultServerOptions()
 	}
 
+	s.opts = opts
 	s.SetPath(opts.Path())
 	s.SetConnectTimeout(opts.ConnectTimeout())
 	s.SetServeClient(false != opts.ServeClient())
@@ -85,16 +98,17 @@ func NewServer(srv any, opts *ServerOptions) *Server {
 	} else {
 		s._parser = parser.NewParser()
 	}
-	s.encoder = s._parser.Encoder()
-	if _adapter := opts.Adapter(); _adapter != nil {
+	s.encoder = &observedEncoder{Encoder: s._parser.Encoder(), server: s}
+	if _adapter := opts.GetRawAdapter(); _adapter != nil {
 		s.SetAdapter(_adapter)
+	} else if opts.ConnectionStateRecovery() != nil {
+		s.SetAdapter(&sessionAwareAdapter{})
 	} else {
 		s.SetAdapter(&adapter{})
 	}
 	s.sockets = s.Of("/", nil)
 	s.StrictEventEmitter = s.sockets.EventEmitter()
 
-	s.opts = opts
 	if srv != nil {
 		s.Attach(srv, nil)
 	}
@@ -192,6 +206,11 @@ func (s *Server) ServeHandler(opts *ServerOptions) http.Handler {
 	s.Bind(s.eio)
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the handler may be mounted with a stripped prefix, so only the name of the file is checked
+		if s._serveClient && clientFileRegex.MatchString(path.Base(r.URL.Path)) {
+			s.serve(w, r)
+			return
+		}
 		s.eio.ServeHTTP(w, r)
 	})
 }
@@ -202,6 +221,9 @@ func (s *Server) Listen(srv any, opts *ServerOptions) *Server {
 }
 
 // Attaches socket.io to a server or port.
+//
+// The server can be an address to listen to, a *types.HttpServer, a *http.Server (whose handler then receives the
+// requests outside of the path of the Socket.IO server), a *http.ServeMux or a net.Listener (a TCP or a Unix socket).
 func (s *Server) Attach(srv any, opts *ServerOptions) *Server {
 	var server *types.HttpServer
 	switch address := srv.(type) {
@@ -214,8 +236,26 @@ func (s *Server) Attach(srv any, opts *ServerOptions) *Server {
 		server.Listen(address, nil)
 	case *types.HttpServer:
 		server = address
+	case *http.ServeMux:
+		server_log.Debug("handling the requests of %s/ with the mux", s._path)
+		address.Handle(s._path+"/", s.ServeHandler(opts))
+		return s
+	case *http.Server:
+		s.attachHttpServer(address, s.ServeHandler(opts))
+		return s
+	case net.Listener:
+		server_log.Debug("creating http server and serving %s", address.Addr())
+		listenerServer := &http.Server{Handler: http.NotFoundHandler()}
+		s.attachHttpServer(listenerServer, s.ServeHandler(opts))
+		s.listenerServer = listenerServer
+		go func() {
+			if err := listenerServer.Serve(address); err != nil && err != http.ErrServerClosed {
+				server_log.Debug("failed to serve %s: %v", address.Addr(), err)
+			}
+		}()
+		return s
 	default:
-		panic(errors.New(fmt.Sprintf("You are trying to attach socket.io to an express request handler %T. Please pass a *types.HttpServer instance.", address)))
+		panic(errors.New(fmt.Sprintf("You are trying to attach socket.io to an express request handler %T. Please pass a *types.HttpServer, *http.Server, *http.ServeMux or net.Listener instance.", address)))
 	}
 	if opts == nil {
 		opts = DefaultServerOptions()
@@ -232,6 +272,27 @@ func (s *Server) Attach(srv any, opts *ServerOptions) *Server {
 	return s
 }
 
+// Handles the requests under the path of the server with the handler, the other ones being left to the handler of the
+// http.Server (or http.DefaultServeMux if it has none).
+func (s *Server) attachHttpServer(server *http.Server, handler http.Handler) {
+	server_log.Debug("handling the requests of %s/ with the http server", s._path)
+	next := server.Handler
+	if next == nil {
+		next = http.DefaultServeMux
+	}
+	prefix := s._path + "/"
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			handler.ServeHTTP(w, r)
+		} else {
+			next.ServeHTTP(w, r)
+		}
+	})
+	server.RegisterOnShutdown(func() {
+		s.engine.Close()
+	})
+}
+
 // Initialize engine
 func (s *Server) initEngine(srv *types.HttpServer, opts any) {
 	// initialize engine
@@ -268,25 +329,12 @@ func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	filename := filepath.Base(r.URL.Path)
+	filename := path.Base(r.URL.Path)
 	isMap := dotMapRegex.MatchString(filename)
 	_type := "source"
 	if isMap {
 		_type = "map"
 	}
-	// Per the standard, ETags must be quoted:
-	// https://tools.ietf.org/html/rfc7232#section-2.3
-	expectedEtag := `"` + clientVersion + `"`
-	weakEtag := "W/" + expectedEtag
-
-	if etag := r.Header.Get("If-None-Match"); etag != "" {
-		if expectedEtag == etag || weakEtag == etag {
-			server_log.Debug("serve client %s 304", _type)
-			w.WriteHeader(http.StatusNotModified)
-			w.Write(nil)
-			return
-		}
-	}
 
 	server_log.Debug("serve client %s", _type)
 	w.Header().Set("Cache-Control", "public, max-age=0")
@@ -295,59 +343,17 @@ func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
 	} else {
 		w.Header().Set("Content-Type", "application/javascript")
 	}
-	w.Header().Set("ETag", expectedEtag)
 	s.sendFile(filename, w, r)
 }
 
-func (Server) sendFile(filename string, w http.ResponseWriter, r *http.Request) {
-	_file, err := os.Executable()
-	if err != nil {
-		server_log.Debug("Failed to get run path: %v", err)
-		http.Error(w, "file not found", http.StatusNotFound)
-		return
-	}
-
-	file, err := os.Open(filepath.FromSlash(path.Join(filepath.Dir(filepath.Dir(_file)), "client-dist", path.Clean("/"+filename))))
-	if err != nil {
-		server_log.Debug("File read failed: %v", err)
+func (*Server) sendFile(filename string, w http.ResponseWriter, r *http.Request) {
+	file := lookupClientFile(filename)
+	if file == nil {
+		server_log.Debug("client file %s not found", filename)
 		http.Error(w, "file not found", http.StatusNotFound)
 		return
 	}
-	encoding := utils.Contains(r.Header.Get("Accept-Encoding"), []string{"gzip", "deflate", "br"})
-
-	switch encoding {
-	case "br":
-		br := brotli.NewWriterLevel(w, 1)
-		defer br.Close()
-		w.Header().Set("Content-Encoding", "br")
-		w.WriteHeader(http.StatusOK)
-		io.Copy(br, file)
-	case "gzip":
-		gz, err := gzip.NewWriterLevel(w, 1)
-		if err != nil {
-			server_log.Debug("Failed to compress data: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-		defer gz.Close()
-		w.Header().Set("Content-Encoding", "gzip")
-		w.WriteHeader(http.StatusOK)
-		io.Copy(gz, file)
-	case "deflate":
-		fl, err := flate.NewWriter(w, 1)
-		if err != nil {
-			server_log.Debug("Failed to compress data: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-		defer fl.Close()
-		w.Header().Set("Content-Encoding", "deflate")
-		w.WriteHeader(http.StatusOK)
-		io.Copy(fl, file)
-	default:
-		w.WriteHeader(http.StatusOK)
-		io.Copy(w, file)
-	}
+	file.serveHTTP(w, r)
 }
 
 // Binds socket.io to an engine.io instance.
@@ -362,6 +368,12 @@ func (s *Server) onconnection(conns ...any) {
 	conn := conns[0].(engine.Socket)
 	server_log.Debug("incoming connection with id %s", conn.Id())
 	client := NewClient(s, conn)
+	s.clients.Store(client.id, client)
+	if s.shuttingDown() {
+		server_log.Debug("the server is shutting down, closing connection %s", conn.Id())
+		client.close()
+		return
+	}
 	if conn.Protocol() == 3 {
 		client.connect("/", nil)
 	}
@@ -424,13 +436,42 @@ func (s *Server) Of(name any, fn func(...any)) NamespaceInterface {
 
 // Closes server connection
 func (s *Server) Close(fn func()) {
-	s.sockets.Sockets().Range(func(_ any, socket any) bool {
-		socket.(*Socket)._onclose("server shutting down")
+	s._nsps.Range(func(_ any, nsp any) bool {
+		nsp.(*Namespace).Sockets().Range(func(_ any, socket any) bool {
+			socket.(*Socket)._onclose("server shutting down")
+			return true
+		})
+		return true
+	})
+
+	s.closeAdapters()
+	s.closeServer(fn)
+}
+
+// Closes the adapters of the namespaces, including the ones of the parent namespaces.
+func (s *Server) closeAdapters() {
+	s._nsps.Range(func(_ any, nsp any) bool {
+		nsp.(*Namespace).Adapter().Close()
 		return true
 	})
+	s.parentNsps.Range(func(_ any, nsp any) bool {
+		nsp.(*ParentNamespace).Adapter().Close()
+		return true
+	})
+}
 
+// Closes the http server, or the engine when socket.io is not attached to a server.
+func (s *Server) closeServer(fn func()) {
 	if s.httpServer != nil {
 		s.httpServer.Close(fn)
+	} else if s.listenerServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		s.listenerServer.Shutdown(ctx)
+		if fn != nil {
+			fn()
+		}
 	} else {
 		s.engine.Close()
 		if fn != nil {
@@ -460,6 +501,12 @@ func (s *Server) Except(room ...Room) *BroadcastOperator {
 	return s.sockets.Except(room...)
 }
 
+// Emits to all clients of the main namespace and waits for their acknowledgements, see
+// BroadcastOperator.EmitWithAck().
+func (s *Server) EmitWithAck(ctx context.Context, ev string, args ...any) ([]any, error) {
+	return s.sockets.EmitWithAck(ctx, ev, args...)
+}
+
 // Sends a `message` event to all clients.
 func (s *Server) Send(args ...any) *Server {
 	s.sockets.Emit("message", args...)
@@ -477,6 +524,24 @@ func (s *Server) ServerSideEmit(ev string, args ...any) error {
 	return s.sockets.ServerSideEmit(ev, args...)
 }
 
+// Sends a message and expect an acknowledgement from the other Socket.IO servers of the cluster.
+//
+//	io.ServerSideEmitWithAck("ping", func(err error, responses []any) {
+//		if err != nil {
+//			// some servers did not acknowledge the event in the given delay
+//		}
+//		fmt.Println(responses) // one response per server (except the current one)
+//	})
+//
+//	// on the other servers
+//	io.On("ping", func(args ...any) {
+//		callback := args[len(args)-1].(func(...any))
+//		callback("pong")
+//	})
+func (s *Server) ServerSideEmitWithAck(ev string, args ...any) error {
+	return s.sockets.ServerSideEmitWithAck(ev, args...)
+}
+
 // Gets a list of socket ids.
 func (s *Server) AllSockets() (*types.Set[SocketId], error) {
 	return s.sockets.AllSockets()
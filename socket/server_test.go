@@ -0,0 +1,349 @@
+package socket_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zishang520/socket.io/client"
+	"github.com/zishang520/socket.io/socket"
+)
+
+// Connects three clients: the first one answers the "poll" event, the second one never does and the third one
+// disconnects instead.
+func pollClients(t *testing.T, urls ...string) []*client.Socket {
+	t.Helper()
+
+	clients := []*client.Socket{}
+	for i := 0; i < 3; i++ {
+		c := connect(t, urls[i%len(urls)], nil)
+		switch i {
+		case 0:
+			c.On("poll", func(args ...any) {
+				args[len(args)-1].(func(...any))("yes")
+			})
+		case 2:
+			c.On("poll", func(...any) {
+				go c.Disconnect()
+			})
+		}
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// Checks the responses of the clients of pollClients(), given their ids.
+func checkResponses(t *testing.T, ids []socket.SocketId, responses *socket.BroadcastResponses) {
+	t.Helper()
+
+	expected := map[socket.SocketId][]any{ids[0]: {"yes"}}
+	if !reflect.DeepEqual(responses.Responses, expected) {
+		t.Errorf("expected the responses %v, got %v", expected, responses.Responses)
+	}
+	if !reflect.DeepEqual(responses.TimedOut, []socket.SocketId{ids[1]}) {
+		t.Errorf("expected %s to time out, got %v", ids[1], responses.TimedOut)
+	}
+	if !reflect.DeepEqual(responses.Disconnected, []socket.SocketId{ids[2]}) {
+		t.Errorf("expected %s to be disconnected, got %v", ids[2], responses.Disconnected)
+	}
+}
+
+func TestEmitWithResponses(t *testing.T) {
+	io, url := newTestServer(t, nil)
+	clients := pollClients(t, url)
+	ids := []socket.SocketId{}
+	for _, c := range clients {
+		ids = append(ids, c.Id())
+	}
+
+	responses, err := io.Timeout(500*time.Millisecond).EmitWithResponses(context.Background(), "poll")
+	if err == nil {
+		t.Fatal("expected the timeout error")
+	}
+	checkResponses(t, ids, responses)
+	for _, id := range ids {
+		if serverId, ok := responses.ServerIds[id]; !ok || serverId != "" {
+			t.Errorf("expected no server id for %s with the in-memory adapter, got %q", id, serverId)
+		}
+	}
+
+	if _, err := io.Local().EmitWithResponses(context.Background(), "disconnect"); err == nil {
+		t.Error("expected an error for a reserved event name")
+	}
+}
+
+func TestEmitWithResponsesCluster(t *testing.T) {
+	transport := socket.NewChannelTransport()
+	servers, urls := []*socket.Server{}, []string{}
+	for i := 0; i < 2; i++ {
+		opts := socket.DefaultServerOptions()
+		opts.SetAdapter(socket.NewClusterAdapter(transport, nil))
+		io, url := newTestServer(t, opts)
+		servers, urls = append(servers, io), append(urls, url)
+	}
+	for deadline := time.Now().Add(2 * time.Second); servers[0].Sockets().Adapter().ServerCount() != 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("the servers did not discover each other")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the first and the third clients are connected to the first server
+	clients := pollClients(t, urls...)
+	ids := []socket.SocketId{}
+	for _, c := range clients {
+		ids = append(ids, c.Id())
+	}
+
+	responses, err := servers[0].Timeout(500*time.Millisecond).EmitWithResponses(context.Background(), "poll")
+	if err == nil {
+		t.Fatal("expected the timeout error")
+	}
+	checkResponses(t, ids, responses)
+	uids := []socket.ServerId{
+		servers[0].Sockets().Adapter().(*socket.ClusterAdapter).Uid(),
+		servers[1].Sockets().Adapter().(*socket.ClusterAdapter).Uid(),
+	}
+	for i, id := range ids {
+		if expected := uids[i%2]; responses.ServerIds[id] != expected {
+			t.Errorf("expected %s to be connected to %s, got %q", id, expected, responses.ServerIds[id])
+		}
+	}
+}
+
+// Checks that a client can connect to the server at the url and exchange an event.
+func checkAttached(t *testing.T, server *socket.Server, url string, opts *client.Options) {
+	t.Helper()
+
+	server.On("connection", func(args ...any) {
+		args[0].(*socket.Socket).On("ping", func(args ...any) {
+			args[len(args)-1].(func(...any))("pong")
+		})
+	})
+	c := connect(t, url, opts)
+	acks := make(chan []any, 1)
+	c.Emit("ping", func(args ...any) {
+		acks <- args
+	})
+	if args := receive(t, acks); !reflect.DeepEqual(args, []any{"pong"}) {
+		t.Errorf("unexpected ack %v", args)
+	}
+}
+
+// Returns the status code and the body of the response to a GET request.
+func get(t *testing.T, httpClient *http.Client, url string) (int, string) {
+	t.Helper()
+
+	res, err := httpClient.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(body)
+}
+
+func TestAttachServeMux(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + listener.Addr().String()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("app"))
+	})
+	httpServer := &http.Server{Handler: mux}
+	go httpServer.Serve(listener)
+	defer httpServer.Close()
+
+	opts := socket.DefaultServerOptions()
+	opts.SetPath("/realtime")
+	io := socket.NewServer(mux, opts)
+	defer io.Close(nil)
+
+	clientOpts := client.DefaultOptions()
+	clientOpts.SetPath("/realtime")
+	checkAttached(t, io, url, clientOpts)
+	if status, body := get(t, http.DefaultClient, url+"/realtime/socket.io.min.js"); status != http.StatusOK || body == "" {
+		t.Errorf("expected the client bundle, got %d", status)
+	}
+	if status, body := get(t, http.DefaultClient, url+"/app"); status != http.StatusOK || body != "app" {
+		t.Errorf("expected the response of the mux, got %d %q", status, body)
+	}
+}
+
+func TestAttachHttpServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + listener.Addr().String()
+	httpServer := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("app " + r.URL.Path))
+	})}
+	io := socket.NewServer(httpServer, nil)
+	defer io.Close(nil)
+	go httpServer.Serve(listener)
+	defer httpServer.Close()
+
+	checkAttached(t, io, url, nil)
+	if status, body := get(t, http.DefaultClient, url+"/socket.io.js"); status != http.StatusOK || body != "app /socket.io.js" {
+		t.Errorf("expected the response of the handler outside of the path, got %d %q", status, body)
+	}
+	if status, body := get(t, http.DefaultClient, url+"/socket.io/socket.io.js"); status != http.StatusOK || !strings.Contains(body, "io") {
+		t.Errorf("expected the client bundle, got %d", status)
+	}
+}
+
+func TestAttachListener(t *testing.T) {
+	io, url := newTestServer(t, nil)
+	checkAttached(t, io, url, nil)
+
+	path := filepath.Join(t.TempDir(), "socket.io.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unixServer := socket.NewServer(listener, nil)
+	defer unixServer.Close(nil)
+
+	httpClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	defer httpClient.CloseIdleConnections()
+	// the open packet of the polling transport
+	if status, body := get(t, httpClient, "http://unix/socket.io/?EIO=4&transport=polling"); status != http.StatusOK || !strings.HasPrefix(body, "0{") || !strings.Contains(body, `"sid":`) {
+		t.Errorf("expected the handshake, got %d %q", status, body)
+	}
+
+	unixServer.Close(nil)
+	if _, err := httpClient.Get("http://unix/socket.io/?EIO=4&transport=polling"); err == nil {
+		t.Error("expected the listener to be closed with the server")
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	transport := socket.NewChannelTransport()
+	opts := socket.DefaultServerOptions()
+	opts.SetAdapter(socket.NewClusterAdapter(transport, nil))
+	io, url := newTestServer(t, opts)
+	// another server of the cluster, which is notified of the closing of the adapter
+	otherOpts := socket.DefaultServerOptions()
+	otherOpts.SetAdapter(socket.NewClusterAdapter(transport, nil))
+	other, _ := newTestServer(t, otherOpts)
+
+	reasons := make(chan any, 1)
+	started := make(chan struct{}, 1)
+	io.On("connection", func(args ...any) {
+		s := args[0].(*socket.Socket)
+		s.On("slow", func(args ...any) {
+			started <- struct{}{}
+			time.Sleep(200 * time.Millisecond)
+			args[len(args)-1].(func(...any))("done")
+		})
+		s.On("disconnect", func(args ...any) {
+			reasons <- args[0]
+		})
+	})
+	c := connect(t, url, nil)
+	events := make(chan any, 2)
+	c.On("disconnect", func(args ...any) {
+		events <- args[0]
+	})
+	for deadline := time.Now().Add(2 * time.Second); other.Sockets().Adapter().ServerCount() != 2; {
+		if time.Now().After(deadline) {
+			t.Fatal("the servers did not discover each other")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Emit("slow", func(args ...any) {
+		events <- args[0]
+	})
+	receive(t, started)
+
+	if err := io.Shutdown(context.Background()); err != nil {
+		t.Fatalf("expected the server to be drained, got %v", err)
+	}
+	// the acknowledgement is sent before the disconnection
+	if v := receive(t, events); v != "done" {
+		t.Errorf("expected the acknowledgement, got %v", v)
+	}
+	if v := receive(t, events); v != "io server disconnect" {
+		t.Errorf("expected the client not to reconnect, got %v", v)
+	}
+	if v := receive(t, reasons); v != "server shutting down" {
+		t.Errorf("unexpected disconnection reason %v", v)
+	}
+	if err := io.Shutdown(context.Background()); err == nil {
+		t.Error("expected an error when shutting down twice")
+	}
+	if other.Sockets().Adapter().ServerCount() != 1 {
+		t.Error("expected the adapter to be closed")
+	}
+	if _, err := http.Get(url + "/socket.io/?EIO=4&transport=polling"); err == nil {
+		t.Error("expected the new connections to be refused")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	opts := socket.DefaultServerOptions()
+	opts.SetReconnectOnShutdown(true)
+	opts.SetReconnectJitter(50 * time.Millisecond)
+	io, url := newTestServer(t, opts)
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	defer close(release)
+	io.On("connection", func(args ...any) {
+		args[0].(*socket.Socket).On("stuck", func(...any) {
+			started <- struct{}{}
+			<-release
+		})
+	})
+
+	clientOpts := client.DefaultOptions()
+	clientOpts.SetReconnection(false)
+	c := connect(t, url, clientOpts)
+	reasons := make(chan any, 1)
+	c.On("disconnect", func(args ...any) {
+		reasons <- args[0]
+	})
+	c.Emit("stuck")
+	receive(t, started)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- io.Shutdown(ctx)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// a new connection to a namespace is refused
+	errs := make(chan any, 1)
+	other := c.Io().Socket("/other", nil)
+	other.On("connect_error", func(args ...any) {
+		errs <- args[0]
+	})
+	other.Connect()
+	if err, ok := receive(t, errs).(error); !ok || err.Error() != "Server shutting down" {
+		t.Errorf("unexpected connect error %v", err)
+	}
+
+	if err := receive(t, done); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	// the connection is closed without disconnecting the sockets, so that the client reconnects to another server
+	if v := receive(t, reasons); v != "transport close" && v != "transport error" {
+		t.Errorf("unexpected disconnection reason %v", v)
+	}
+}
@@ -0,0 +1,119 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zishang520/socket.io/parser"
+)
+
+// How often the clients are checked for pending work while the server is shutting down.
+const shutdownPollInterval = 50 * time.Millisecond
+
+// Gracefully shuts down the server: the new connections and the new connections to a namespace are refused, then each
+// client is closed as soon as its sockets have handled the packets they received and got the acknowledgements they
+// are waiting for. The clients which are still busy once the context is done are closed right away, and the error of
+// the context is returned.
+//
+// The sockets are disconnected with the "server shutting down" reason, so that the clients do not reconnect, unless
+// ServerOptions.SetReconnectOnShutdown() is enabled: the connections are then closed (each one after a random delay
+// of up to ServerOptions.ReconnectJitter()), so that the clients reconnect to another server.
+//
+// The adapters of the namespaces and the http server the Socket.IO server is attached to are closed afterwards.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&s._shuttingDown, 0, 1) {
+		return errors.New("the server is already shutting down")
+	}
+	server_log.Debug("shutting down")
+
+	reconnect := s.opts.ReconnectOnShutdown()
+	jitter := s.opts.ReconnectJitter()
+
+	var wg sync.WaitGroup
+	s.clients.Range(func(_, client any) bool {
+		var delay time.Duration
+		if reconnect && jitter > 0 {
+			delay = time.Duration(rand.Int63n(int64(jitter)))
+		}
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			client.shutdown(ctx, reconnect, delay)
+		}(client.(*Client))
+		return true
+	})
+	wg.Wait()
+
+	err := ctx.Err()
+
+	s.closeAdapters()
+	s.closeServer(nil)
+
+	return err
+}
+
+// Whether Shutdown() has been called.
+func (s *Server) shuttingDown() bool {
+	return atomic.LoadInt32(&s._shuttingDown) == 1
+}
+
+// Closes the client once its sockets are idle (or once the context is done), after the given delay.
+func (c *Client) shutdown(ctx context.Context, reconnect bool, delay time.Duration) {
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for c.busy() && ctx.Err() == nil {
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+
+	client_log.Debug("closing client %s upon shutdown", c.id)
+	if !reconnect {
+		// the clients do not reconnect when their sockets are disconnected by the server
+		c.sockets.Range(func(_, socket any) bool {
+			socket.(*Socket).packet(&parser.Packet{
+				Type: parser.DISCONNECT,
+			}, nil)
+			return true
+		})
+	}
+	c.onclose("server shutting down")
+	// the packets buffered by the engine are flushed before the transport is closed
+	c.conn.Close(false)
+}
+
+// Whether one of the sockets is still handling a packet or waiting for an acknowledgement.
+func (c *Client) busy() (busy bool) {
+	c.sockets.Range(func(_, socket any) bool {
+		busy = socket.(*Socket).busy()
+		return !busy
+	})
+	return busy
+}
+
+// Whether the socket is still handling a packet or waiting for an acknowledgement.
+func (s *Socket) busy() (busy bool) {
+	if s.queue.busy() {
+		return true
+	}
+	s.ackMetas.Range(func(any, any) bool {
+		busy = true
+		return false
+	})
+	return busy
+}
@@ -0,0 +1,49 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// The cause of the cancellation of the context of a socket once it is disconnected, see Socket.Context(). It matches
+// context.Canceled with errors.Is().
+type DisconnectedError struct {
+	// The reason of the disconnection, like "client namespace disconnect" or "transport close"
+	Reason string
+}
+
+func (e *DisconnectedError) Error() string {
+	return fmt.Sprintf("socket has been disconnected: %s", e.Reason)
+}
+
+func (e *DisconnectedError) Unwrap() error {
+	return context.Canceled
+}
+
+// Returns the reason of the disconnection held by the error, or its message.
+func disconnectReason(err error) string {
+	var disconnected *DisconnectedError
+	if errors.As(err, &disconnected) {
+		return disconnected.Reason
+	}
+	return err.Error()
+}
+
+// A context cancelled with the reason of the disconnection of the socket as its cause.
+type socketContext struct {
+	context.Context
+
+	cancel context.CancelCauseFunc
+}
+
+func newSocketContext() *socketContext {
+	c := &socketContext{}
+	c.Context, c.cancel = context.WithCancelCause(context.Background())
+	return c
+}
+
+// Cancels the context, only the first reason is kept.
+func (c *socketContext) close(reason any) {
+	c.cancel(&DisconnectedError{Reason: fmt.Sprint(reason)})
+}
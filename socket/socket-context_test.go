@@ -0,0 +1,53 @@
+package socket_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zishang520/socket.io/socket"
+)
+
+func TestOnCtx(t *testing.T) {
+	io, url := newTestServer(t, nil)
+	type call struct {
+		ctx    context.Context
+		socket *socket.Socket
+		err    error
+	}
+	calls := make(chan *call, 2)
+	io.On("connection", func(args ...any) {
+		s := args[0].(*socket.Socket)
+		s.OnCtx("search", func(ctx context.Context, _ ...any) {
+			calls <- &call{ctx, s, ctx.Err()}
+		})
+	})
+	c := connect(t, url, nil)
+
+	c.Emit("search")
+	c.Emit("search")
+	first, second := receive(t, calls), receive(t, calls)
+	if first.err != nil || second.err != nil {
+		t.Fatal("expected the context to be alive during the handler")
+	}
+	if first.ctx == second.ctx || first.ctx == first.socket.Context() {
+		t.Fatal("expected a context per event")
+	}
+	waitDone(t, first.ctx)
+	if first.socket.Context().Err() != nil {
+		t.Fatal("expected the context of the socket to outlive the event")
+	}
+
+	c.Disconnect()
+	waitDone(t, first.socket.Context())
+}
+
+func waitDone(t *testing.T, ctx context.Context) {
+	t.Helper()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the context is not done")
+	}
+}
@@ -1,6 +1,7 @@
 package socket
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
@@ -17,8 +18,9 @@ import (
 )
 
 var (
-	SOCKET_RESERVED_EVENTS = types.NewSet("connect", "connect_error", "disconnect", "disconnecting", "newListener", "removeListener")
-	socket_log             = log.NewLog("socket.io:socket")
+	SOCKET_RESERVED_EVENTS         = types.NewSet("connect", "connect_error", "disconnect", "disconnecting", "newListener", "removeListener")
+	RECOVERABLE_DISCONNECT_REASONS = types.NewSet("transport error", "transport close", "forced close", "ping timeout", "server shutting down", "forced server close")
+	socket_log                     = log.NewLog("socket.io:socket")
 )
 
 type Handshake struct {
@@ -42,6 +44,13 @@ type Handshake struct {
 	Auth any
 }
 
+type ackMeta struct {
+	sentAt time.Time
+	direct bool
+	// called instead of the ack callback if the socket disconnects first
+	reject func(error)
+}
+
 type Socket struct {
 	*StrictEventEmitter
 
@@ -50,6 +59,13 @@ type Socket struct {
 	id        SocketId
 	handshake *Handshake
 
+	// Private session ID, only used for connection state recovery
+	pid PrivateSessionId
+	// Whether the connection state was recovered after a temporary disconnection. In that case, any missed packets will
+	// be transmitted to the client, the data attribute and the rooms will be restored.
+	recovered     bool
+	missedPackets [][]any
+
 	// Additional information that can be attached to the Socket instance and which will be used in the fetchSockets method
 	data    any
 	data_mu sync.RWMutex
@@ -59,9 +75,14 @@ type Socket struct {
 	canJoin      bool
 	canJoin_mu   sync.RWMutex
 
+	// cancelled upon disconnection
+	ctx *socketContext
+
 	server                *Server
 	adapter               Adapter
 	acks                  *sync.Map
+	ackMetas              *sync.Map
+	queue                 *dispatchQueue
 	fns                   []func([]any, func(error))
 	flags                 *BroadcastFlags
 	_anyListeners         []events.Listener
@@ -85,6 +106,12 @@ func (s *Socket) Client() *Client {
 	return s.client
 }
 
+// Returns the number of bytes buffered for the underlying client, until they are written to the transport, see
+// Client.BufferedAmount(). The sockets of the different namespaces share the same client.
+func (s *Socket) BufferedAmount() int64 {
+	return s.client.BufferedAmount()
+}
+
 func (s *Socket) Acks() *sync.Map {
 	return s.acks
 }
@@ -93,6 +120,12 @@ func (s *Socket) Handshake() *Handshake {
 	return s.handshake
 }
 
+// Whether the connection state was recovered after a temporary disconnection. In that case, any missed packets will
+// be transmitted to the client, the data attribute and the rooms will be restored.
+func (s *Socket) Recovered() bool {
+	return s.recovered
+}
+
 func (s *Socket) Connected() bool {
 	s.connected_mu.RLock()
 	defer s.connected_mu.RUnlock()
@@ -100,6 +133,16 @@ func (s *Socket) Connected() bool {
 	return s.connected
 }
 
+// Returns a context which is cancelled when the socket disconnects. Its error is then context.Canceled, and its cause
+// (see context.Cause()) a *DisconnectedError holding the reason of the disconnection.
+//
+//	socket.On("search", func(args ...any) {
+//		rows, err := db.QueryContext(socket.Context(), "SELECT ...")
+//	})
+func (s *Socket) Context() context.Context {
+	return s.ctx
+}
+
 func (s *Socket) Data() any {
 	s.data_mu.RLock()
 	defer s.data_mu.RUnlock()
@@ -114,7 +157,7 @@ func (s *Socket) SetData(data any) {
 	s.data = data
 }
 
-func NewSocket(nsp *Namespace, client *Client, auth any) *Socket {
+func NewSocket(nsp *Namespace, client *Client, auth any, previousSession *Session) *Socket {
 	s := &Socket{}
 	s.StrictEventEmitter = NewStrictEventEmitter()
 	s.nsp = nsp
@@ -123,20 +166,38 @@ func NewSocket(nsp *Namespace, client *Client, auth any) *Socket {
 	s.data = nil
 	s.connected = false
 	s.canJoin = true
+	s.ctx = newSocketContext()
 	s.acks = &sync.Map{}
+	s.ackMetas = &sync.Map{}
+	s.queue = newDispatchQueue(nsp.Concurrency)
 	s.fns = []func([]any, func(error)){}
 	s.flags = &BroadcastFlags{}
 	s.server = nsp.Server()
 	s.adapter = s.nsp.Adapter()
-	if client.conn.Protocol() == 3 {
-		if name := nsp.Name(); name != "/" {
-			s.id = SocketId(name + "#" + client.id)
-		} else {
-			s.id = SocketId(client.id)
+	if previousSession != nil {
+		s.id = previousSession.Sid
+		s.pid = previousSession.Pid
+		if previousSession.Rooms != nil {
+			s.Join(previousSession.Rooms.Keys()...)
 		}
+		s.data = previousSession.Data
+		s.missedPackets = previousSession.MissedPackets
+		s.recovered = true
 	} else {
-		id, _ := utils.Base64Id().GenerateId()
-		s.id = SocketId(id) // don't reuse the Engine.IO id because it's sensitive information
+		if client.conn.Protocol() == 3 {
+			if name := nsp.Name(); name != "/" {
+				s.id = SocketId(name + "#" + client.id)
+			} else {
+				s.id = SocketId(client.id)
+			}
+		} else {
+			id, _ := utils.Base64Id().GenerateId()
+			s.id = SocketId(id) // don't reuse the Engine.IO id because it's sensitive information
+		}
+		if s.server.opts.ConnectionStateRecovery() != nil {
+			pid, _ := utils.Base64Id().GenerateId()
+			s.pid = PrivateSessionId(pid)
+		}
 	}
 	s.handshake = s.buildHandshake(auth)
 	return s
@@ -157,32 +218,119 @@ func (s *Socket) buildHandshake(auth any) *Handshake {
 	}
 }
 
-// Emits to this client.
+// Emits to this client. If the last argument is an ack callback, it is called with a *DisconnectedError when the
+// socket disconnects before the client answers. With Timeout(), the first argument of the callback is always an error
+// (nil with the response of the client). Without it, the callback receives the arguments of the client, or a
+// *DisconnectedError as its only argument, which cannot be sent by a client:
+//
+//	socket.Emit("hello", func(args ...any) {
+//		if err, ok := args[0].(*socket.DisconnectedError); ok {
+//			// the client has disconnected before answering
+//		}
+//	})
 func (s *Socket) Emit(ev string, args ...any) error {
 	if SOCKET_RESERVED_EVENTS.Has(ev) {
 		return errors.New(fmt.Sprintf(`"%s" is a reserved event name`, ev))
 	}
 	data := append([]any{ev}, args...)
 	data_len := len(data)
+	// access last argument to see if it's an ACK callback
+	if fn, ok := data[data_len-1].(func(...any)); ok {
+		return s.emit(data[:data_len-1], func(id uint64) {
+			s.registerAckCallback(id, fn)
+		})
+	}
+	return s.emit(data, nil)
+}
+
+// Emits an event to this client and waits for its acknowledgement, until the client answers, the context is
+// cancelled or the timeout set with Timeout() is reached.
+//
+//	args, err := socket.Timeout(5 * time.Second).EmitWithAck(ctx, "hello", "world")
+//	if err != nil {
+//	  // the client did not acknowledge the event in the given delay
+//	}
+func (s *Socket) EmitWithAck(ctx context.Context, ev string, args ...any) ([]any, error) {
+	if SOCKET_RESERVED_EVENTS.Has(ev) {
+		return nil, errors.New(fmt.Sprintf(`"%s" is a reserved event name`, ev))
+	}
+
+	s.flags_mu.RLock()
+	timeout := s.flags.Timeout
+	s.flags_mu.RUnlock()
+
+	var id uint64
+	done := make(chan []any, 1)
+	failed := make(chan error, 1)
+	if err := s.emit(append([]any{ev}, args...), func(ackId uint64) {
+		id = ackId
+		s.storeAck(id, func(args ...any) {
+			select {
+			case done <- args:
+			default:
+			}
+		}, func(err error) {
+			failed <- err
+		}, true)
+	}); err != nil {
+		return nil, err
+	}
+
+	var expired <-chan time.Time
+	if timeout != nil {
+		timer := time.NewTimer(*timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case args := <-done:
+		return args, nil
+	case err := <-failed:
+		return nil, err
+	case <-expired:
+		socket_log.Debug("event with ack id %d has timed out after %d ms", id, *timeout/time.Millisecond)
+		s.deleteAck(id)
+		s.server.observeAckTimeout(s.nsp.Name())
+		return nil, errors.New("operation has timed out")
+	case <-ctx.Done():
+		s.deleteAck(id)
+		return nil, ctx.Err()
+	}
+}
+
+// Sends an event packet, with an ack id registered by `register` when it is not nil.
+func (s *Socket) emit(data []any, register func(uint64)) (err error) {
 	packet := &parser.Packet{
 		Type: parser.EVENT,
 		Data: data,
 	}
-	// access last argument to see if it's an ACK callback
-	if fn, ok := data[data_len-1].(func(...any)); ok {
+	if register != nil {
 		id := s.nsp.Ids()
 		socket_log.Debug("emitting packet with ack id %d", id)
-		packet.Data = data[:data_len-1]
-		s.registerAckCallback(id, fn)
+		register(id)
 		packet.Id = &id
+		defer func() {
+			if err != nil {
+				// the packet has not been sent, so it will never be acknowledged
+				s.deleteAck(id)
+			}
+		}()
 	}
 	s.flags_mu.Lock()
 	flags := *s.flags
 	s.flags = &BroadcastFlags{}
 	s.flags_mu.Unlock()
+	if s.server.opts.ConnectionStateRecovery() != nil {
+		// this ensures the packet is stored and can be transmitted upon reconnection
+		return s.adapter.Broadcast(packet, &BroadcastOptions{
+			Rooms:  types.NewSet(Room(s.id)),
+			Except: types.NewSet[Room](),
+			Flags:  &flags,
+		})
+	}
 	s.notifyOutgoingListeners(packet)
-	s.packet(packet, &flags)
-	return nil
+	return s.packet(packet, &flags)
 }
 
 func (s *Socket) registerAckCallback(id uint64, ack func(...any)) {
@@ -190,20 +338,74 @@ func (s *Socket) registerAckCallback(id uint64, ack func(...any)) {
 	timeout := s.flags.Timeout
 	s.flags_mu.RUnlock()
 	if timeout == nil {
-		s.acks.Store(id, ack)
+		// the client cannot send a *DisconnectedError, so the rejection is told apart from a response by its type
+		s.storeAck(id, ack, func(err error) {
+			ack(err)
+		}, false)
 		return
 	}
 	timer := utils.SetTimeOut(func() {
+		defer s._recover(nil)
+
+		// the ack may have been called, or rejected, in the meantime
+		if !s.deleteAck(id) {
+			return
+		}
 		socket_log.Debug("event with ack id %d has timed out after %d ms", id, *timeout/time.Millisecond)
-		s.acks.Delete(id)
+		s.server.observeAckTimeout(s.nsp.Name())
 		ack(errors.New("operation has timed out"))
 	}, *timeout)
-	s.acks.Store(id, func(args ...any) {
+	s.storeAck(id, func(args ...any) {
 		utils.ClearTimeout(timer)
 		ack(append([]any{nil}, args...)...)
+	}, func(err error) {
+		utils.ClearTimeout(timer)
+		ack(err)
+	}, false)
+}
+
+// Stores an ack callback, along with the time the packet is sent at. The direct acks (the ones of EmitWithAck() and of
+// the broadcasts) only unblock a waiting goroutine or collect the responses of several sockets, so they are started
+// once the packets received before them have been started, without waiting for them to be done: an event listener can
+// wait for them without blocking the dispatch of the socket.
+//
+// The `reject` function is called instead of the ack callback if the socket disconnects first.
+func (s *Socket) storeAck(id uint64, ack func(...any), reject func(error), direct bool) {
+	s.ackMetas.Store(id, &ackMeta{sentAt: time.Now(), direct: direct, reject: reject})
+	s.acks.Store(id, ack)
+}
+
+// Removes an ack callback which is not expected anymore, returns false if it was already removed.
+func (s *Socket) deleteAck(id uint64) bool {
+	_, ok := s.acks.LoadAndDelete(id)
+	s.ackMetas.Delete(id)
+	return ok
+}
+
+// Calls the `reject` function of each pending ack callback, since the client will not answer anymore.
+func (s *Socket) rejectAcks(err error) {
+	s.ackMetas.Range(func(id, meta any) bool {
+		if _, ok := s.acks.LoadAndDelete(id); ok {
+			s.ackMetas.Delete(id)
+			socket_log.Debug("rejecting ack %d", id)
+			func() {
+				defer s._recover(nil)
+
+				meta.(*ackMeta).reject(err)
+			}()
+		}
+		return true
 	})
 }
 
+// Whether the ack callback is called without waiting for the packets received before to be done.
+func (s *Socket) isDirectAck(id uint64) bool {
+	if meta, ok := s.ackMetas.Load(id); ok {
+		return meta.(*ackMeta).direct
+	}
+	return false
+}
+
 // Targets a room when broadcasting.
 func (s *Socket) To(room ...Room) *BroadcastOperator {
 	return s.newBroadcastOperator().To(room...)
@@ -232,13 +434,13 @@ func (s *Socket) Write(args ...any) *Socket {
 }
 
 // Writes a packet.
-func (s *Socket) packet(packet *parser.Packet, opts *BroadcastFlags) {
+func (s *Socket) packet(packet *parser.Packet, opts *BroadcastFlags) error {
 	packet.Nsp = s.nsp.Name()
 	if opts == nil {
 		opts = &BroadcastFlags{}
 	}
 	opts.Compress = false != opts.Compress
-	s.client._packet(packet, &opts.WriteOptions)
+	return s.client._packet(packet, &opts.WriteOptions)
 }
 
 // Joins a room.
@@ -282,45 +484,83 @@ func (s *Socket) _onconnect() {
 			Type: parser.CONNECT,
 		}, nil)
 	} else {
+		data := map[string]any{
+			"sid": s.id,
+		}
+		if s.pid != "" {
+			data["pid"] = s.pid
+		}
 		s.packet(&parser.Packet{
 			Type: parser.CONNECT,
-			Data: map[string]any{
-				"sid": s.id,
-			},
+			Data: data,
 		}, nil)
 	}
+	if s.recovered {
+		socket_log.Debug("transmitting %d missed packets", len(s.missedPackets))
+		for _, missedPacket := range s.missedPackets {
+			s.packet(&parser.Packet{
+				Type: parser.EVENT,
+				Data: missedPacket,
+			}, nil)
+		}
+		s.missedPackets = nil
+	}
 }
 
 // Called with each packet. Called by `Client`.
+//
+// The packets are started in the order they were received, up to the concurrency of the namespace.
 func (s *Socket) _onpacket(packet *parser.Packet) {
 	socket_log.Debug("got packet %v", packet)
-	switch packet.Type {
-	case parser.EVENT:
-		s.onevent(packet)
-		break
-	case parser.BINARY_EVENT:
-		s.onevent(packet)
-		break
-	case parser.ACK:
-		s.onack(packet)
-		break
-	case parser.BINARY_ACK:
-		s.onack(packet)
-		break
-	case parser.DISCONNECT:
-		s.ondisconnect()
-		break
+	if (packet.Type == parser.ACK || packet.Type == parser.BINARY_ACK) && packet.Id != nil && s.isDirectAck(*packet.Id) {
+		// the listeners may be waiting for these acknowledgements
+		s.queue.pushInline(func() {
+			s.onack(packet)
+		})
+		return
 	}
+	s.queue.push(func(done func()) {
+		switch packet.Type {
+		case parser.EVENT:
+			s.onevent(packet, done)
+			return
+		case parser.BINARY_EVENT:
+			s.onevent(packet, done)
+			return
+		case parser.ACK:
+			s.onack(packet)
+			break
+		case parser.BINARY_ACK:
+			s.onack(packet)
+			break
+		case parser.DISCONNECT:
+			s.ondisconnect()
+			break
+		}
+		done()
+	})
 }
 
 // Called upon event packet.
-func (s *Socket) onevent(packet *parser.Packet) {
+func (s *Socket) onevent(packet *parser.Packet, done func()) {
 	args := packet.Data.([]any)
 	socket_log.Debug("emitting event %v", args)
+	var ack func(...any)
 	if nil != packet.Id {
 		socket_log.Debug("attaching ack callback to event")
-		args = append(args, s.ack(*packet.Id))
+		ack = s.ack(*packet.Id)
+		args = append(args, ack)
+	}
+	// answers the client, which would otherwise wait for the acknowledgement until its timeout
+	reject := func(err error) {
+		if ack != nil && s.server.opts.AckOnError() {
+			ack(ackError(err))
+		}
 	}
+	defer s._recover(func(err *PanicError) {
+		reject(err)
+		done()
+	})
 	s._anyListeners_mu.RLock()
 	if s._anyListeners != nil && len(s._anyListeners) > 0 {
 		listeners := append([]events.Listener{}, s._anyListeners[:]...)
@@ -331,7 +571,7 @@ func (s *Socket) onevent(packet *parser.Packet) {
 	} else {
 		s._anyListeners_mu.RUnlock()
 	}
-	s.dispatch(args)
+	s.dispatch(args, reject, done)
 }
 
 // Produces an ack callback to emit with an event.
@@ -353,10 +593,13 @@ func (s *Socket) ack(id uint64) func(...any) {
 // Called upon ack packet.
 func (s *Socket) onack(packet *parser.Packet) {
 	if packet.Id != nil {
-		if ack, ok := s.acks.Load(*packet.Id); ok {
+		if ack, ok := s.acks.LoadAndDelete(*packet.Id); ok {
 			socket_log.Debug("calling ack %d with %v", *packet.Id, packet.Data)
+			if meta, ok := s.ackMetas.LoadAndDelete(*packet.Id); ok {
+				s.server.observeAck(s.nsp.Name(), time.Since(meta.(*ackMeta).sentAt))
+			}
+			defer s._recover(nil)
 			(ack.(func(...any)))(packet.Data.([]any)...)
-			s.acks.Delete(*packet.Id)
 		} else {
 			socket_log.Debug("bad ack %d", *packet.Id)
 		}
@@ -371,11 +614,16 @@ func (s *Socket) ondisconnect() {
 	s._onclose("client namespace disconnect")
 }
 
-// Handles a client error.
+// Handles a client error, which is also passed to the ErrorHandler of the server.
 func (s *Socket) _onerror(err any) {
+	e, ok := err.(error)
+	if !ok {
+		e = errors.New(fmt.Sprint(err))
+	}
+	handled := s.server._onerror(s, e)
 	if s.ListenerCount("error") > 0 {
 		s.EmitReserved("error", err)
-	} else {
+	} else if !handled {
 		utils.Log().Error("Missing error handler on `socket`.")
 		utils.Log().Error("%v", err)
 	}
@@ -389,12 +637,25 @@ func (s *Socket) _onclose(reason any) *Socket {
 
 	socket_log.Debug("closing socket - reason %v", reason)
 	s.EmitReserved("disconnecting", reason)
+	if r, ok := reason.(string); ok && s.pid != "" && RECOVERABLE_DISCONNECT_REASONS.Has(r) {
+		socket_log.Debug("connection state recovery is enabled for sid %s", s.id)
+		s.adapter.PersistSession(&SessionToPersist{
+			Sid:   s.id,
+			Pid:   s.pid,
+			Rooms: types.NewSet(s.Rooms().Keys()...),
+			Data:  s.Data(),
+		})
+	}
 	s._cleanup()
+	// the packets which are still queued are not dispatched after the disconnection
+	s.queue.clear()
 	s.nsp._remove(s)
 	s.client._remove(s)
 	s.connected_mu.Lock()
 	s.connected = false
 	s.connected_mu.Unlock()
+	s.ctx.close(reason)
+	s.rejectAcks(context.Cause(s.ctx))
 	s.EmitReserved("disconnect", reason)
 	return nil
 }
@@ -481,12 +742,19 @@ func (s *Socket) Timeout(timeout time.Duration) *Socket {
 	return s
 }
 
-// Dispatch incoming event to socket listeners.
-func (s *Socket) dispatch(event []any) {
+// Dispatch incoming event to socket listeners, then calls `done`. The errors are also passed to `reject`.
+func (s *Socket) dispatch(event []any, reject func(error), done func()) {
 	socket_log.Debug("dispatching an event %v", event)
 	s.run(event, func(err error) {
+		defer done()
+		// the middlewares may call next() from another goroutine
+		defer s._recover(func(err *PanicError) {
+			reject(err)
+		})
+
 		if err != nil {
 			s._onerror(err)
+			reject(err)
 			return
 		}
 		if s.Connected() {
@@ -514,15 +782,33 @@ func (s *Socket) run(event []any, fn func(err error)) {
 	if length := len(fns); length > 0 {
 		var run func(i int)
 		run = func(i int) {
+			called := int32(0)
+			// a panicking middleware rejects the event, unless it has already called next(). The panics of the next
+			// middlewares are recovered here too when next() is called from another goroutine.
+			defer func() {
+				if r := recover(); r != nil {
+					err := newPanicError(r)
+					socket_log.Debug("middleware panicked: %v", err)
+					if atomic.CompareAndSwapInt32(&called, 0, 1) {
+						fn(err)
+					} else {
+						s._onerror(err)
+					}
+				}
+			}()
 			fns[i](event, func(err error) {
+				if !atomic.CompareAndSwapInt32(&called, 0, 1) {
+					socket_log.Debug("next called twice by the middleware %d - ignoring", i)
+					return
+				}
 				// upon error, short-circuit
 				if err != nil {
-					go fn(err)
+					fn(err)
 					return
 				}
 				// if no middleware left, summon callback
-				if i >= length {
-					go fn(nil)
+				if i+1 >= length {
+					fn(nil)
 					return
 				}
 				// go on to next
@@ -531,7 +817,7 @@ func (s *Socket) run(event []any, fn func(err error)) {
 		}
 		run(0)
 	} else {
-		go fn(nil)
+		fn(nil)
 	}
 }
 
@@ -557,6 +843,44 @@ func (s *Socket) Rooms() *types.Set[Room] {
 	return types.NewSet[Room]()
 }
 
+// Adds the `handlers` functions as event listeners for `ev`, which receive a context along with the arguments of the
+// event. The context is derived from the one of the socket (see Socket.Context()) for each event, and is cancelled
+// once the handler returns or the socket disconnects: the goroutines outliving the handler should use Socket.Context().
+//
+//	socket.OnCtx("search", func(ctx context.Context, args ...any) {
+//		rows, err := db.QueryContext(ctx, "SELECT ...")
+//	})
+func (s *Socket) OnCtx(ev string, handlers ...func(context.Context, ...any)) error {
+	listeners := make([]events.Listener, 0, len(handlers))
+	for _, handler := range handlers {
+		handler := handler
+		listeners = append(listeners, func(args ...any) {
+			ctx, cancel := context.WithCancel(s.ctx)
+			defer cancel()
+
+			handler(ctx, args...)
+		})
+	}
+	return s.On(ev, listeners...)
+}
+
+// Adds a typed `handler` function as an event listener for `ev`, see StrictEventEmitter.OnTyped().
+//
+//	socket.OnTyped("chat", func(msg ChatMessage, ack func(Reply)) {
+//		ack(Reply{Ok: true})
+//	})
+//
+// The arguments which can not be decoded are reported with the "error" event of the socket.
+func (s *Socket) OnTyped(ev string, handler any) error {
+	listener, err := typedListener(ev, handler, func(err error) {
+		s._onerror(err)
+	})
+	if err != nil {
+		return err
+	}
+	return s.On(ev, listener)
+}
+
 // Adds a listener that will be fired when any event is received. The event name is passed as the first argument to
 // the callback.
 func (s *Socket) OnAny(listener events.Listener) *Socket {
@@ -0,0 +1,106 @@
+package socket
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSocketMiddlewares(t *testing.T) {
+	s := &Socket{}
+
+	calls := []int{}
+	for i := 0; i < 3; i++ {
+		i := i
+		s.Use(func(_ []any, next func(error)) {
+			calls = append(calls, i)
+			next(nil)
+		})
+	}
+
+	called := false
+	s.run([]any{"ev"}, func(err error) {
+		called = true
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+	if !called || len(calls) != 3 {
+		t.Fatalf("expected 3 middlewares to run before the callback, got %v", calls)
+	}
+
+	s.Use(func(_ []any, next func(error)) {
+		next(errors.New("rejected"))
+	})
+	s.run([]any{"ev"}, func(err error) {
+		if err == nil {
+			t.Fatal("expected the error of the last middleware")
+		}
+	})
+}
+
+func TestSocketContext(t *testing.T) {
+	ctx := newSocketContext()
+	child, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	ctx.close("transport close")
+	ctx.close("server namespace disconnect")
+
+	<-child.Done()
+	for _, c := range []context.Context{ctx, child} {
+		if c.Err() != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", c.Err())
+		}
+		var disconnected *DisconnectedError
+		if cause := context.Cause(c); !errors.As(cause, &disconnected) || disconnected.Reason != "transport close" {
+			t.Fatalf("expected the first reason as cause, got %v", cause)
+		}
+	}
+}
+
+func TestSocketRejectAcks(t *testing.T) {
+	s := &Socket{acks: &sync.Map{}, ackMetas: &sync.Map{}, flags: &BroadcastFlags{}}
+
+	calls := [][]any{}
+	s.registerAckCallback(1, func(args ...any) {
+		calls = append(calls, args)
+	})
+	s.rejectAcks(&DisconnectedError{Reason: "transport close"})
+	s.rejectAcks(&DisconnectedError{Reason: "transport close"})
+
+	if len(calls) != 1 || len(calls[0]) != 1 {
+		t.Fatalf("expected one call with a single argument, got %v", calls)
+	}
+	if _, ok := calls[0][0].(*DisconnectedError); !ok {
+		t.Fatalf("expected a *DisconnectedError, got %v", calls[0][0])
+	}
+}
+
+func TestSocketMiddlewarePanic(t *testing.T) {
+	s := &Socket{}
+
+	// the next middleware runs in the goroutine of the first one
+	s.Use(func(_ []any, next func(error)) {
+		go next(nil)
+	})
+	s.Use(func([]any, func(error)) {
+		panic("middleware failure")
+	})
+
+	done := make(chan error, 1)
+	s.run([]any{"ev"}, func(err error) {
+		done <- err
+	})
+	select {
+	case err := <-done:
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) {
+			t.Fatalf("expected a *PanicError, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the callback was not called")
+	}
+}
@@ -1,6 +1,7 @@
 package socket
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -14,6 +15,26 @@ type SocketId string
 
 type Room string
 
+// A private ID, sent by the server at the beginning of the Socket.IO session and used for connection state recovery
+// upon reconnection
+type PrivateSessionId string
+
+// The unique ID of a Socket.IO server in a cluster
+type ServerId string
+
+type SessionToPersist struct {
+	Sid   SocketId
+	Pid   PrivateSessionId
+	Rooms *types.Set[Room]
+	Data  any
+}
+
+type Session struct {
+	*SessionToPersist
+
+	MissedPackets [][]any
+}
+
 type WriteOptions struct {
 	packet.Options
 
@@ -37,7 +58,41 @@ type BroadcastOptions struct {
 	Flags  *BroadcastFlags
 }
 
+// The clients a broadcast was sent to by one Socket.IO server of the cluster, see Adapter.BroadcastWithAck().
+type BroadcastClients struct {
+	// The Socket.IO server, empty with the in-memory adapter
+	ServerId ServerId
+	// The number of clients, which may exceed the number of Sids when the server does not send them
+	Count uint64
+	Sids  []SocketId
+}
+
+// The acknowledgement of a broadcast by one client, see Adapter.BroadcastWithAck().
+type BroadcastAck struct {
+	// The socket, empty when the server does not send it
+	Sid SocketId
+	// The arguments of the acknowledgement
+	Args []any
+	// Set instead of the arguments when the client has disconnected before answering
+	Err error
+}
+
+// The acknowledgements of a broadcast, by socket, see BroadcastOperator.EmitWithResponses().
+type BroadcastResponses struct {
+	// The arguments of the acknowledgement of each socket which has answered
+	Responses map[SocketId][]any
+	// The sockets which have not answered in time
+	TimedOut []SocketId
+	// The sockets which have disconnected before answering
+	Disconnected []SocketId
+	// The Socket.IO server each socket is connected to, empty with the in-memory adapter
+	ServerIds map[SocketId]ServerId
+}
+
 type Adapter interface {
+	// Emits the "create-room", "join-room", "leave-room" and "delete-room" events
+	events.EventEmitter
+
 	New(NamespaceInterface) Adapter
 
 	Rooms() *sync.Map
@@ -62,22 +117,27 @@ type Adapter interface {
 	// Removes a socket from all rooms it's joined.
 	DelAll(SocketId)
 
-	SetBroadcast(func(*parser.Packet, *BroadcastOptions))
+	SetBroadcast(func(*parser.Packet, *BroadcastOptions) error)
 	// Broadcasts a packet.
 	//
 	// Options:
 	//  - `Flags` {*BroadcastFlags} flags for this packet
 	//  - `Except` {*types.Set[Room]} sids that should be excluded
 	//  - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
-	Broadcast(*parser.Packet, *BroadcastOptions)
+	//
+	// Returns a *parser.EncodeError when the packet cannot be encoded, in which case it is sent to no client.
+	Broadcast(*parser.Packet, *BroadcastOptions) error
 
-	// Broadcasts a packet and expects multiple acknowledgements.
+	// Broadcasts a packet and expects multiple acknowledgements. The first callback is called by each Socket.IO server
+	// of the cluster with the clients the packet was sent to, the second one with the acknowledgement of each client.
 	//
 	// Options:
 	//  - `Flags` {*BroadcastFlags} flags for this packet
 	//  - `Except` {*types.Set[Room]} sids that should be excluded
 	//  - `Rooms` {*types.Set[Room]} list of rooms to broadcast to
-	BroadcastWithAck(*parser.Packet, *BroadcastOptions, func(uint64), func(...any))
+	//
+	// Returns a *parser.EncodeError when the packet cannot be encoded, in which case none of the callbacks is called.
+	BroadcastWithAck(*parser.Packet, *BroadcastOptions, func(*BroadcastClients), func(*BroadcastAck)) error
 
 	// Gets a list of sockets by sid.
 	Sockets(*types.Set[Room]) *types.Set[SocketId]
@@ -97,8 +157,24 @@ type Adapter interface {
 	// Makes the matching socket instances disconnect
 	DisconnectSockets(*BroadcastOptions, bool)
 
-	// Send a packet to the other Socket.IO servers in the cluster
+	// Send a packet to the other Socket.IO servers in the cluster. If the last argument is a func(error, []any), it is
+	// called with the responses of the other servers, as expected from ServerCount().
 	ServerSideEmit(string, ...any) error
+
+	// Save the client session in order to restore it upon reconnection.
+	PersistSession(*SessionToPersist)
+
+	// Restore the session and find the packets that were missed by the client.
+	RestoreSession(PrivateSessionId, string) (*Session, error)
+}
+
+// The transport between the Socket.IO servers of a cluster, used by the ClusterAdapter.
+type ClusterTransport interface {
+	// Sends a message to every subscriber of the channel.
+	Publish(string, []byte) error
+
+	// Calls the handler for each message published on the channel, until the returned function is called.
+	Subscribe(string, func([]byte)) (func(), error)
 }
 
 type SocketDetails interface {
@@ -126,6 +202,12 @@ type NamespaceInterface interface {
 	// Sets up namespace middleware.
 	Use(func(*Socket, func(*ExtendedError))) NamespaceInterface
 
+	// Sets how many packets of a socket can be handled at the same time.
+	SetConcurrency(int) NamespaceInterface
+
+	// Returns how many packets of a socket can be handled at the same time.
+	Concurrency() int
+
 	// Targets a room when emitting.
 	To(...Room) *BroadcastOperator
 
@@ -141,6 +223,9 @@ type NamespaceInterface interface {
 	// Emits to all clients.
 	Emit(string, ...any) error
 
+	// Emits to all clients and waits for their acknowledgements.
+	EmitWithAck(context.Context, string, ...any) ([]any, error)
+
 	// Sends a `message` event to all clients.
 	Send(...any) NamespaceInterface
 
@@ -150,6 +235,9 @@ type NamespaceInterface interface {
 	// Emit a packet to other Socket.IO servers
 	ServerSideEmit(string, ...any) error
 
+	// Sends a message and expect an acknowledgement from the other Socket.IO servers of the cluster.
+	ServerSideEmitWithAck(string, ...any) error
+
 	// Gets a list of clients.
 	AllSockets() (*types.Set[SocketId], error)
 
@@ -1,9 +1,21 @@
 package socket
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"github.com/mitchellh/mapstructure"
 	"github.com/zishang520/engine.io/events"
 )
 
+var (
+	bytesType  = reflect.TypeOf([]byte{})
+	numberType = reflect.TypeOf(json.Number(""))
+)
+
 // Strictly typed version of an `EventEmitter`. A `TypedEventEmitter` takes type
 // parameters for mappings of event names to event data types, and strictly
 // types method calls to the `EventEmitter` according to these event maps.
@@ -26,6 +38,33 @@ func (s *StrictEventEmitter) Once(ev string, listeners ...events.Listener) error
 	return s.EventEmitter.Once(events.EventName(ev), listeners...)
 }
 
+// Adds a typed `handler` function as an event listener for `ev`.
+//
+// Each positional argument of the event is decoded into the type of the matching parameter of the handler, with the
+// `json` tags of the struct fields. If the event comes with an acknowledgement, it is passed to the last parameter of
+// the handler when it is a function:
+//
+//	type ChatMessage struct {
+//		Text string `json:"text"`
+//	}
+//
+//	socket.OnTyped("chat", func(msg ChatMessage, ack func(string)) {
+//		ack("received " + msg.Text)
+//	})
+//
+// The arguments which can not be decoded are reported with an "error" event, and the handler is not called.
+func (s *StrictEventEmitter) OnTyped(ev string, handler any) error {
+	listener, err := typedListener(ev, handler, func(err error) {
+		if s.ListenerCount("error") > 0 {
+			s.EmitReserved("error", err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return s.On(ev, listener)
+}
+
 // Emits an event.
 func (s *StrictEventEmitter) Emit(ev string, args ...any) {
 	s.EventEmitter.Emit(events.EventName(ev), args...)
@@ -52,3 +91,151 @@ func (s *StrictEventEmitter) EmitUntyped(ev string, args ...any) {
 func (s *StrictEventEmitter) Listeners(ev string) []events.Listener {
 	return s.EventEmitter.Listeners(events.EventName(ev))
 }
+
+// Wraps a typed handler into a listener, which decodes its arguments and reports the failures to `onerror`.
+func typedListener(ev string, handler any, onerror func(error)) (events.Listener, error) {
+	fn := reflect.ValueOf(handler)
+	if fn.Kind() != reflect.Func {
+		return nil, errors.New(fmt.Sprintf(`the handler of "%s" must be a function, got %T`, ev, handler))
+	}
+	fnType := fn.Type()
+
+	// a trailing function parameter receives the acknowledgement callback
+	numIn := fnType.NumIn()
+	withAck := numIn > 0 && !fnType.IsVariadic() && fnType.In(numIn-1).Kind() == reflect.Func
+
+	return func(args ...any) {
+		var ack func(...any)
+		if len(args) > 0 {
+			if f, ok := args[len(args)-1].(func(...any)); ok {
+				ack = f
+				args = args[:len(args)-1]
+			}
+		}
+
+		params := numIn
+		if withAck {
+			params--
+		}
+		in := make([]reflect.Value, 0, numIn)
+		for i := 0; i < params; i++ {
+			paramType := fnType.In(i)
+			if fnType.IsVariadic() && i == numIn-1 {
+				elemType := paramType.Elem()
+				for j := i; j < len(args); j++ {
+					value, err := decodeTypedArg(args[j], elemType)
+					if err != nil {
+						onerror(errors.New(fmt.Sprintf(`"%s": cannot decode argument %d into %v: %v`, ev, j, elemType, err)))
+						return
+					}
+					in = append(in, value)
+				}
+				break
+			}
+			if i >= len(args) {
+				in = append(in, reflect.Zero(paramType))
+				continue
+			}
+			value, err := decodeTypedArg(args[i], paramType)
+			if err != nil {
+				onerror(errors.New(fmt.Sprintf(`"%s": cannot decode argument %d into %v: %v`, ev, i, paramType, err)))
+				return
+			}
+			in = append(in, value)
+		}
+		if withAck {
+			in = append(in, typedAck(ack, fnType.In(numIn-1)))
+		}
+
+		fn.Call(in)
+	}, nil
+}
+
+// Decodes an argument of an event into a value of the given type.
+func decodeTypedArg(arg any, t reflect.Type) (reflect.Value, error) {
+	if arg == nil {
+		return reflect.Zero(t), nil
+	}
+	if v := reflect.ValueOf(arg); v.Type().AssignableTo(t) {
+		return v, nil
+	}
+
+	result := reflect.New(t)
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(decodeBinaryHook, decodeNumberHook),
+		Result:     result.Interface(),
+		TagName:    "json",
+	})
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if err := decoder.Decode(arg); err != nil {
+		return reflect.Value{}, err
+	}
+	return result.Elem(), nil
+}
+
+// Converts the binary attachments of a packet into []byte, or into a named byte slice type.
+func decodeBinaryHook(from reflect.Type, to reflect.Type, data any) (any, error) {
+	if to.Kind() == reflect.Slice && bytesType.ConvertibleTo(to) {
+		if buffer, ok := data.(interface{ Bytes() []byte }); ok {
+			if to == bytesType {
+				return buffer.Bytes(), nil
+			}
+			return reflect.ValueOf(buffer.Bytes()).Convert(to).Interface(), nil
+		}
+	}
+	return data, nil
+}
+
+// Converts the numbers into json.Number, whatever the number mode of the parser. The json.Number values are converted
+// into the other numeric types by mapstructure itself.
+func decodeNumberHook(from reflect.Type, to reflect.Type, data any) (any, error) {
+	if to != numberType {
+		return data, nil
+	}
+	switch tdata := data.(type) {
+	case int64:
+		return json.Number(strconv.FormatInt(tdata, 10)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(tdata, 10)), nil
+	case float64:
+		return json.Number(strconv.FormatFloat(tdata, 'g', -1, 64)), nil
+	}
+	return data, nil
+}
+
+// Adapts the acknowledgement callback of an event to the function type expected by a typed handler.
+func typedAck(ack func(...any), t reflect.Type) reflect.Value {
+	if ack == nil {
+		// no acknowledgement was requested by the sender
+		return reflect.MakeFunc(t, func([]reflect.Value) []reflect.Value {
+			return zeroResults(t)
+		})
+	}
+	if reflect.TypeOf(ack).AssignableTo(t) {
+		return reflect.ValueOf(ack)
+	}
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		args := []any{}
+		for i, v := range in {
+			if t.IsVariadic() && i == len(in)-1 {
+				for j := 0; j < v.Len(); j++ {
+					args = append(args, v.Index(j).Interface())
+				}
+				break
+			}
+			args = append(args, v.Interface())
+		}
+		ack(args...)
+		return zeroResults(t)
+	})
+}
+
+func zeroResults(t reflect.Type) []reflect.Value {
+	results := make([]reflect.Value, t.NumOut())
+	for i := range results {
+		results[i] = reflect.Zero(t.Out(i))
+	}
+	return results
+}
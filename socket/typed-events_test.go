@@ -0,0 +1,125 @@
+package socket_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zishang520/engine.io/types"
+	"github.com/zishang520/socket.io/socket"
+)
+
+type chatMessage struct {
+	Text  string   `json:"text"`
+	Count int      `json:"count"`
+	Tags  []string `json:"tags"`
+	Data  []byte   `json:"data"`
+}
+
+type chatReply struct {
+	Ok bool `json:"ok"`
+}
+
+func TestOnTyped(t *testing.T) {
+	emitter := socket.NewStrictEventEmitter()
+
+	var message chatMessage
+	var id json.Number
+	if err := emitter.OnTyped("chat", func(msg chatMessage, n json.Number, ack func(chatReply)) {
+		message, id = msg, n
+		ack(chatReply{Ok: true})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var acked []any
+	emitter.Emit("chat", map[string]any{
+		"text":  "hello",
+		"count": float64(2),
+		"tags":  []any{"a", "b"},
+		"data":  types.NewBytesBuffer([]byte{1, 2}),
+	}, int64(9007199254740993), func(args ...any) {
+		acked = args
+	})
+	expected := chatMessage{Text: "hello", Count: 2, Tags: []string{"a", "b"}, Data: []byte{1, 2}}
+	if !reflect.DeepEqual(message, expected) {
+		t.Errorf("expected %+v, got %+v", expected, message)
+	}
+	if id != "9007199254740993" {
+		t.Errorf("expected the integer to keep its precision, got %s", id)
+	}
+	if !reflect.DeepEqual(acked, []any{chatReply{Ok: true}}) {
+		t.Errorf("unexpected acknowledgement %v", acked)
+	}
+
+	// without acknowledgement requested nor second argument
+	emitter.Emit("chat", map[string]any{"text": "no ack"})
+	if message.Text != "no ack" || id != "" {
+		t.Errorf("unexpected arguments %+v, %q", message, id)
+	}
+
+	called := false
+	var prefix string
+	var values []float64
+	emitter.OnTyped("sum", func(p string, v ...float64) {
+		called = true
+		prefix, values = p, v
+	})
+	emitter.Emit("sum", "total", float64(1), int64(2))
+	if !called || prefix != "total" || !reflect.DeepEqual(values, []float64{1, 2}) {
+		t.Errorf("unexpected variadic arguments %q, %v", prefix, values)
+	}
+}
+
+func TestOnTypedErrors(t *testing.T) {
+	emitter := socket.NewStrictEventEmitter()
+
+	if err := emitter.OnTyped("chat", 3); err == nil {
+		t.Error("expected an error for a handler which is not a function")
+	}
+
+	var errs []error
+	emitter.On("error", func(args ...any) {
+		errs = append(errs, args[0].(error))
+	})
+	called := false
+	emitter.OnTyped("chat", func(chatMessage) {
+		called = true
+	})
+	emitter.Emit("chat", "not a message")
+	if called {
+		t.Error("the handler was called with an argument which can not be decoded")
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), `"chat": cannot decode argument 0`) {
+		t.Errorf("expected a decoding error, got %v", errs)
+	}
+}
+
+func TestSocketOnTyped(t *testing.T) {
+	io, url := newTestServer(t, nil)
+	errs := make(chan error, 1)
+	io.On("connection", func(args ...any) {
+		s := args[0].(*socket.Socket)
+		s.On("error", func(args ...any) {
+			errs <- args[0].(error)
+		})
+		s.OnTyped("chat", func(msg chatMessage, ack func(chatReply)) {
+			ack(chatReply{Ok: msg.Text == "hello"})
+		})
+	})
+
+	c := connect(t, url, nil)
+	acks := make(chan []any, 1)
+	c.Emit("chat", map[string]any{"text": "hello", "data": []byte{1}}, func(args ...any) {
+		acks <- args
+	})
+	if args := receive(t, acks); !reflect.DeepEqual(args, []any{map[string]any{"ok": true}}) {
+		t.Errorf("unexpected acknowledgement %v", args)
+	}
+
+	c.Emit("chat", []any{1})
+	if err := receive(t, errs); !strings.Contains(err.Error(), "cannot decode argument 0") {
+		t.Errorf("unexpected error %v", err)
+	}
+}